@@ -10,8 +10,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/siohaza/fosilo/internal/accounts"
+	"github.com/siohaza/fosilo/internal/bans"
 	"github.com/siohaza/fosilo/internal/server"
 	"github.com/siohaza/fosilo/pkg/config"
+	"github.com/siohaza/fosilo/pkg/lua"
 
 	"github.com/spf13/cobra"
 )
@@ -19,6 +22,7 @@ import (
 var (
 	configPath string
 	logLevel   string
+	banFormat  string
 	version    = "0.1.0"
 )
 
@@ -38,6 +42,60 @@ var startCmd = &cobra.Command{
 	Run:   runServer,
 }
 
+var replayCmd = &cobra.Command{
+	Use:   "replay <demo>",
+	Short: "Host a recorded demo",
+	Long: `Host a recorded demo for regular clients using the port, player limit and
+passwords from the configuration. Everyone joins as a spectator, admins can
+/login and control playback with /pause, /play, /seek and /speed`,
+	Args: cobra.ExactArgs(1),
+	Run:  runReplay,
+}
+
+var bansCmd = &cobra.Command{
+	Use:   "bans",
+	Short: "Import and export the server's bans",
+	Long: `Import and export the bans in ` + bans.DefaultPath + ` as pyspades bans.txt,
+piqueserver ban publishing JSON or fosilo's own format. Stop the server first,
+it rewrites the file whenever a ban changes`,
+}
+
+var bansImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Merge a ban list into the server's bans",
+	Args:  cobra.ExactArgs(1),
+	Run:   runBansImport,
+}
+
+var bansExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Write the server's bans to a file, - for stdout",
+	Args:  cobra.ExactArgs(1),
+	Run:   runBansExport,
+}
+
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "Manage registered accounts",
+	Long: `Manage the registered accounts in ` + accounts.DefaultPath + `. Players register
+in game with /register, use this to hand out the first manager role. Stop the
+server first, it rewrites the file whenever an account changes`,
+}
+
+var accountsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered accounts and their roles",
+	Args:  cobra.NoArgs,
+	Run:   runAccountsList,
+}
+
+var accountsRoleCmd = &cobra.Command{
+	Use:   "role <name> <role>",
+	Short: "Set the role of an account (a built-in tier, a role from the config or none)",
+	Args:  cobra.ExactArgs(2),
+	Run:   runAccountsRole,
+}
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print version information",
@@ -52,11 +110,22 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "configs/config.toml", "path to configuration file")
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "log level (debug, info, warn, error)")
 
+	bansCmd.PersistentFlags().StringVarP(&banFormat, "format", "f", "pyspades", "ban list format (pyspades, piqueserver, fosilo)")
+	bansCmd.AddCommand(bansImportCmd)
+	bansCmd.AddCommand(bansExportCmd)
+
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(bansCmd)
+	accountsCmd.AddCommand(accountsListCmd)
+	accountsCmd.AddCommand(accountsRoleCmd)
+	rootCmd.AddCommand(accountsCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
-func runServer(cmd *cobra.Command, args []string) {
+// setup loads the config and builds the logger both subcommands use,
+// the returned function closes the log file if one was opened
+func setup() (*config.Config, *slog.Logger, func()) {
 	level := slog.LevelInfo
 	switch logLevel {
 	case "debug":
@@ -91,8 +160,6 @@ func runServer(cmd *cobra.Command, args []string) {
 			fmt.Fprintf(os.Stderr, "failed to open log file: %v\n", err)
 			os.Exit(1)
 		}
-		defer logFile.Close()
-
 		logWriter = io.MultiWriter(os.Stdout, logFile)
 	}
 
@@ -101,6 +168,17 @@ func runServer(cmd *cobra.Command, args []string) {
 	}))
 	slog.SetDefault(logger)
 
+	return cfg, logger, func() {
+		if logFile != nil {
+			logFile.Close()
+		}
+	}
+}
+
+func runServer(cmd *cobra.Command, args []string) {
+	cfg, logger, closeLog := setup()
+	defer closeLog()
+
 	logger.Info("starting foslio server", "version", version)
 
 	if err := cfg.Validate(); err != nil {
@@ -135,6 +213,137 @@ func runServer(cmd *cobra.Command, args []string) {
 	logger.Info("server stopped successfully")
 }
 
+func runReplay(cmd *cobra.Command, args []string) {
+	cfg, logger, closeLog := setup()
+	defer closeLog()
+
+	logger.Info("starting foslio replay", "version", version, "demo", args[0])
+
+	if err := cfg.Validate(); err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	replay, err := server.NewReplay(cfg, args[0], logger)
+	if err != nil {
+		logger.Error("failed to load demo", "error", err)
+		os.Exit(1)
+	}
+
+	if err := replay.Start(); err != nil {
+		logger.Error("failed to start replay", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("replay running", "address", fmt.Sprintf("0.0.0.0:%d", cfg.Server.Port))
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	<-sigChan
+	logger.Info("shutting down replay")
+
+	replay.Stop()
+}
+
+// loadBans opens the server's ban list for the bans subcommands
+func loadBans() (*bans.Manager, bans.Format) {
+	format, err := bans.ParseFormat(banFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	manager := bans.NewManager(bans.DefaultPath)
+	if err := manager.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load bans: %v\n", err)
+		os.Exit(1)
+	}
+	return manager, format
+}
+
+func runBansImport(cmd *cobra.Command, args []string) {
+	manager, format := loadBans()
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read ban list: %v\n", err)
+		os.Exit(1)
+	}
+
+	added, err := manager.Import(data, format)
+	fmt.Printf("imported %d bans into %s\n", added, bans.DefaultPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "some bans were skipped: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runBansExport(cmd *cobra.Command, args []string) {
+	manager, format := loadBans()
+
+	data, err := manager.Export(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to export bans: %v\n", err)
+		os.Exit(1)
+	}
+
+	if args[0] == "-" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(args[0], data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write ban list: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func loadAccounts() *accounts.Store {
+	store := accounts.NewStore(accounts.DefaultPath)
+	if err := store.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load accounts: %v\n", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+func runAccountsList(cmd *cobra.Command, args []string) {
+	for _, account := range loadAccounts().All() {
+		role := account.Role
+		if role == "" {
+			role = "none"
+		}
+		fmt.Printf("%-16s %-10s registered %s\n", account.Name, role, account.CreatedAt.Format("2006-01-02"))
+	}
+}
+
+func runAccountsRole(cmd *cobra.Command, args []string) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	roles, err := lua.NewRoles(cfg.Roles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid roles: %v\n", err)
+		os.Exit(1)
+	}
+
+	role := lua.NormalizeRole(args[1])
+	if !roles.Exists(role) {
+		fmt.Fprintf(os.Stderr, "unknown role %q\n", args[1])
+		os.Exit(1)
+	}
+	if role == "none" {
+		role = ""
+	}
+
+	if err := loadAccounts().SetRole(args[0], role); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set role: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,297 @@
+package accounts
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPath is where the server keeps registered accounts
+const DefaultPath = "data/accounts.json"
+
+// the server hashes off its game loop, a few at a time, and this keeps a
+// login quick while still being expensive to brute force offline. Stored
+// hashes carry their iteration count so it can be raised later
+const hashIterations = 100_000
+
+var (
+	ErrNameTaken     = errors.New("name is already registered")
+	ErrNoAccount     = errors.New("no such account")
+	ErrWrongPassword = errors.New("wrong password")
+)
+
+type Account struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"`
+	Role         string    `json:"role,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastLogin    time.Time `json:"last_login"`
+}
+
+// Store holds the registered accounts, names are matched ignoring case and
+// surrounding spaces so "Admin" also reserves "admin "
+type Store struct {
+	accounts map[string]*Account
+	filePath string
+	mu       sync.RWMutex
+}
+
+func fold(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func NewStore(filePath string) *Store {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("Warning: failed to create accounts directory: %v\n", err)
+	}
+
+	return &Store{
+		accounts: make(map[string]*Account),
+		filePath: filePath,
+	}
+}
+
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read accounts file: %w", err)
+	}
+
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return fmt.Errorf("failed to parse accounts file: %w", err)
+	}
+
+	s.accounts = make(map[string]*Account, len(accounts))
+	for _, account := range accounts {
+		if account.Name != "" {
+			s.accounts[fold(account.Name)] = account
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) saveUnlocked() error {
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].CreatedAt.Before(accounts[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal accounts: %w", err)
+	}
+
+	// password hashes are nobody else's business
+	if err := os.WriteFile(s.filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write accounts file: %w", err)
+	}
+
+	return nil
+}
+
+// Register creates an account without a role
+func (s *Store) Register(name, password string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.accounts[fold(name)]; exists {
+		return ErrNameTaken
+	}
+
+	s.accounts[fold(name)] = &Account{
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+
+	return s.saveUnlocked()
+}
+
+// Authenticate checks the password of an account and records the login.
+// The hash is checked without holding the lock, it is slow on purpose
+func (s *Store) Authenticate(name, password string) (Account, error) {
+	s.mu.RLock()
+	account, exists := s.accounts[fold(name)]
+	var hash string
+	if exists {
+		hash = account.PasswordHash
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		return Account{}, ErrNoAccount
+	}
+	if !checkPassword(hash, password) {
+		return Account{}, ErrWrongPassword
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the account may have been dropped or given a new password meanwhile
+	account, exists = s.accounts[fold(name)]
+	if !exists {
+		return Account{}, ErrNoAccount
+	}
+	if account.PasswordHash != hash {
+		return Account{}, ErrWrongPassword
+	}
+
+	account.LastLogin = time.Now()
+	if err := s.saveUnlocked(); err != nil {
+		return Account{}, err
+	}
+	return *account, nil
+}
+
+func (s *Store) Get(name string) (Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, exists := s.accounts[fold(name)]
+	if !exists {
+		return Account{}, false
+	}
+	return *account, true
+}
+
+// IsRegistered reports whether name is reserved by an account
+func (s *Store) IsRegistered(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.accounts[fold(name)]
+	return exists
+}
+
+// SetRole gives an account a role, an empty role takes it away
+func (s *Store) SetRole(name, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, exists := s.accounts[fold(name)]
+	if !exists {
+		return ErrNoAccount
+	}
+
+	account.Role = role
+	return s.saveUnlocked()
+}
+
+// SetPassword replaces the password of an account
+func (s *Store) SetPassword(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, exists := s.accounts[fold(name)]
+	if !exists {
+		return ErrNoAccount
+	}
+
+	account.PasswordHash = hash
+	return s.saveUnlocked()
+}
+
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.accounts[fold(name)]; !exists {
+		return ErrNoAccount
+	}
+
+	delete(s.accounts, fold(name))
+	return s.saveUnlocked()
+}
+
+func (s *Store) All() []Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, *account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return fold(accounts[i].Name) < fold(accounts[j].Name) })
+	return accounts
+}
+
+// hashes are stored as pbkdf2-sha256$iterations$salt$key
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, 32)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
@@ -0,0 +1,52 @@
+package accounts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegisterAndAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s := NewStore(path)
+
+	if err := s.Register("Deuce", "hunter22"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("deuce ", "other"); err != ErrNameTaken {
+		t.Errorf("registering the same name in another case: %v", err)
+	}
+	if err := s.SetRole("DEUCE", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter22") {
+		t.Fatal("the password was stored in the clear")
+	}
+
+	reloaded := NewStore(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsRegistered("dEuCe") {
+		t.Error("name is not reserved after a reload")
+	}
+	if _, err := reloaded.Authenticate("Deuce", "hunter2"); err != ErrWrongPassword {
+		t.Errorf("wrong password: %v", err)
+	}
+	account, err := reloaded.Authenticate("deuce", "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Name != "Deuce" || account.Role != "admin" || account.LastLogin.IsZero() {
+		t.Errorf("unexpected account %+v", account)
+	}
+	if _, err := reloaded.Authenticate("nobody", "hunter22"); err != ErrNoAccount {
+		t.Errorf("unknown account: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Package anticheat keeps per player statistics that are only suspicious in
+// aggregate, a single lucky flick or headshot proves nothing but a whole
+// match of them does
+package anticheat
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+const (
+	// how far back before a hit the aim is searched for a snap
+	snapWindow = 200 * time.Millisecond
+
+	// reaction times further apart than this are not reactions to the
+	// target appearing
+	maxReaction = 2 * time.Second
+
+	minReactions = 10
+	maxReactions = 64
+
+	// orientation packets closer together than this are measured as if
+	// they were this far apart so jitter cannot fake a snap
+	minTurnInterval = 10 * time.Millisecond
+)
+
+// AimThresholds decides what counts as an outlier, a zero value disables
+// the corresponding check
+type AimThresholds struct {
+	MinSamples    int
+	SnapSpeed     float64 // degrees per second
+	SnapRatio     float64
+	HeadshotRatio float64
+	MinReaction   time.Duration
+	MaxAccuracy   float64
+}
+
+// Flag is one statistic that crossed its threshold
+type Flag struct {
+	Kind   string
+	Detail string
+}
+
+type turn struct {
+	at    time.Time
+	speed float64
+}
+
+type weaponStats struct {
+	shots    int
+	shotsHit int
+	lastHit  bool
+}
+
+// AimStats accumulates the aim of one player, it is not safe for
+// concurrent use
+type AimStats struct {
+	th AimThresholds
+
+	lastOri   protocol.Vector3f
+	lastOriAt time.Time
+	turns     []turn
+
+	hits      int
+	headshots int
+	snapHits  int
+	reactions []time.Duration
+	weapons   map[protocol.WeaponType]*weaponStats
+
+	reported map[string]bool
+}
+
+func NewAimStats(th AimThresholds) *AimStats {
+	return &AimStats{
+		th:       th,
+		weapons:  make(map[protocol.WeaponType]*weaponStats),
+		reported: make(map[string]bool),
+	}
+}
+
+// Orientation records where the player is looking at now
+func (a *AimStats) Orientation(now time.Time, ori protocol.Vector3f) {
+	if !a.lastOriAt.IsZero() {
+		dt := max(now.Sub(a.lastOriAt), minTurnInterval)
+		a.turns = append(a.turns, turn{at: now, speed: angleBetween(a.lastOri, ori) / dt.Seconds()})
+	}
+	a.lastOri = ori
+	a.lastOriAt = now
+
+	kept := a.turns[:0]
+	for _, t := range a.turns {
+		if now.Sub(t.at) <= snapWindow {
+			kept = append(kept, t)
+		}
+	}
+	a.turns = kept
+}
+
+// Shot records a bullet fired with weapon
+func (a *AimStats) Shot(weapon protocol.WeaponType) {
+	w := a.weapon(weapon)
+	w.shots++
+	w.lastHit = false
+}
+
+// Hit records a hit that passed validation. visibleFor is how long the
+// target had been in sight, negative when that is unknown
+func (a *AimStats) Hit(now time.Time, weapon protocol.WeaponType, hitType protocol.HitType, visibleFor time.Duration) {
+	if hitType == protocol.HitTypeMelee {
+		return
+	}
+
+	a.hits++
+	if hitType == protocol.HitTypeHead {
+		a.headshots++
+	}
+
+	// shotgun pellets hit several times per shot, accuracy counts the shot
+	w := a.weapon(weapon)
+	if !w.lastHit && w.shotsHit < w.shots {
+		w.shotsHit++
+		w.lastHit = true
+	}
+
+	if a.th.SnapSpeed > 0 && a.peakTurn(now) >= a.th.SnapSpeed {
+		a.snapHits++
+	}
+
+	if visibleFor >= 0 && visibleFor <= maxReaction {
+		a.reactions = append(a.reactions, visibleFor)
+		if len(a.reactions) > maxReactions {
+			a.reactions = a.reactions[1:]
+		}
+	}
+}
+
+// Evaluate returns the statistics that are past their threshold and were
+// not reported before
+func (a *AimStats) Evaluate() []Flag {
+	th := a.th
+	var flags []Flag
+	flag := func(kind, detail string) {
+		if a.reported[kind] {
+			return
+		}
+		a.reported[kind] = true
+		flags = append(flags, Flag{Kind: kind, Detail: detail})
+	}
+
+	if a.hits < max(th.MinSamples, 1) {
+		return nil
+	}
+
+	if th.SnapRatio > 0 {
+		if ratio := float64(a.snapHits) / float64(a.hits); ratio >= th.SnapRatio {
+			flag("snap", fmt.Sprintf("%.0f%% of %d hits right after a flick over %.0f deg/s",
+				ratio*100, a.hits, th.SnapSpeed))
+		}
+	}
+
+	if th.HeadshotRatio > 0 {
+		if ratio := float64(a.headshots) / float64(a.hits); ratio >= th.HeadshotRatio {
+			flag("headshots", fmt.Sprintf("%.0f%% headshots over %d hits", ratio*100, a.hits))
+		}
+	}
+
+	if th.MinReaction > 0 && len(a.reactions) >= minReactions {
+		if median := a.medianReaction(); median < th.MinReaction {
+			flag("reaction", fmt.Sprintf("median reaction %dms over %d targets",
+				median.Milliseconds(), len(a.reactions)))
+		}
+	}
+
+	if th.MaxAccuracy > 0 {
+		for weapon, w := range a.weapons {
+			if w.shots < th.MinSamples {
+				continue
+			}
+			if accuracy := float64(w.shotsHit) / float64(w.shots); accuracy >= th.MaxAccuracy {
+				flag(fmt.Sprintf("accuracy_%d", weapon), fmt.Sprintf("%.0f%% accuracy over %d shots with %s",
+					accuracy*100, w.shots, weaponName(weapon)))
+			}
+		}
+	}
+
+	return flags
+}
+
+func (a *AimStats) weapon(weapon protocol.WeaponType) *weaponStats {
+	w, ok := a.weapons[weapon]
+	if !ok {
+		w = &weaponStats{}
+		a.weapons[weapon] = w
+	}
+	return w
+}
+
+func (a *AimStats) peakTurn(now time.Time) float64 {
+	peak := 0.0
+	for _, t := range a.turns {
+		if now.Sub(t.at) <= snapWindow {
+			peak = math.Max(peak, t.speed)
+		}
+	}
+	return peak
+}
+
+func (a *AimStats) medianReaction() time.Duration {
+	sorted := append([]time.Duration(nil), a.reactions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// angleBetween returns the angle between two orientations in degrees
+func angleBetween(a, b protocol.Vector3f) float64 {
+	la := math.Sqrt(float64(a.X*a.X + a.Y*a.Y + a.Z*a.Z))
+	lb := math.Sqrt(float64(b.X*b.X + b.Y*b.Y + b.Z*b.Z))
+	if la == 0 || lb == 0 {
+		return 0
+	}
+	dot := float64(a.X*b.X+a.Y*b.Y+a.Z*b.Z) / (la * lb)
+	return math.Acos(math.Max(-1, math.Min(1, dot))) * 180 / math.Pi
+}
+
+func weaponName(weapon protocol.WeaponType) string {
+	switch weapon {
+	case protocol.WeaponTypeRifle:
+		return "rifle"
+	case protocol.WeaponTypeSMG:
+		return "SMG"
+	case protocol.WeaponTypeShotgun:
+		return "shotgun"
+	}
+	return "unknown weapon"
+}
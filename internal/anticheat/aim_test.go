@@ -0,0 +1,65 @@
+package anticheat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+func TestAimStatsFlagsSnapsAndHeadshots(t *testing.T) {
+	a := NewAimStats(AimThresholds{
+		MinSamples:    10,
+		SnapSpeed:     1000,
+		SnapRatio:     0.5,
+		HeadshotRatio: 0.8,
+		MaxAccuracy:   0.9,
+	})
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		// look away, then flick 90 degrees in one packet right before the hit
+		a.Orientation(now, protocol.Vector3f{X: 1})
+		now = now.Add(20 * time.Millisecond)
+		a.Orientation(now, protocol.Vector3f{Y: 1})
+
+		a.Shot(protocol.WeaponTypeRifle)
+		a.Hit(now, protocol.WeaponTypeRifle, protocol.HitTypeHead, -1)
+		now = now.Add(time.Second)
+	}
+
+	kinds := map[string]bool{}
+	for _, f := range a.Evaluate() {
+		kinds[f.Kind] = true
+	}
+	for _, kind := range []string{"snap", "headshots", "accuracy_0"} {
+		if !kinds[kind] {
+			t.Errorf("expected %s to be flagged, got %v", kind, kinds)
+		}
+	}
+
+	if flags := a.Evaluate(); len(flags) != 0 {
+		t.Errorf("flags were reported twice: %v", flags)
+	}
+}
+
+func TestAimStatsCountsShotgunShotsOnce(t *testing.T) {
+	a := NewAimStats(AimThresholds{MinSamples: 10, MaxAccuracy: 0.9})
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		a.Shot(protocol.WeaponTypeShotgun)
+		if i%2 == 0 {
+			// every pellet of the shot lands
+			for pellet := 0; pellet < 8; pellet++ {
+				a.Hit(now, protocol.WeaponTypeShotgun, protocol.HitTypeTorso, -1)
+			}
+		}
+	}
+
+	for _, f := range a.Evaluate() {
+		if f.Kind == "accuracy_2" {
+			t.Errorf("half the shots hit but accuracy was flagged: %s", f.Detail)
+		}
+	}
+}
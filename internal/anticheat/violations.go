@@ -0,0 +1,106 @@
+package anticheat
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// violation categories fed by the server
+const (
+	CategoryRateLimit   = "rate_limit"
+	CategoryBlockQuota  = "block_quota"
+	CategoryBlockDamage = "block_damage"
+	CategoryWeapon      = "weapon"
+	CategoryMovement    = "movement"
+	CategoryAim         = "aim"
+)
+
+// a score this low counts as fully decayed
+const forgottenScore = 0.1
+
+// Ledger is the decaying violation score of one player, it is not safe for
+// concurrent use
+type Ledger struct {
+	halfLife time.Duration
+	score    float64
+	updated  time.Time
+	counts   map[string]int
+
+	// Step is how many rungs of the escalation ladder were already applied
+	Step int
+}
+
+func NewLedger(halfLife time.Duration) *Ledger {
+	return &Ledger{
+		halfLife: halfLife,
+		counts:   make(map[string]int),
+	}
+}
+
+// Record adds a violation of weight to the ledger and returns the new score
+func (l *Ledger) Record(now time.Time, category string, weight float64) float64 {
+	l.decay(now)
+	l.score += weight
+	l.counts[category]++
+	return l.score
+}
+
+// Score returns the score as of now. Once it has decayed to nothing the
+// ledger starts over, counts and ladder progress included
+func (l *Ledger) Score(now time.Time) float64 {
+	l.decay(now)
+	return l.score
+}
+
+// Counts returns how often each category was recorded since the ledger last
+// started over, sorted by category
+func (l *Ledger) Counts() []CategoryCount {
+	counts := make([]CategoryCount, 0, len(l.counts))
+	for category, n := range l.counts {
+		counts = append(counts, CategoryCount{Category: category, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Category < counts[j].Category })
+	return counts
+}
+
+type CategoryCount struct {
+	Category string
+	Count    int
+}
+
+func (l *Ledger) decay(now time.Time) {
+	if !l.updated.IsZero() && l.halfLife > 0 && now.After(l.updated) {
+		l.score *= math.Pow(0.5, float64(now.Sub(l.updated))/float64(l.halfLife))
+	}
+	l.updated = now
+
+	if l.score < forgottenScore {
+		l.score = 0
+		l.Step = 0
+		clear(l.counts)
+	}
+}
+
+// Absorb adds the score, counts and ladder progress of other to l, for a
+// ledger that was started before an older one of the same player turned up
+func (l *Ledger) Absorb(other *Ledger, now time.Time) {
+	l.decay(now)
+	other.decay(now)
+	l.score += other.score
+	for category, n := range other.counts {
+		l.counts[category] += n
+	}
+	l.Step = max(l.Step, other.Step)
+}
+
+// Report is a snapshot of a ledger for admins and scripts
+type Report struct {
+	Score  float64
+	Step   int
+	Counts []CategoryCount
+}
+
+func (l *Ledger) Report(now time.Time) Report {
+	return Report{Score: l.Score(now), Step: l.Step, Counts: l.Counts()}
+}
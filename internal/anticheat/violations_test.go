@@ -0,0 +1,26 @@
+package anticheat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLedgerDecaysAndStartsOver(t *testing.T) {
+	l := NewLedger(time.Minute)
+	now := time.Now()
+
+	l.Record(now, CategoryWeapon, 8)
+	l.Step = 1
+	if score := l.Record(now, CategoryWeapon, 8); score != 16 {
+		t.Fatalf("expected 16 points, got %v", score)
+	}
+
+	if score := l.Score(now.Add(time.Minute)); score < 7.9 || score > 8.1 {
+		t.Errorf("expected the score to halve after a half life, got %v", score)
+	}
+
+	l.Score(now.Add(time.Hour))
+	if l.Step != 0 || len(l.Counts()) != 0 {
+		t.Errorf("a fully decayed ledger kept its step %d and counts %v", l.Step, l.Counts())
+	}
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPath is where the server appends its audit trail when the config
+// names no audit_log
+const DefaultPath = "data/audit.jsonl"
+
+// Entry is one administrative action. IP is the address of the actor, empty
+// when the server acted on its own
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	IP     string    `json:"ip,omitempty"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Args   []string  `json:"args,omitempty"`
+}
+
+// Recorder takes audit entries
+type Recorder interface {
+	Record(e Entry) error
+}
+
+// Keep is how many of the latest entries a Log holds in memory for Tail
+const Keep = 1000
+
+// Log is an append-only file of entries, one JSON object per line. Entries
+// are never rewritten, rotating the file is left to the operator. The latest
+// ones are kept in a ring as well so looking them up never reads the file
+type Log struct {
+	file   *os.File
+	recent []Entry
+	next   int
+	mu     sync.Mutex
+}
+
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	l := &Log{file: file, recent: make([]Entry, 0, Keep)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		l.remember(e)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return l, nil
+}
+
+func (l *Log) remember(e Entry) {
+	if len(l.recent) < Keep {
+		l.recent = append(l.recent, e)
+	} else {
+		l.recent[l.next] = e
+	}
+	l.next = (l.next + 1) % Keep
+}
+
+// Record appends e, stamping it with the current time when it has none
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// a single write per entry keeps lines whole with O_APPEND
+	if _, err := l.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	l.remember(e)
+	return nil
+}
+
+// Tail returns up to n of the latest entries, newest first. A non-empty who
+// only keeps entries whose actor, target or IP matches it, ignoring case.
+// Only the last Keep entries are searched, older ones stay in the file
+func (l *Log) Tail(who string, n int) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []Entry
+	for i := 0; i < len(l.recent) && len(entries) < n; i++ {
+		e := l.recent[(l.next-1-i+len(l.recent))%len(l.recent)]
+		if who != "" && !strings.EqualFold(e.Actor, who) && !strings.EqualFold(e.Target, who) && e.IP != who {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []Entry{
+		{Actor: "Admin", IP: "10.0.0.1", Action: "kick", Target: "Griefer", Args: []string{"spam"}},
+		{Actor: "server", Action: "map_change", Args: []string{"hallway"}},
+		{Actor: "Admin", IP: "10.0.0.1", Action: "ban", Target: "10.0.0.9"},
+		{Actor: "Mod", Action: "kick", Target: "griefer"},
+	} {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening appends rather than truncating
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Record(Entry{Actor: "server", Action: "kick", Target: "Bot"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 5 {
+		t.Fatalf("%d lines in the log, want 5", lines)
+	}
+
+	latest, err := l.Tail("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 || latest[0].Target != "Bot" || latest[1].Actor != "Mod" {
+		t.Errorf("latest two entries: %+v", latest)
+	}
+	if latest[0].Time.IsZero() {
+		t.Error("entry was not timestamped")
+	}
+
+	griefer, err := l.Tail("GRIEFER", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(griefer) != 2 || griefer[0].Actor != "Mod" || griefer[1].Actor != "Admin" {
+		t.Errorf("entries about the griefer: %+v", griefer)
+	}
+
+	byIP, err := l.Tail("10.0.0.1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byIP) != 2 {
+		t.Errorf("%d entries from 10.0.0.1, want 2", len(byIP))
+	}
+}
+
+func TestTailKeepsLatest(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < Keep+5; i++ {
+		if err := l.Record(Entry{Actor: "server", Action: "kick", Target: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := l.Tail("", Keep*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != Keep || all[0].Target != strconv.Itoa(Keep+4) || all[Keep-1].Target != "5" {
+		t.Errorf("%d entries from %s to %s, want %d from %d to 5", len(all), all[0].Target, all[len(all)-1].Target, Keep, Keep+4)
+	}
+}
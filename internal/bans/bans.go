@@ -2,20 +2,37 @@ package bans
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/siohaza/fosilo/internal/audit"
 )
 
+// DefaultPath is where the server keeps its own bans
+const DefaultPath = "data/bans.json"
+
 type BanType string
 
 const (
 	BanTypeIP       BanType = "ip"
 	BanTypeUsername BanType = "username"
+
+	// BanTypeRange covers a CIDR block kept in IP, e.g. 203.0.113.0/24
+	BanTypeRange BanType = "range"
+	// BanTypeNamePattern matches names against Name, where * stands for any
+	// run of characters and ? for one. Names and patterns both ignore case
+	BanTypeNamePattern BanType = "name_pattern"
 )
 
+var ErrNotBanned = errors.New("not banned")
+
 type Ban struct {
 	Type      BanType   `json:"type"`
 	IP        string    `json:"ip,omitempty"`
@@ -25,13 +42,67 @@ type Ban struct {
 	BannedAt  time.Time `json:"banned_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 	Permanent bool      `json:"permanent"`
+
+	// Source names the subscribed list a ban came from, empty for our own
+	Source string `json:"source,omitempty"`
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.Permanent && now.After(b.ExpiresAt)
+}
+
+// key is what identifies the ban within its list: the address, range, name
+// or pattern
+func (b *Ban) key() string {
+	switch b.Type {
+	case BanTypeUsername, BanTypeNamePattern:
+		return b.Name
+	}
+	return b.IP
 }
 
 type Manager struct {
-	ipBans       map[string]*Ban
-	usernameBans map[string]*Ban
-	filePath     string
-	mu           sync.RWMutex
+	local *banSet
+
+	// read-only lists by source, replaced wholesale on every refresh
+	subscribed map[string]*banSet
+	// addresses, ranges and names subscribed bans do not apply to here
+	exclusions map[string]bool
+
+	filePath string
+	audit    audit.Recorder
+	mu       sync.RWMutex
+}
+
+// IsRange reports whether s is written as a CIDR range rather than a single
+// address
+func IsRange(s string) bool {
+	return strings.Contains(s, "/")
+}
+
+// IsPattern reports whether name contains wildcards
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, "*?")
+}
+
+// ParseRange parses a CIDR range and masks off the host bits, so
+// 203.0.113.7/24 and 203.0.113.0/24 are the same range. A range covering
+// every address is refused
+func ParseRange(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid range %q: %w", cidr, err)
+	}
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("invalid range %q: too wide for a mapped IPv4 address", cidr)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	if prefix.Bits() == 0 {
+		return netip.Prefix{}, fmt.Errorf("invalid range %q: covers every address", cidr)
+	}
+	return prefix.Masked(), nil
 }
 
 func NewManager(filePath string) *Manager {
@@ -41,9 +112,24 @@ func NewManager(filePath string) *Manager {
 	}
 
 	return &Manager{
-		ipBans:       make(map[string]*Ban),
-		usernameBans: make(map[string]*Ban),
-		filePath:     filePath,
+		local:      newBanSet(),
+		subscribed: make(map[string]*banSet),
+		exclusions: make(map[string]bool),
+		filePath:   filePath,
+	}
+}
+
+// SetAudit makes bans and unbans leave an entry in r
+func (m *Manager) SetAudit(r audit.Recorder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = r
+}
+
+func (m *Manager) recordUnlocked(e audit.Entry) {
+	if m.audit != nil {
+		// the ban itself stands even when the trail cannot be written
+		_ = m.audit.Record(e)
 	}
 }
 
@@ -64,10 +150,10 @@ func (m *Manager) Load() error {
 		return fmt.Errorf("failed to parse bans file: %w", err)
 	}
 
-	m.ipBans = make(map[string]*Ban)
-	m.usernameBans = make(map[string]*Ban)
+	m.local = newBanSet()
+	now := time.Now()
 	for _, ban := range bans {
-		if !ban.Permanent && time.Now().After(ban.ExpiresAt) {
+		if ban.expired(now) {
 			continue
 		}
 
@@ -75,15 +161,8 @@ func (m *Manager) Load() error {
 			ban.Type = BanTypeIP
 		}
 
-		switch ban.Type {
-		case BanTypeIP:
-			if ban.IP != "" {
-				m.ipBans[ban.IP] = ban
-			}
-		case BanTypeUsername:
-			if ban.Name != "" {
-				m.usernameBans[ban.Name] = ban
-			}
+		if err := m.local.add(ban); err != nil {
+			fmt.Printf("Warning: skipping ban: %v\n", err)
 		}
 	}
 
@@ -94,64 +173,75 @@ func (m *Manager) Save() error {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	bans := make([]*Ban, 0, len(m.ipBans)+len(m.usernameBans))
-	for _, ban := range m.ipBans {
-		bans = append(bans, ban)
-	}
-	for _, ban := range m.usernameBans {
-		bans = append(bans, ban)
-	}
-
-	data, err := json.MarshalIndent(bans, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal bans: %w", err)
-	}
-
-	if err := os.WriteFile(m.filePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write bans file: %w", err)
-	}
-
-	return nil
+	return m.saveUnlocked()
 }
 
+// IsBanned checks ip against exact bans first and then against every range
+// covering it, our own bans before subscribed ones
 func (m *Manager) IsBanned(ip string) (bool, *Ban) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	ban, exists := m.ipBans[ip]
-	if !exists {
-		return false, nil
+	now := time.Now()
+	if ban := m.local.matchIP(ip, now); ban != nil {
+		return true, ban
 	}
 
-	if !ban.Permanent && time.Now().After(ban.ExpiresAt) {
+	if m.exclusions[ip] {
 		return false, nil
 	}
+	for _, source := range m.sourcesUnlocked() {
+		if ban := m.subscribed[source].matchIP(ip, now); ban != nil && !m.excludedUnlocked(ban) {
+			return true, ban
+		}
+	}
 
-	return true, ban
+	return false, nil
 }
 
 func (m *Manager) IsBannedByName(name string) (bool, *Ban) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	ban, exists := m.usernameBans[name]
-	if !exists {
-		return false, nil
+	now := time.Now()
+	if ban := m.local.matchName(name, now); ban != nil {
+		return true, ban
 	}
 
-	if !ban.Permanent && time.Now().After(ban.ExpiresAt) {
+	if m.exclusions[strings.ToLower(name)] {
 		return false, nil
 	}
+	for _, source := range m.sourcesUnlocked() {
+		if ban := m.subscribed[source].matchName(name, now); ban != nil && !m.excludedUnlocked(ban) {
+			return true, ban
+		}
+	}
 
-	return true, ban
+	return false, nil
 }
 
 func (m *Manager) AddBan(ip, name, reason, bannedBy string, duration time.Duration) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.addBan(BanTypeIP, ip, name, reason, bannedBy, duration)
+}
 
+// AddRangeBan bans every address in the CIDR range, name is only kept for
+// reference
+func (m *Manager) AddRangeBan(cidr, name, reason, bannedBy string, duration time.Duration) error {
+	return m.addBan(BanTypeRange, cidr, name, reason, bannedBy, duration)
+}
+
+// AddBanByName bans a name, or every name matching it when it contains
+// wildcards
+func (m *Manager) AddBanByName(name, reason, bannedBy string, duration time.Duration) error {
+	if IsPattern(name) {
+		return m.addBan(BanTypeNamePattern, "", name, reason, bannedBy, duration)
+	}
+	return m.addBan(BanTypeUsername, "", name, reason, bannedBy, duration)
+}
+
+func (m *Manager) addBan(banType BanType, ip, name, reason, bannedBy string, duration time.Duration) error {
 	ban := &Ban{
-		Type:      BanTypeIP,
+		Type:      banType,
 		IP:        ip,
 		Name:      name,
 		Reason:    reason,
@@ -164,61 +254,58 @@ func (m *Manager) AddBan(ip, name, reason, bannedBy string, duration time.Durati
 		ban.ExpiresAt = time.Now().Add(duration)
 	}
 
-	m.ipBans[ip] = ban
-
-	return m.saveUnlocked()
-}
-
-func (m *Manager) AddBanByName(name, reason, bannedBy string, duration time.Duration) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	ban := &Ban{
-		Type:      BanTypeUsername,
-		Name:      name,
-		Reason:    reason,
-		BannedBy:  bannedBy,
-		BannedAt:  time.Now(),
-		Permanent: duration == 0,
+	if err := m.local.add(ban); err != nil {
+		return err
 	}
 
+	length := "permanent"
 	if duration > 0 {
-		ban.ExpiresAt = time.Now().Add(duration)
+		length = duration.String()
 	}
-
-	m.usernameBans[name] = ban
+	m.recordUnlocked(audit.Entry{
+		Actor:  bannedBy,
+		Action: "ban",
+		Target: ban.key(),
+		Args:   []string{string(banType), reason, length},
+	})
 
 	return m.saveUnlocked()
 }
 
+// RemoveBan lifts the ban on an address or, given a CIDR range, the range
+// ban itself. Addresses merely covered by a range stay banned, and
+// subscribed bans can only be excluded, not lifted
 func (m *Manager) RemoveBan(ip string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.ipBans, ip)
+	if err := m.local.removeIP(ip); err != nil {
+		return err
+	}
+	m.recordUnlocked(audit.Entry{Action: "unban", Target: ip})
 
 	return m.saveUnlocked()
 }
 
+// RemoveBanByName lifts a name ban, or a pattern ban when name contains
+// wildcards
 func (m *Manager) RemoveBanByName(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.usernameBans, name)
+	if err := m.local.removeName(name); err != nil {
+		return err
+	}
+	m.recordUnlocked(audit.Entry{Action: "unban", Target: name})
 
 	return m.saveUnlocked()
 }
 
 func (m *Manager) saveUnlocked() error {
-	bans := make([]*Ban, 0, len(m.ipBans)+len(m.usernameBans))
-	for _, ban := range m.ipBans {
-		bans = append(bans, ban)
-	}
-	for _, ban := range m.usernameBans {
-		bans = append(bans, ban)
-	}
-
-	data, err := json.MarshalIndent(bans, "", "  ")
+	data, err := json.MarshalIndent(m.local.all(), "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal bans: %w", err)
 	}
@@ -230,22 +317,25 @@ func (m *Manager) saveUnlocked() error {
 	return nil
 }
 
+// GetAll returns the bans in force, subscribed ones included and tagged with
+// their source
 func (m *Manager) GetAll() []*Ban {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	bans := make([]*Ban, 0, len(m.ipBans)+len(m.usernameBans))
-	for _, ban := range m.ipBans {
-		if !ban.Permanent && time.Now().After(ban.ExpiresAt) {
-			continue
+	now := time.Now()
+	var bans []*Ban
+	for _, ban := range m.local.all() {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
 		}
-		bans = append(bans, ban)
 	}
-	for _, ban := range m.usernameBans {
-		if !ban.Permanent && time.Now().After(ban.ExpiresAt) {
-			continue
+	for _, source := range m.sourcesUnlocked() {
+		for _, ban := range m.subscribed[source].all() {
+			if !ban.expired(now) && !m.excludedUnlocked(ban) {
+				bans = append(bans, ban)
+			}
 		}
-		bans = append(bans, ban)
 	}
 
 	return bans
@@ -256,16 +346,193 @@ func (m *Manager) Cleanup() error {
 	defer m.mu.Unlock()
 
 	now := time.Now()
-	for ip, ban := range m.ipBans {
-		if !ban.Permanent && now.After(ban.ExpiresAt) {
-			delete(m.ipBans, ip)
+	m.local.prune(now)
+	for _, set := range m.subscribed {
+		set.prune(now)
+	}
+
+	return m.saveUnlocked()
+}
+
+func (m *Manager) sourcesUnlocked() []string {
+	sources := make([]string, 0, len(m.subscribed))
+	for source := range m.subscribed {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// banSet is one list of bans along with the tries that match against it
+type banSet struct {
+	ips      map[string]*Ban
+	names    map[string]*Ban
+	ranges   map[netip.Prefix]*Ban
+	patterns map[string]*Ban
+
+	rangeTrie   ipTrie
+	patternTrie nameTrie
+}
+
+func newBanSet() *banSet {
+	return &banSet{
+		ips:      make(map[string]*Ban),
+		names:    make(map[string]*Ban),
+		ranges:   make(map[netip.Prefix]*Ban),
+		patterns: make(map[string]*Ban),
+	}
+}
+
+// add files ban under its type, replacing any ban with the same key
+func (s *banSet) add(ban *Ban) error {
+	switch ban.Type {
+	case BanTypeIP:
+		if ban.IP == "" {
+			return fmt.Errorf("ip ban without an address")
+		}
+		s.ips[ban.IP] = ban
+	case BanTypeUsername:
+		if ban.Name == "" {
+			return fmt.Errorf("name ban without a name")
+		}
+		ban.Name = strings.ToLower(ban.Name)
+		s.names[ban.Name] = ban
+	case BanTypeRange:
+		prefix, err := ParseRange(ban.IP)
+		if err != nil {
+			return err
+		}
+		ban.IP = prefix.String()
+		s.ranges[prefix] = ban
+		s.rangeTrie.insert(prefix, ban)
+	case BanTypeNamePattern:
+		if strings.Trim(ban.Name, "*?") == "" {
+			return fmt.Errorf("name pattern %q matches every name", ban.Name)
 		}
+		ban.Name = strings.ToLower(ban.Name)
+		if _, exists := s.patterns[ban.Name]; exists {
+			s.patternTrie.remove(ban.Name)
+		}
+		s.patterns[ban.Name] = ban
+		s.patternTrie.insert(ban.Name, ban)
+	default:
+		return fmt.Errorf("unknown ban type %q", ban.Type)
+	}
+	return nil
+}
+
+func (s *banSet) has(ban *Ban) bool {
+	switch ban.Type {
+	case BanTypeIP:
+		return s.ips[ban.IP] != nil
+	case BanTypeUsername:
+		return s.names[strings.ToLower(ban.Name)] != nil
+	case BanTypeRange:
+		prefix, err := ParseRange(ban.IP)
+		return err == nil && s.ranges[prefix] != nil
+	case BanTypeNamePattern:
+		return s.patterns[strings.ToLower(ban.Name)] != nil
 	}
-	for name, ban := range m.usernameBans {
-		if !ban.Permanent && now.After(ban.ExpiresAt) {
-			delete(m.usernameBans, name)
+	return false
+}
+
+func (s *banSet) removeIP(ip string) error {
+	if IsRange(ip) {
+		prefix, err := ParseRange(ip)
+		if err != nil {
+			return err
 		}
+		if _, exists := s.ranges[prefix]; !exists {
+			return ErrNotBanned
+		}
+		delete(s.ranges, prefix)
+		s.rangeTrie.remove(prefix)
+		return nil
 	}
 
-	return m.saveUnlocked()
+	if _, exists := s.ips[ip]; !exists {
+		return ErrNotBanned
+	}
+	delete(s.ips, ip)
+	return nil
+}
+
+func (s *banSet) removeName(name string) error {
+	name = strings.ToLower(name)
+	if IsPattern(name) {
+		if _, exists := s.patterns[name]; !exists {
+			return ErrNotBanned
+		}
+		delete(s.patterns, name)
+		s.patternTrie.remove(name)
+		return nil
+	}
+
+	if _, exists := s.names[name]; !exists {
+		return ErrNotBanned
+	}
+	delete(s.names, name)
+	return nil
+}
+
+func (s *banSet) matchIP(ip string, now time.Time) *Ban {
+	if ban, exists := s.ips[ip]; exists && !ban.expired(now) {
+		return ban
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	return s.rangeTrie.lookup(addr.Unmap(), now)
+}
+
+func (s *banSet) matchName(name string, now time.Time) *Ban {
+	name = strings.ToLower(name)
+	if ban, exists := s.names[name]; exists && !ban.expired(now) {
+		return ban
+	}
+	return s.patternTrie.lookup(name, now)
+}
+
+func (s *banSet) all() []*Ban {
+	bans := make([]*Ban, 0, len(s.ips)+len(s.names)+len(s.ranges)+len(s.patterns))
+	for _, ban := range s.ips {
+		bans = append(bans, ban)
+	}
+	for _, ban := range s.names {
+		bans = append(bans, ban)
+	}
+	for _, ban := range s.ranges {
+		bans = append(bans, ban)
+	}
+	for _, ban := range s.patterns {
+		bans = append(bans, ban)
+	}
+	return bans
+}
+
+func (s *banSet) prune(now time.Time) {
+	for ip, ban := range s.ips {
+		if ban.expired(now) {
+			delete(s.ips, ip)
+		}
+	}
+	for name, ban := range s.names {
+		if ban.expired(now) {
+			delete(s.names, name)
+		}
+	}
+	for prefix, ban := range s.ranges {
+		if ban.expired(now) {
+			delete(s.ranges, prefix)
+			s.rangeTrie.remove(prefix)
+		}
+	}
+	for pattern, ban := range s.patterns {
+		if ban.expired(now) {
+			delete(s.patterns, pattern)
+			s.patternTrie.remove(pattern)
+		}
+	}
 }
@@ -0,0 +1,227 @@
+package bans
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/audit"
+)
+
+func TestRangeAndPatternBans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	m := NewManager(path)
+
+	if err := m.AddRangeBan("203.0.113.77/24", "evader", "evasion", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRangeBan("2001:db8:1::/48", "", "evasion", "admin", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddBanByName("Aim*Bot?", "cheating", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddBanByName("Griefer", "griefing", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRangeBan("not a range", "", "", "admin", 0); err == nil {
+		t.Error("an invalid range was accepted")
+	}
+	for _, everyone := range []string{"0.0.0.0/0", "::/0", "::ffff:0.0.0.0/96"} {
+		if err := m.AddRangeBan(everyone, "", "", "admin", 0); err == nil {
+			t.Errorf("range %s covering every address was accepted", everyone)
+		}
+	}
+	for _, everyone := range []string{"*", "?*", "**"} {
+		if err := m.AddBanByName(everyone, "", "admin", 0); err == nil {
+			t.Errorf("pattern %s matching every name was accepted", everyone)
+		}
+	}
+
+	check := func(m *Manager) {
+		t.Helper()
+		for ip, want := range map[string]bool{
+			"203.0.113.1":           true,
+			"203.0.113.255":         true,
+			"::ffff:203.0.113.9":    true,
+			"203.0.114.1":           false,
+			"2001:db8:1:ffff::1":    true,
+			"2001:db8:2::1":         false,
+			"not an address at all": false,
+		} {
+			if banned, _ := m.IsBanned(ip); banned != want {
+				t.Errorf("IsBanned(%q) = %v, want %v", ip, banned, want)
+			}
+		}
+		for name, want := range map[string]bool{
+			"aimbot1":      true,
+			"AIM_the_BOTs": true,
+			"aimbot":       false,
+			"Deuce":        false,
+			"griefer":      true,
+			"GRIEFER":      true,
+		} {
+			if banned, _ := m.IsBannedByName(name); banned != want {
+				t.Errorf("IsBannedByName(%q) = %v, want %v", name, banned, want)
+			}
+		}
+	}
+	check(m)
+
+	reloaded := NewManager(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	check(reloaded)
+
+	if err := reloaded.RemoveBan("203.0.113.1"); err != ErrNotBanned {
+		t.Errorf("lifting an address only covered by a range: %v", err)
+	}
+	if err := reloaded.RemoveBan("203.0.113.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.RemoveBanByName("aim*bot?"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.RemoveBanByName("griefer"); err != nil {
+		t.Fatal(err)
+	}
+	if banned, _ := reloaded.IsBanned("203.0.113.1"); banned {
+		t.Error("address still banned after its range was lifted")
+	}
+	if banned, _ := reloaded.IsBannedByName("aimbot1"); banned {
+		t.Error("name still banned after its pattern was lifted")
+	}
+}
+
+func TestMostSpecificRangeWins(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "bans.json"))
+
+	if err := m.AddRangeBan("10.0.0.0/8", "", "wide", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRangeBan("10.1.0.0/16", "", "narrow", "admin", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ban := m.IsBanned("10.1.2.3"); ban == nil || ban.Reason != "narrow" {
+		t.Errorf("expected the /16 to match, got %+v", ban)
+	}
+	if _, ban := m.IsBanned("10.2.2.3"); ban == nil || ban.Reason != "wide" {
+		t.Errorf("expected the /8 to match, got %+v", ban)
+	}
+}
+
+type recorder []audit.Entry
+
+func (r *recorder) Record(e audit.Entry) error {
+	*r = append(*r, e)
+	return nil
+}
+
+func TestImportExportFormats(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "bans.json"))
+	var trail recorder
+	m.SetAudit(&trail)
+
+	pyspades := `[
+		["198.51.100.4/32", ["griefer", "griefing", null]],
+		["198.51.100.0/24", [null, "proxy range", 4102444800.0]],
+		["192.0.2.1", ["old", "expired", 1000000000.0]]
+	]`
+	added, err := m.Import([]byte(pyspades), FormatPyspades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("imported %d bans, want 2 without the expired one", added)
+	}
+	if _, ban := m.IsBanned("198.51.100.4"); ban == nil || ban.Type != BanTypeIP || !ban.Permanent {
+		t.Errorf("a /32 should import as a permanent address ban, got %+v", ban)
+	}
+	if _, ban := m.IsBanned("198.51.100.9"); ban == nil || ban.Permanent || ban.ExpiresAt.Year() != 2100 {
+		t.Errorf("the range should import with its expiry, got %+v", ban)
+	}
+
+	if err := m.AddBanByName("griefer", "griefing", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Export(FormatPiqueserver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := Decode(data, FormatPiqueserver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || exported[0].IP != "198.51.100.4" {
+		t.Errorf("piqueserver export should hold only the permanent address ban, got %s", data)
+	}
+
+	again, err := m.Export(FormatPyspades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := m.Import(again, FormatPyspades); err != nil || added != 0 {
+		t.Errorf("importing our own export added %d bans: %v", added, err)
+	}
+
+	var imports int
+	for _, e := range trail {
+		if e.Action == "ban_import" {
+			imports++
+		}
+	}
+	if imports != 1 {
+		t.Errorf("%d ban_import entries, want 1 as the second import added nothing", imports)
+	}
+}
+
+func TestSubscribedBansAreTaggedAndExcludable(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "shared.json")
+	shared := `[{"ip": "203.0.113.0/24", "reason": "proxies"}, {"ip": "192.0.2.7", "name": "cheater"}]`
+	if err := os.WriteFile(list, []byte(shared), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "bans.json")
+	m := NewManager(path)
+	sub := Subscription{Name: "community", Location: list, Format: FormatPiqueserver}
+	if n, err := m.Refresh(context.Background(), http.DefaultClient, sub); err != nil || n != 2 {
+		t.Fatalf("refresh gave %d bans: %v", n, err)
+	}
+
+	if _, ban := m.IsBanned("203.0.113.50"); ban == nil || ban.Source != "community" {
+		t.Errorf("expected a ban tagged with its source, got %+v", ban)
+	}
+	if err := m.RemoveBan("192.0.2.7"); err != ErrNotBanned {
+		t.Errorf("a subscribed ban was lifted locally: %v", err)
+	}
+
+	m.SetExclusions([]string{"203.0.113.50", "192.0.2.7"})
+	if banned, _ := m.IsBanned("203.0.113.50"); banned {
+		t.Error("an excluded address is still banned by a subscribed range")
+	}
+	if banned, _ := m.IsBanned("192.0.2.7"); banned {
+		t.Error("an excluded subscribed ban still applies")
+	}
+	if banned, _ := m.IsBanned("203.0.113.51"); !banned {
+		t.Error("excluding one address lifted the whole range")
+	}
+
+	// subscribed bans are never written to our own list
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewManager(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.GetAll()) != 0 {
+		t.Errorf("subscribed bans leaked into %s", path)
+	}
+}
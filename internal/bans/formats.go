@@ -0,0 +1,224 @@
+package bans
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/audit"
+)
+
+// Format is a ban list layout other servers read and write
+type Format string
+
+const (
+	// FormatFosilo is our own bans.json
+	FormatFosilo Format = "fosilo"
+	// FormatPyspades is the bans.txt of pyspades and piqueserver, a list of
+	// [network, [name, reason, expiry]] with expiry in unix seconds or null
+	FormatPyspades Format = "pyspades"
+	// FormatPiqueserver is what piqueserver's ban publishing serves, a list
+	// of {"ip", "name", "reason"} objects of permanent bans
+	FormatPiqueserver Format = "piqueserver"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatFosilo, FormatPyspades, FormatPiqueserver:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown ban list format %q, expected fosilo, pyspades or piqueserver", s)
+}
+
+type piqueserverBan struct {
+	IP     string `json:"ip"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Decode parses a ban list, dropping bans that have already expired
+func Decode(data []byte, format Format) ([]*Ban, error) {
+	now := time.Now()
+	var bans []*Ban
+
+	switch format {
+	case FormatFosilo:
+		if err := json.Unmarshal(data, &bans); err != nil {
+			return nil, fmt.Errorf("failed to parse ban list: %w", err)
+		}
+		for _, ban := range bans {
+			if ban.Type == "" {
+				ban.Type = BanTypeIP
+			}
+		}
+
+	case FormatPyspades:
+		var entries [][2]json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse ban list: %w", err)
+		}
+		for i, entry := range entries {
+			var network string
+			var values []any
+			if err := json.Unmarshal(entry[0], &network); err != nil {
+				return nil, fmt.Errorf("ban %d: %w", i, err)
+			}
+			if err := json.Unmarshal(entry[1], &values); err != nil {
+				return nil, fmt.Errorf("ban %d: %w", i, err)
+			}
+
+			ban := addressBan(network)
+			ban.Permanent = true
+			if len(values) > 0 {
+				ban.Name, _ = values[0].(string)
+			}
+			if len(values) > 1 {
+				ban.Reason, _ = values[1].(string)
+			}
+			if len(values) > 2 {
+				if expiry, ok := values[2].(float64); ok {
+					ban.Permanent = false
+					ban.ExpiresAt = time.Unix(0, int64(expiry*float64(time.Second)))
+				}
+			}
+			bans = append(bans, ban)
+		}
+
+	case FormatPiqueserver:
+		var entries []piqueserverBan
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse ban list: %w", err)
+		}
+		for _, entry := range entries {
+			ban := addressBan(entry.IP)
+			ban.Name = entry.Name
+			ban.Reason = entry.Reason
+			ban.Permanent = true
+			bans = append(bans, ban)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown ban list format %q", format)
+	}
+
+	kept := bans[:0]
+	for _, ban := range bans {
+		if !ban.expired(now) {
+			kept = append(kept, ban)
+		}
+	}
+	return kept, nil
+}
+
+// addressBan makes an IP or range ban for a network as pyspades writes it,
+// where single addresses may come as a /32 or /128
+func addressBan(network string) *Ban {
+	if IsRange(network) {
+		if prefix, err := ParseRange(network); err == nil && prefix.IsSingleIP() {
+			return &Ban{Type: BanTypeIP, IP: prefix.Addr().String()}
+		}
+		return &Ban{Type: BanTypeRange, IP: network}
+	}
+	if addr, err := netip.ParseAddr(network); err == nil {
+		network = addr.Unmap().String()
+	}
+	return &Ban{Type: BanTypeIP, IP: network}
+}
+
+// Encode writes bans in format. pyspades and piqueserver have no name bans
+// so those are left out, and piqueserver only carries permanent bans
+func Encode(bans []*Ban, format Format) ([]byte, error) {
+	switch format {
+	case FormatFosilo:
+		return json.MarshalIndent(bans, "", "  ")
+
+	case FormatPyspades:
+		entries := make([][2]any, 0, len(bans))
+		for _, ban := range bans {
+			if ban.Type != BanTypeIP && ban.Type != BanTypeRange {
+				continue
+			}
+			var expiry any
+			if !ban.Permanent {
+				expiry = float64(ban.ExpiresAt.UnixMilli()) / 1000
+			}
+			entries = append(entries, [2]any{ban.IP, []any{ban.Name, ban.Reason, expiry}})
+		}
+		return json.Marshal(entries)
+
+	case FormatPiqueserver:
+		entries := make([]piqueserverBan, 0, len(bans))
+		for _, ban := range bans {
+			if (ban.Type != BanTypeIP && ban.Type != BanTypeRange) || !ban.Permanent {
+				continue
+			}
+			entries = append(entries, piqueserverBan{IP: ban.IP, Name: ban.Name, Reason: ban.Reason})
+		}
+		return json.MarshalIndent(entries, "", "  ")
+	}
+
+	return nil, fmt.Errorf("unknown ban list format %q", format)
+}
+
+// Import merges a ban list into our own bans, leaving bans we already have
+// alone, and returns how many were added. Entries that cannot be used are
+// skipped and reported in the error
+func (m *Manager) Import(data []byte, format Format) (int, error) {
+	bans, err := Decode(data, format)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := 0
+	var skipped []error
+	for _, ban := range bans {
+		if m.local.has(ban) {
+			continue
+		}
+		ban.Source = ""
+		if ban.BannedBy == "" {
+			ban.BannedBy = "import"
+		}
+		if ban.BannedAt.IsZero() {
+			ban.BannedAt = time.Now()
+		}
+		if err := m.local.add(ban); err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		added++
+	}
+	if added > 0 {
+		m.recordUnlocked(audit.Entry{Action: "ban_import", Args: []string{string(format), strconv.Itoa(added)}})
+	}
+
+	if err := m.saveUnlocked(); err != nil {
+		return added, err
+	}
+	return added, errors.Join(skipped...)
+}
+
+// Export encodes our own bans in force, subscribed bans are not ours to
+// pass on
+func (m *Manager) Export(format Format) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	bans := make([]*Ban, 0)
+	for _, ban := range m.local.all() {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].BannedAt.Before(bans[j].BannedAt) })
+
+	return Encode(bans, format)
+}
@@ -0,0 +1,114 @@
+package bans
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// a subscribed list larger than this is refused rather than read into memory
+const maxListSize = 16 << 20
+
+// Subscription is a read-only ban list shared by another server
+type Subscription struct {
+	// Name tags every ban from the list
+	Name string
+	// Location is an http(s) URL or a path on disk
+	Location string
+	Format   Format
+}
+
+// Fetch downloads or reads the list
+func (s Subscription) Fetch(ctx context.Context, client *http.Client) ([]*Ban, error) {
+	var data []byte
+	if strings.HasPrefix(s.Location, "http://") || strings.HasPrefix(s.Location, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Location, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: %s", s.Location, resp.Status)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(s.Location); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) > maxListSize {
+		return nil, fmt.Errorf("ban list %s is larger than %d bytes", s.Location, maxListSize)
+	}
+	return Decode(data, s.Format)
+}
+
+// Refresh fetches the list and puts it in place of what was fetched before,
+// a failed fetch keeps the previous list. It returns how many bans the list
+// holds now
+func (m *Manager) Refresh(ctx context.Context, client *http.Client, s Subscription) (int, error) {
+	bans, err := s.Fetch(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	return m.SetSubscribed(s.Name, bans), nil
+}
+
+// SetSubscribed replaces the bans of a subscribed source, tagging each with
+// it, and returns how many were usable
+func (m *Manager) SetSubscribed(source string, bans []*Ban) int {
+	set := newBanSet()
+	for _, ban := range bans {
+		ban.Source = source
+		// entries this server cannot make sense of are left out
+		_ = set.add(ban)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscribed[source] = set
+	return len(set.all())
+}
+
+// SetExclusions lists addresses, ranges, names and name patterns that
+// subscribed bans do not apply to on this server. An excluded address or name
+// is let in even when a subscribed range or pattern covers it, an excluded
+// range or pattern drops the subscribed ban written exactly like it
+func (m *Manager) SetExclusions(entries []string) {
+	exclusions := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if IsRange(entry) {
+			if prefix, err := ParseRange(entry); err == nil {
+				entry = prefix.String()
+			}
+		} else {
+			// names and patterns ignore case, addresses are written in
+			// lower case anyway
+			entry = strings.ToLower(entry)
+		}
+		exclusions[entry] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exclusions = exclusions
+}
+
+func (m *Manager) excludedUnlocked(ban *Ban) bool {
+	return m.exclusions[ban.key()]
+}
@@ -0,0 +1,170 @@
+package bans
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ipTrie is a binary trie over address bits, IPv4 and IPv6 have separate
+// roots so a /8 of one never covers the other
+type ipTrie struct {
+	v4, v6 *ipNode
+}
+
+type ipNode struct {
+	children [2]*ipNode
+	ban      *Ban
+}
+
+func bitAt(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
+
+func (t *ipTrie) root(addr netip.Addr, create bool) **ipNode {
+	if addr.Is4() {
+		if t.v4 == nil && create {
+			t.v4 = &ipNode{}
+		}
+		return &t.v4
+	}
+	if t.v6 == nil && create {
+		t.v6 = &ipNode{}
+	}
+	return &t.v6
+}
+
+func (t *ipTrie) insert(prefix netip.Prefix, ban *Ban) {
+	node := *t.root(prefix.Addr(), true)
+	addr := prefix.Addr().AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := bitAt(addr, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &ipNode{}
+		}
+		node = node.children[bit]
+	}
+	node.ban = ban
+}
+
+func (t *ipTrie) remove(prefix netip.Prefix) {
+	node := *t.root(prefix.Addr(), false)
+	addr := prefix.Addr().AsSlice()
+	for i := 0; i < prefix.Bits() && node != nil; i++ {
+		node = node.children[bitAt(addr, i)]
+	}
+	if node != nil {
+		node.ban = nil
+	}
+}
+
+// lookup returns the most specific range still in force that covers addr
+func (t *ipTrie) lookup(addr netip.Addr, now time.Time) *Ban {
+	node := *t.root(addr, false)
+	raw := addr.AsSlice()
+	var found *Ban
+	for i := 0; node != nil; i++ {
+		if node.ban != nil && !node.ban.expired(now) {
+			found = node.ban
+		}
+		if i == len(raw)*8 {
+			break
+		}
+		node = node.children[bitAt(raw, i)]
+	}
+	return found
+}
+
+// nameTrie files wildcard patterns under their literal prefix, a lookup
+// only tries the patterns whose prefix the name starts with
+type nameTrie struct {
+	root nameNode
+}
+
+type nameNode struct {
+	children map[byte]*nameNode
+	patterns []*Ban
+}
+
+// literalPrefix is the part of a pattern before its first wildcard
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func (t *nameTrie) insert(pattern string, ban *Ban) {
+	node := &t.root
+	for _, c := range []byte(literalPrefix(pattern)) {
+		if node.children == nil {
+			node.children = make(map[byte]*nameNode)
+		}
+		child, ok := node.children[c]
+		if !ok {
+			child = &nameNode{}
+			node.children[c] = child
+		}
+		node = child
+	}
+	node.patterns = append(node.patterns, ban)
+}
+
+func (t *nameTrie) remove(pattern string) {
+	node := &t.root
+	for _, c := range []byte(literalPrefix(pattern)) {
+		if node = node.children[c]; node == nil {
+			return
+		}
+	}
+	kept := node.patterns[:0]
+	for _, ban := range node.patterns {
+		if ban.Name != pattern {
+			kept = append(kept, ban)
+		}
+	}
+	node.patterns = kept
+}
+
+func (t *nameTrie) lookup(name string, now time.Time) *Ban {
+	node := &t.root
+	for i := 0; node != nil; i++ {
+		for _, ban := range node.patterns {
+			if !ban.expired(now) && matchWildcard(ban.Name, name) {
+				return ban
+			}
+		}
+		if i == len(name) {
+			break
+		}
+		node = node.children[name[i]]
+	}
+	return nil
+}
+
+// matchWildcard reports whether name matches pattern, where * stands for any
+// run of characters and ? for exactly one
+func matchWildcard(pattern, name string) bool {
+	star, resume := -1, 0
+	p, n := 0, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, resume = p, n
+			p++
+		case star >= 0:
+			p = star + 1
+			resume++
+			n = resume
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,179 @@
+// Package demo reads and writes match recordings in the aos_replay format
+// used by pyspades and piqueserver tooling: a two byte header with the file
+// and protocol versions, then one record per packet holding a float32
+// timestamp in seconds, a uint16 length and the raw packet bytes
+package demo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	FileVersion = 1
+
+	// buffered packets are written out at least this often so a crash
+	// loses little of the match
+	flushInterval = time.Second
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported demo file version")
+
+// Recorder appends timestamped packets to a demo file, it is safe for
+// concurrent use
+type Recorder struct {
+	mu        sync.Mutex
+	file      *os.File
+	w         *bufio.Writer
+	path      string
+	start     time.Time
+	lastFlush time.Time
+	packets   int
+}
+
+// Create starts a new demo file at path for the given protocol version
+func Create(path string, protocolVersion uint8) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create demo file: %w", err)
+	}
+
+	w := bufio.NewWriter(file)
+	if _, err := w.Write([]byte{FileVersion, protocolVersion}); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write demo header: %w", err)
+	}
+
+	now := time.Now()
+	return &Recorder{
+		file:      file,
+		w:         w,
+		path:      path,
+		start:     now,
+		lastFlush: now,
+	}, nil
+}
+
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Packets returns how many packets have been recorded so far
+func (r *Recorder) Packets() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.packets
+}
+
+// WritePacket records data as received at the current time
+func (r *Recorder) WritePacket(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data) > math.MaxUint16 {
+		return fmt.Errorf("packet too large for demo: %d bytes", len(data))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.w == nil {
+		return fmt.Errorf("demo recorder closed")
+	}
+
+	now := time.Now()
+	var header [6]byte
+	binary.LittleEndian.PutUint32(header[0:4], math.Float32bits(float32(now.Sub(r.start).Seconds())))
+	binary.LittleEndian.PutUint16(header[4:6], uint16(len(data)))
+
+	if _, err := r.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := r.w.Write(data); err != nil {
+		return err
+	}
+	r.packets++
+
+	if now.Sub(r.lastFlush) >= flushInterval {
+		r.lastFlush = now
+		return r.w.Flush()
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.w == nil {
+		return nil
+	}
+
+	flushErr := r.w.Flush()
+	closeErr := r.file.Close()
+	r.w = nil
+
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// Packet is one recorded packet and how long after the start it was sent
+type Packet struct {
+	Time time.Duration
+	Data []byte
+}
+
+type Reader struct {
+	r               *bufio.Reader
+	ProtocolVersion uint8
+}
+
+// NewReader reads the demo header from r
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read demo header: %w", err)
+	}
+	if header[0] != FileVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header[0])
+	}
+
+	return &Reader{r: br, ProtocolVersion: header[1]}, nil
+}
+
+// Next returns the next packet, or io.EOF once the recording ends
+func (r *Reader) Next() (Packet, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// a recording cut short by a crash ends at its last whole packet
+			return Packet{}, io.EOF
+		}
+		return Packet{}, err
+	}
+
+	seconds := math.Float32frombits(binary.LittleEndian.Uint32(header[0:4]))
+	data := make([]byte, binary.LittleEndian.Uint16(header[4:6]))
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return Packet{}, io.EOF
+		}
+		return Packet{}, err
+	}
+
+	return Packet{
+		Time: time.Duration(float64(seconds) * float64(time.Second)),
+		Data: data,
+	}, nil
+}
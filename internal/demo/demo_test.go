@@ -0,0 +1,60 @@
+package demo
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "match.demo")
+
+	rec, err := Create(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{{18, 1, 2, 3}, {2}, bytes.Repeat([]byte{19}, 9000)}
+	for _, p := range packets {
+		if err := rec.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	r, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ProtocolVersion != 3 {
+		t.Fatalf("expected protocol version 3, got %d", r.ProtocolVersion)
+	}
+
+	var last Packet
+	for i, want := range packets {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got.Data, want) {
+			t.Fatalf("packet %d: data mismatch", i)
+		}
+		if got.Time < last.Time {
+			t.Fatalf("packet %d: timestamps went backwards", i)
+		}
+		last = got
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last packet, got %v", err)
+	}
+}
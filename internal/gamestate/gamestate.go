@@ -8,11 +8,13 @@ import (
 	"github.com/siohaza/fosilo/internal/player"
 	"github.com/siohaza/fosilo/internal/protocol"
 	"github.com/siohaza/fosilo/pkg/config"
+	"github.com/siohaza/fosilo/pkg/nav"
 	"github.com/siohaza/fosilo/pkg/vxl"
 )
 
 type GameState struct {
 	Map              *vxl.Map
+	Nav              *nav.Graph
 	MapConfig        *config.MapConfig
 	Config           *config.Config
 	Gamemode         config.GamemodeID
@@ -23,9 +25,11 @@ type GameState struct {
 	Intel            [2]Intel
 	IntelSpawnPos    [2]protocol.Vector3f
 	Base             [2]protocol.Vector3f
+	Territories      []Territory
 	Grenades         []*Grenade
 	RoundStartTime   time.Time
 	TimeLimitReached bool
+	protected        map[sector]bool
 	rng              *rand.Rand
 	mu               sync.RWMutex
 }
@@ -37,6 +41,13 @@ type Intel struct {
 	Team      uint8
 }
 
+// Territory is a control point as the gamemode script reports it, Team is
+// 255 while nobody holds it
+type Territory struct {
+	Position protocol.Vector3f
+	Team     uint8
+}
+
 type Grenade struct {
 	Position    protocol.Vector3f
 	Velocity    protocol.Vector3f
@@ -58,6 +69,7 @@ func New(cfg *config.Config, mapCfg *config.MapConfig, vxlMap *vxl.Map) *GameSta
 
 	gs := &GameState{
 		Map:            vxlMap,
+		Nav:            nav.New(vxlMap),
 		MapConfig:      mapCfg,
 		Config:         cfg,
 		Players:        player.NewManager(),
@@ -69,6 +81,7 @@ func New(cfg *config.Config, mapCfg *config.MapConfig, vxlMap *vxl.Map) *GameSta
 	}
 
 	gs.initializeGamemode()
+	gs.initProtection()
 	return gs
 }
 
@@ -80,6 +93,10 @@ func (gs *GameState) initializeGamemode() {
 		gs.initBabel()
 	case config.GamemodeTDM, config.GamemodeTC, config.GamemodeArena:
 		gs.initTDM()
+	case config.GamemodePush:
+		gs.initPush()
+	case config.GamemodeMurderball:
+		gs.initMurderball()
 	}
 }
 
@@ -223,6 +240,153 @@ func (gs *GameState) initTDM() {
 	gs.Base[1] = protocol.Vector3f{X: team2BaseX, Y: team2BaseY, Z: team2BaseZ}
 }
 
+// push follows the piqueserver layout: each team's intel sits on its own
+// checkpoint, which push maps put out by the enemy spawn, and the enemy
+// carries it across the map to their checkpoint. Players spawn in the push
+// spawn ranges rather than at the bases
+func (gs *GameState) initPush() {
+	ext := gs.MapConfig.Extensions
+	if len(ext.PushBlueCP) < 3 || len(ext.PushGreenCP) < 3 ||
+		len(ext.PushBlueSpawn) < 3 || len(ext.PushGreenSpawn) < 3 {
+		// no push metadata on this map, fall back to regular ctf positions
+		gs.initCTF()
+		return
+	}
+
+	gs.Team1Score = 0
+	gs.Team2Score = 0
+
+	toVector := func(v []float64) protocol.Vector3f {
+		return protocol.Vector3f{X: float32(v[0]), Y: float32(v[1]), Z: float32(v[2])}
+	}
+
+	// intel[team] is the one the other team picks up
+	gs.Intel[0] = Intel{Position: toVector(ext.PushBlueCP), Held: false, Team: 0}
+	gs.Intel[1] = Intel{Position: toVector(ext.PushGreenCP), Held: false, Team: 1}
+	gs.IntelSpawnPos[0] = gs.Intel[0].Position
+	gs.IntelSpawnPos[1] = gs.Intel[1].Position
+
+	gs.Base[0] = toVector(ext.PushBlueCP)
+	gs.Base[1] = toVector(ext.PushGreenCP)
+}
+
+// murderball plays with a single neutral ball in intel[0] that either team
+// carries into the opposing goal, blue scores in the green goal and vice versa
+func (gs *GameState) initMurderball() {
+	gs.Team1Score = 0
+	gs.Team2Score = 0
+
+	ball := protocol.Vector3f{
+		X: float32(gs.MapConfig.Intel.Team1Position[0]),
+		Y: float32(gs.MapConfig.Intel.Team1Position[1]),
+		Z: float32(gs.MapConfig.Intel.Team1Position[2]),
+	}
+	if len(gs.MapConfig.Ball) >= 3 {
+		ball = protocol.Vector3f{
+			X: float32(gs.MapConfig.Ball[0]),
+			Y: float32(gs.MapConfig.Ball[1]),
+			Z: float32(gs.MapConfig.Ball[2]),
+		}
+	}
+
+	gs.Intel[0] = Intel{Position: ball, Held: false, Team: 0}
+
+	hiddenPos := protocol.Vector3f{X: 1e9, Y: 1e9, Z: 128}
+	gs.Intel[1] = Intel{Position: hiddenPos, Held: true, Team: 1}
+
+	gs.IntelSpawnPos[0] = ball
+	gs.IntelSpawnPos[1] = hiddenPos
+
+	gs.Base[0] = protocol.Vector3f{
+		X: float32(gs.MapConfig.Intel.Team1Base[0]),
+		Y: float32(gs.MapConfig.Intel.Team1Base[1]),
+		Z: float32(gs.MapConfig.Intel.Team1Base[2]),
+	}
+	gs.Base[1] = protocol.Vector3f{
+		X: float32(gs.MapConfig.Intel.Team2Base[0]),
+		Y: float32(gs.MapConfig.Intel.Team2Base[1]),
+		Z: float32(gs.MapConfig.Intel.Team2Base[2]),
+	}
+	if center, ok := gs.goalCenter(gs.MapConfig.GreenGoal); ok {
+		gs.Base[0] = center
+	}
+	if center, ok := gs.goalCenter(gs.MapConfig.BlueGoal); ok {
+		gs.Base[1] = center
+	}
+}
+
+func (gs *GameState) goalCenter(box []float64) (protocol.Vector3f, bool) {
+	if len(box) < 6 {
+		return protocol.Vector3f{}, false
+	}
+	x := (box[0] + box[3]) / 2
+	y := (box[1] + box[4]) / 2
+	return protocol.Vector3f{
+		X: float32(x),
+		Y: float32(y),
+		Z: float32(gs.Map.FindGroundLevel(int(x), int(y))),
+	}, true
+}
+
+// InGoal reports whether pos is inside the goal volume the team scores in,
+// the second value is false when the map defines no goal for the team
+func (gs *GameState) InGoal(team uint8, pos protocol.Vector3f) (bool, bool) {
+	goal := gs.MapConfig.GreenGoal
+	if team == 1 {
+		goal = gs.MapConfig.BlueGoal
+	}
+	if len(goal) < 6 {
+		return false, false
+	}
+
+	x, y, z := float64(pos.X), float64(pos.Y), float64(pos.Z)
+	return x >= min(goal[0], goal[3]) && x <= max(goal[0], goal[3]) &&
+		y >= min(goal[1], goal[4]) && y <= max(goal[1], goal[4]) &&
+		z >= min(goal[2], goal[5]) && z <= max(goal[2], goal[5]), true
+}
+
+// InPenaltyArea reports whether the column at x, y lies in one of the map's
+// murderball penalty areas
+func (gs *GameState) InPenaltyArea(x, y int) bool {
+	fx, fy := float64(x), float64(y)
+	for _, area := range gs.MapConfig.PenaltyAreas {
+		if len(area) < 4 {
+			continue
+		}
+		if fx >= min(area[0], area[2]) && fx < max(area[0], area[2]) &&
+			fy >= min(area[1], area[3]) && fy < max(area[1], area[3]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (gs *GameState) pushSpawnPosition(team uint8) (protocol.Vector3f, bool) {
+	ext := gs.MapConfig.Extensions
+	spawn := ext.PushBlueSpawn
+	if team == 1 {
+		spawn = ext.PushGreenSpawn
+	}
+	if len(spawn) < 2 {
+		return protocol.Vector3f{}, false
+	}
+
+	spawnRange := 5
+	if ext.PushSpawnRange != nil && *ext.PushSpawnRange >= 0 {
+		spawnRange = *ext.PushSpawnRange
+	}
+
+	x := int(spawn[0]) + gs.rng.Intn(spawnRange*2+1) - spawnRange
+	y := int(spawn[1]) + gs.rng.Intn(spawnRange*2+1) - spawnRange
+	x = max(0, min(x, gs.Map.Width()-1))
+	y = max(0, min(y, gs.Map.Height()-1))
+
+	groundZ := gs.Map.FindGroundLevel(x, y)
+	spawnZ := gs.findValidSpawnZ(x, y, groundZ)
+
+	return protocol.Vector3f{X: float32(x) + 0.5, Y: float32(y) + 0.5, Z: float32(spawnZ) - 0.4}, true
+}
+
 func (gs *GameState) isValidSpawnPoint(x, y, z int) bool {
 	if x < 0 || x >= gs.Map.Width() || y < 0 || y >= gs.Map.Height() {
 		return false
@@ -280,6 +444,12 @@ func (gs *GameState) GetSpawnPosition(team uint8) protocol.Vector3f {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
 
+	if gs.Gamemode == config.GamemodePush {
+		if pos, ok := gs.pushSpawnPosition(team); ok {
+			return pos
+		}
+	}
+
 	var spawnPoints [][]float64
 	if team == 0 {
 		spawnPoints = gs.MapConfig.SpawnPoints.Team1Points
@@ -342,7 +512,7 @@ func (gs *GameState) PickupIntel(playerID uint8, team uint8) bool {
 	}
 
 	var intelToCheck uint8
-	if gs.Gamemode == config.GamemodeBabel {
+	if gs.Gamemode.SingleIntel() {
 		intelToCheck = 0
 	} else {
 		intelToCheck = 1 - team
@@ -385,7 +555,7 @@ func (gs *GameState) DropIntel(team uint8, position protocol.Vector3f) {
 	}
 
 	var intelSlot uint8
-	if gs.Gamemode == config.GamemodeBabel {
+	if gs.Gamemode.SingleIntel() {
 		intelSlot = 0
 	} else {
 		intelSlot = team
@@ -426,11 +596,11 @@ func (gs *GameState) CaptureIntel(playerID uint8, team uint8) bool {
 		return false
 	}
 
-	// for babel, check intel[0] (center flag)
+	// for babel and murderball, check intel[0] (center flag or ball)
 	// for ctf/tdm, check opposite team's intel
 	var intelToCheck uint8
 	gm := gs.Gamemode
-	if gm == config.GamemodeBabel {
+	if gm.SingleIntel() {
 		intelToCheck = 0
 	} else {
 		intelToCheck = 1 - team
@@ -638,6 +808,25 @@ func (gs *GameState) GetBase(team uint8) protocol.Vector3f {
 	return gs.Base[team]
 }
 
+// SetTerritory records where territory id is and who holds it
+func (gs *GameState) SetTerritory(id uint8, position protocol.Vector3f, team uint8) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	for len(gs.Territories) <= int(id) {
+		gs.Territories = append(gs.Territories, Territory{Team: 255})
+	}
+	gs.Territories[id] = Territory{Position: position, Team: team}
+}
+
+// GetTerritories returns a copy of the territories the script has set
+func (gs *GameState) GetTerritories() []Territory {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	return append([]Territory(nil), gs.Territories...)
+}
+
 func (gs *GameState) ResetScores() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -654,8 +843,8 @@ func (gs *GameState) ResetIntel() {
 	gs.Intel[0].Position = gs.IntelSpawnPos[0]
 	gs.Intel[0].CarrierID = 0
 
-	// in babel intel[1] remains perma hidden
-	if gs.Gamemode != config.GamemodeBabel {
+	// in babel and murderball intel[1] remains perma hidden
+	if !gs.Gamemode.SingleIntel() {
 		gs.Intel[1].Held = false
 		gs.Intel[1].Position = gs.IntelSpawnPos[1]
 		gs.Intel[1].CarrierID = 0
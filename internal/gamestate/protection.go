@@ -0,0 +1,99 @@
+package gamestate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maps are split into a grid of 64x64 sectors, columns lettered from A and
+// rows numbered from 1 like the in-game map overlay
+const sectorSize = 64
+
+type sector struct {
+	col, row int
+}
+
+func (s sector) String() string {
+	return fmt.Sprintf("%c%d", 'A'+s.col, s.row+1)
+}
+
+func (gs *GameState) parseSector(name string) (sector, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if len(name) < 2 {
+		return sector{}, fmt.Errorf("invalid sector %q", name)
+	}
+
+	col := int(name[0] - 'A')
+	row, err := strconv.Atoi(name[1:])
+	if err != nil {
+		return sector{}, fmt.Errorf("invalid sector %q", name)
+	}
+	row--
+
+	cols, rows := gs.sectorGrid()
+	if col < 0 || col >= cols || row < 0 || row >= rows {
+		return sector{}, fmt.Errorf("sector %q is outside the map", name)
+	}
+
+	return sector{col: col, row: row}, nil
+}
+
+func (gs *GameState) sectorGrid() (int, int) {
+	if gs.Map == nil {
+		return 8, 8
+	}
+	return (gs.Map.Width() + sectorSize - 1) / sectorSize, (gs.Map.Height() + sectorSize - 1) / sectorSize
+}
+
+func (gs *GameState) initProtection() {
+	gs.protected = make(map[sector]bool)
+	if gs.MapConfig == nil {
+		return
+	}
+	for _, name := range gs.MapConfig.Protected {
+		if sec, err := gs.parseSector(name); err == nil {
+			gs.protected[sec] = true
+		}
+	}
+}
+
+func (gs *GameState) IsProtected(x, y int) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	if len(gs.protected) == 0 || x < 0 || y < 0 {
+		return false
+	}
+	return gs.protected[sector{col: x / sectorSize, row: y / sectorSize}]
+}
+
+func (gs *GameState) SetSectorProtected(name string, protected bool) error {
+	sec, err := gs.parseSector(name)
+	if err != nil {
+		return err
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if protected {
+		gs.protected[sec] = true
+	} else {
+		delete(gs.protected, sec)
+	}
+	return nil
+}
+
+func (gs *GameState) ProtectedSectors() []string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	names := make([]string, 0, len(gs.protected))
+	for sec := range gs.protected {
+		names = append(names, sec.String())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,90 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codecat/go-enet"
+)
+
+// Client is an outgoing ENet connection to a game server
+type Client struct {
+	host enet.Host
+	peer enetPeer
+}
+
+// Dial connects to host:port, data is the connect payload which the AoS
+// protocol uses to carry the protocol version
+func Dial(host string, port int, data uint32, timeout time.Duration) (*Client, error) {
+	enetHost, err := enet.NewHost(nil, 1, 1, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ENet host: %w", err)
+	}
+
+	if err := enetHost.CompressWithRangeCoder(); err != nil {
+		enetHost.Destroy()
+		return nil, fmt.Errorf("failed to setup range coder compression: %w", err)
+	}
+
+	peer, err := enetHost.Connect(enet.NewAddress(host, uint16(port)), 1, data)
+	if err != nil {
+		enetHost.Destroy()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		event := enetHost.Service(uint32(50))
+		if event == nil {
+			continue
+		}
+		switch event.GetType() {
+		case enet.EventConnect:
+			return &Client{host: enetHost, peer: enetPeer{peer: peer}}, nil
+		case enet.EventDisconnect:
+			enetHost.Destroy()
+			return nil, fmt.Errorf("connection refused (reason %d)", event.GetData())
+		}
+	}
+
+	peer.DisconnectNow(0)
+	enetHost.Destroy()
+	return nil, fmt.Errorf("connection to %s:%d timed out", host, port)
+}
+
+func (c *Client) Send(data []byte, reliable bool) error {
+	return c.peer.Send(data, reliable)
+}
+
+// Service waits up to timeout for the next packet or disconnect from the server
+func (c *Client) Service(timeout time.Duration) (*Event, error) {
+	enetEvent := c.host.Service(uint32(timeout.Milliseconds()))
+	if enetEvent == nil {
+		return &Event{Type: EventTypeNone}, nil
+	}
+
+	event := &Event{Peer: c.peer}
+
+	switch enetEvent.GetType() {
+	case enet.EventDisconnect:
+		event.Type = EventTypeDisconnect
+		event.Reason = enetEvent.GetData()
+
+	case enet.EventReceive:
+		event.Type = EventTypeReceive
+		packet := enetEvent.GetPacket()
+		if packet != nil {
+			event.Data = packet.GetData()
+			event.ChannelID = enetEvent.GetChannelID()
+			packet.Destroy()
+		}
+	}
+
+	return event, nil
+}
+
+func (c *Client) Disconnect() {
+	c.peer.Disconnect(0)
+	c.host.Service(100)
+	c.host.Destroy()
+}
@@ -0,0 +1,27 @@
+package network
+
+// #cgo !windows pkg-config: libenet
+// #include <enet/enet.h>
+import "C"
+
+import (
+	"reflect"
+
+	"github.com/codecat/go-enet"
+)
+
+// reliableInTransit reads how many reliable bytes ENet has sent to the peer
+// without an acknowledgement. go-enet keeps the ENetPeer pointer unexported,
+// its peer type is a struct holding only that pointer so it is read from
+// there. ok is false when go-enet's peer no longer looks like that
+func reliableInTransit(peer enet.Peer) (uint32, bool) {
+	v := reflect.ValueOf(peer)
+	if v.Kind() != reflect.Struct || v.NumField() != 1 || v.Field(0).Kind() != reflect.Pointer {
+		return 0, false
+	}
+	cPeer := (*C.ENetPeer)(v.Field(0).UnsafePointer())
+	if cPeer == nil {
+		return 0, false
+	}
+	return uint32(cPeer.reliableDataInTransit), true
+}
@@ -0,0 +1,333 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	loopbackDefaultAddress = "127.0.0.1"
+	// clients dialing without a version speak 0.75
+	loopbackDefaultVersion = 3
+)
+
+var _ Transport = (*Loopback)(nil)
+
+// Loopback is an in-process transport, clients attach with Dial and exchange
+// packets through memory queues instead of sockets
+type Loopback struct {
+	mu      sync.Mutex
+	started bool
+	peers   map[*loopbackPeer]struct{}
+	events  *eventQueue
+}
+
+func NewLoopback() *Loopback {
+	return &Loopback{
+		peers:  make(map[*loopbackPeer]struct{}),
+		events: newEventQueue(),
+	}
+}
+
+func (l *Loopback) Start() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.started {
+		return fmt.Errorf("loopback already started")
+	}
+	l.started = true
+	return nil
+}
+
+func (l *Loopback) Stop() {
+	l.mu.Lock()
+	peers := make([]*loopbackPeer, 0, len(l.peers))
+	for peer := range l.peers {
+		peers = append(peers, peer)
+	}
+	l.started = false
+	l.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.DisconnectNow(0)
+	}
+}
+
+func (l *Loopback) Service(timeout time.Duration) (*Event, error) {
+	l.mu.Lock()
+	started := l.started
+	l.mu.Unlock()
+	if !started {
+		return nil, fmt.Errorf("server not started")
+	}
+
+	event := l.events.pop(timeout)
+	if event == nil {
+		return &Event{Type: EventTypeNone}, nil
+	}
+	return event, nil
+}
+
+func (l *Loopback) SendPacket(peer Peer, data []byte, reliable bool) error {
+	if peer == nil {
+		return fmt.Errorf("peer is nil")
+	}
+	return peer.Send(data, reliable)
+}
+
+func (l *Loopback) Broadcast(data []byte, reliable bool) error {
+	l.mu.Lock()
+	if !l.started {
+		l.mu.Unlock()
+		return fmt.Errorf("server not started")
+	}
+	peers := make([]*loopbackPeer, 0, len(l.peers))
+	for peer := range l.peers {
+		peers = append(peers, peer)
+	}
+	l.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.Send(data, reliable)
+	}
+	return nil
+}
+
+func (l *Loopback) DisconnectPeer(peer Peer, immediate bool) {
+	l.DisconnectPeerWithReason(peer, immediate, 0)
+}
+
+func (l *Loopback) DisconnectPeerWithReason(peer Peer, immediate bool, reason uint32) {
+	if peer == nil {
+		return
+	}
+
+	if immediate {
+		peer.DisconnectNow(reason)
+	} else {
+		peer.Disconnect(reason)
+	}
+}
+
+// Dial attaches a new client to the loopback, address is what the server
+// sees as the peer's IP and defaults to 127.0.0.1 when empty
+func (l *Loopback) Dial(address string) (*LoopbackClient, error) {
+	return l.DialVersion(address, loopbackDefaultVersion)
+}
+
+// DialVersion is Dial for a client connecting with the given protocol version
+func (l *Loopback) DialVersion(address string, version uint32) (*LoopbackClient, error) {
+	if address == "" {
+		address = loopbackDefaultAddress
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.started {
+		return nil, fmt.Errorf("server not started")
+	}
+
+	peer := &loopbackPeer{
+		transport: l,
+		address:   address,
+		inbox:     newEventQueue(),
+	}
+	l.peers[peer] = struct{}{}
+	l.events.push(&Event{Type: EventTypeConnect, Peer: peer, Version: version})
+
+	return &LoopbackClient{peer: peer}, nil
+}
+
+// detach removes the peer and reports whether it was still connected
+func (l *Loopback) detach(peer *loopbackPeer) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.peers[peer]; !ok {
+		return false
+	}
+	delete(l.peers, peer)
+	return true
+}
+
+// loopbackPeer is the server's end of a loopback connection
+type loopbackPeer struct {
+	transport *Loopback
+	address   string
+	inbox     *eventQueue
+
+	mu  sync.Mutex
+	rtt uint32
+	// reliable bytes in the inbox, the client acknowledges them by reading
+	pending uint32
+}
+
+func (p *loopbackPeer) Address() string {
+	return p.address
+}
+
+func (p *loopbackPeer) RoundTripTime() uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rtt
+}
+
+func (p *loopbackPeer) PendingReliable() (uint32, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending, true
+}
+
+func (p *loopbackPeer) Send(data []byte, reliable bool) error {
+	if !p.connected() {
+		return fmt.Errorf("peer disconnected")
+	}
+
+	if reliable {
+		p.mu.Lock()
+		p.pending += uint32(len(data))
+		p.mu.Unlock()
+	}
+
+	p.inbox.push(&Event{
+		Type:     EventTypeReceive,
+		Peer:     p,
+		Data:     append([]byte(nil), data...),
+		reliable: reliable,
+	})
+	return nil
+}
+
+func (p *loopbackPeer) Disconnect(reason uint32) {
+	if !p.transport.detach(p) {
+		return
+	}
+	p.inbox.push(&Event{Type: EventTypeDisconnect, Peer: p, Reason: reason})
+	p.transport.events.push(&Event{Type: EventTypeDisconnect, Peer: p, Reason: reason})
+}
+
+func (p *loopbackPeer) DisconnectLater(reason uint32) {
+	p.Disconnect(reason)
+}
+
+// DisconnectNow drops the peer without a disconnect event on the server side,
+// matching ENet's behaviour
+func (p *loopbackPeer) DisconnectNow(reason uint32) {
+	if !p.transport.detach(p) {
+		return
+	}
+	p.inbox.push(&Event{Type: EventTypeDisconnect, Peer: p, Reason: reason})
+}
+
+func (p *loopbackPeer) connected() bool {
+	p.transport.mu.Lock()
+	defer p.transport.mu.Unlock()
+	_, ok := p.transport.peers[p]
+	return ok
+}
+
+// LoopbackClient is the client's end of a loopback connection
+type LoopbackClient struct {
+	peer *loopbackPeer
+}
+
+// Send delivers data to the server as a receive event
+func (c *LoopbackClient) Send(data []byte, reliable bool) error {
+	if !c.peer.connected() {
+		return fmt.Errorf("peer disconnected")
+	}
+
+	c.peer.transport.events.push(&Event{
+		Type: EventTypeReceive,
+		Peer: c.peer,
+		Data: append([]byte(nil), data...),
+	})
+	return nil
+}
+
+// Service waits up to timeout for the next packet or disconnect from the server
+func (c *LoopbackClient) Service(timeout time.Duration) (*Event, error) {
+	event := c.peer.inbox.pop(timeout)
+	if event == nil {
+		return &Event{Type: EventTypeNone}, nil
+	}
+	if event.reliable {
+		c.peer.mu.Lock()
+		c.peer.pending -= uint32(len(event.Data))
+		c.peer.mu.Unlock()
+	}
+	return event, nil
+}
+
+func (c *LoopbackClient) Disconnect() {
+	if !c.peer.transport.detach(c.peer) {
+		return
+	}
+	c.peer.transport.events.push(&Event{Type: EventTypeDisconnect, Peer: c.peer})
+}
+
+func (c *LoopbackClient) Connected() bool {
+	return c.peer.connected()
+}
+
+// SetRoundTripTime sets the latency the server reports for this client
+func (c *LoopbackClient) SetRoundTripTime(ms uint32) {
+	c.peer.mu.Lock()
+	c.peer.rtt = ms
+	c.peer.mu.Unlock()
+}
+
+type eventQueue struct {
+	mu     sync.Mutex
+	events []*Event
+	notify chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{notify: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) push(event *Event) {
+	q.mu.Lock()
+	q.events = append(q.events, event)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) pop(timeout time.Duration) *Event {
+	var deadline <-chan time.Time
+
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			event := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+			q.mu.Unlock()
+			return event
+		}
+		q.mu.Unlock()
+
+		if timeout <= 0 {
+			return nil
+		}
+		if deadline == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		select {
+		case <-q.notify:
+		case <-deadline:
+			return nil
+		}
+	}
+}
@@ -15,21 +15,54 @@ type Server struct {
 	logger   *slog.Logger
 }
 
-type Event struct {
-	Type      EventType
-	Peer      enet.Peer
-	Data      []byte
-	ChannelID uint8
+var _ Transport = (*Server)(nil)
+
+// enetPeer adapts an ENet peer to the transport-neutral Peer interface
+type enetPeer struct {
+	peer enet.Peer
 }
 
-type EventType int
+func (p enetPeer) Address() string {
+	return p.peer.GetAddress().String()
+}
 
-const (
-	EventTypeNone EventType = iota
-	EventTypeConnect
-	EventTypeDisconnect
-	EventTypeReceive
-)
+func (p enetPeer) RoundTripTime() uint32 {
+	return p.peer.GetRoundTripTime()
+}
+
+func (p enetPeer) PendingReliable() (uint32, bool) {
+	return reliableInTransit(p.peer)
+}
+
+func (p enetPeer) Send(data []byte, reliable bool) error {
+	flags := enet.PacketFlagUnsequenced
+	if reliable {
+		flags = enet.PacketFlagReliable
+	}
+
+	packet, err := enet.NewPacket(data, flags)
+	if err != nil {
+		return fmt.Errorf("failed to create packet: %w", err)
+	}
+
+	if err := p.peer.SendPacket(packet, 0); err != nil {
+		return fmt.Errorf("failed to send packet: %w", err)
+	}
+
+	return nil
+}
+
+func (p enetPeer) Disconnect(reason uint32) {
+	p.peer.Disconnect(reason)
+}
+
+func (p enetPeer) DisconnectLater(reason uint32) {
+	p.peer.DisconnectLater(reason)
+}
+
+func (p enetPeer) DisconnectNow(reason uint32) {
+	p.peer.DisconnectNow(reason)
+}
 
 func NewServer(port int, maxPeers int, logger *slog.Logger) (*Server, error) {
 	if logger == nil {
@@ -80,16 +113,18 @@ func (s *Server) Service(timeout time.Duration) (*Event, error) {
 	}
 
 	event := &Event{
-		Peer: enetEvent.GetPeer(),
+		Peer: enetPeer{peer: enetEvent.GetPeer()},
 	}
 
 	switch enetEvent.GetType() {
 	case enet.EventConnect:
 		event.Type = EventTypeConnect
+		event.Version = enetEvent.GetData()
 		s.logger.Debug("peer connected", "peer", enetEvent.GetPeer().GetAddress())
 
 	case enet.EventDisconnect:
 		event.Type = EventTypeDisconnect
+		event.Reason = enetEvent.GetData()
 		s.logger.Debug("peer disconnected", "peer", enetEvent.GetPeer().GetAddress())
 
 	case enet.EventReceive:
@@ -105,26 +140,12 @@ func (s *Server) Service(timeout time.Duration) (*Event, error) {
 	return event, nil
 }
 
-func (s *Server) SendPacket(peer enet.Peer, data []byte, reliable bool) error {
+func (s *Server) SendPacket(peer Peer, data []byte, reliable bool) error {
 	if peer == nil {
 		return fmt.Errorf("peer is nil")
 	}
 
-	flags := enet.PacketFlagUnsequenced
-	if reliable {
-		flags = enet.PacketFlagReliable
-	}
-
-	packet, err := enet.NewPacket(data, flags)
-	if err != nil {
-		return fmt.Errorf("failed to create packet: %w", err)
-	}
-
-	if err := peer.SendPacket(packet, 0); err != nil {
-		return fmt.Errorf("failed to send packet: %w", err)
-	}
-
-	return nil
+	return peer.Send(data, reliable)
 }
 
 func (s *Server) Broadcast(data []byte, reliable bool) error {
@@ -144,11 +165,11 @@ func (s *Server) Broadcast(data []byte, reliable bool) error {
 	return nil
 }
 
-func (s *Server) DisconnectPeer(peer enet.Peer, immediate bool) {
+func (s *Server) DisconnectPeer(peer Peer, immediate bool) {
 	s.DisconnectPeerWithReason(peer, immediate, 0)
 }
 
-func (s *Server) DisconnectPeerWithReason(peer enet.Peer, immediate bool, reason uint32) {
+func (s *Server) DisconnectPeerWithReason(peer Peer, immediate bool, reason uint32) {
 	if peer == nil {
 		return
 	}
@@ -0,0 +1,49 @@
+package network
+
+import "time"
+
+// Peer is a single remote connection as seen by the server
+type Peer interface {
+	Address() string
+	RoundTripTime() uint32
+	// PendingReliable is the reliable data sent to the peer that it has not
+	// acknowledged yet, in bytes. ok is false when the transport can't tell
+	PendingReliable() (pending uint32, ok bool)
+	Send(data []byte, reliable bool) error
+	Disconnect(reason uint32)
+	DisconnectLater(reason uint32)
+	DisconnectNow(reason uint32)
+}
+
+// Transport carries game packets between the server and its peers
+type Transport interface {
+	Start() error
+	Stop()
+	Service(timeout time.Duration) (*Event, error)
+	SendPacket(peer Peer, data []byte, reliable bool) error
+	Broadcast(data []byte, reliable bool) error
+	DisconnectPeer(peer Peer, immediate bool)
+	DisconnectPeerWithReason(peer Peer, immediate bool, reason uint32)
+}
+
+type Event struct {
+	Type      EventType
+	Peer      Peer
+	Data      []byte
+	ChannelID uint8
+	Reason    uint32
+	// Version is the protocol version a client sent along with its connect
+	Version uint32
+
+	// reliable marks loopback packets that count as pending until read
+	reliable bool
+}
+
+type EventType int
+
+const (
+	EventTypeNone EventType = iota
+	EventTypeConnect
+	EventTypeDisconnect
+	EventTypeReceive
+)
@@ -212,6 +212,19 @@ func TryUncrouch(p *player.Player, vxlMap *vxl.Map) bool {
 	return false
 }
 
+// BodyInSolid reports whether the torso and legs of a player at pos are
+// both inside solid blocks. Only the centre of the body is checked, a player
+// grazing a wall or stepping up a block overlaps it with the edge of their box
+func BodyInSolid(vxlMap *vxl.Map, pos protocol.Vector3f, crouching bool) bool {
+	eyeHeight := float32(PlayerEyeHeight)
+	if crouching {
+		eyeHeight = PlayerCrouchEye
+	}
+	torso := pos.Z + eyeHeight
+	legs := torso + 0.45
+	return clipBox(vxlMap, pos.X, pos.Y, torso) && clipBox(vxlMap, pos.X, pos.Y, legs)
+}
+
 func ValidateHit(shooter, target protocol.Vector3f, orientation protocol.Vector3f, tolerance float32) bool {
 	f := float32(math.Sqrt(float64(orientation.X*orientation.X + orientation.Y*orientation.Y)))
 	if math.Abs(float64(f)) < Epsilon {
@@ -0,0 +1,82 @@
+package player
+
+import (
+	"time"
+
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// enough for roughly two seconds of samples at the 60hz tick rate
+const positionHistorySize = 128
+
+type positionSample struct {
+	time     time.Time
+	position protocol.Vector3f
+}
+
+type positionHistory struct {
+	samples [positionHistorySize]positionSample
+	head    int
+	count   int
+}
+
+func (h *positionHistory) push(t time.Time, pos protocol.Vector3f) {
+	h.samples[h.head] = positionSample{time: t, position: pos}
+	h.head = (h.head + 1) % positionHistorySize
+	if h.count < positionHistorySize {
+		h.count++
+	}
+}
+
+// at returns the i-th most recent sample, 0 being the newest
+func (h *positionHistory) at(i int) positionSample {
+	idx := (h.head - 1 - i + positionHistorySize) % positionHistorySize
+	return h.samples[idx]
+}
+
+func (h *positionHistory) reset() {
+	h.head = 0
+	h.count = 0
+}
+
+func (p *Player) RecordPosition(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.history.push(t, p.Position)
+}
+
+// PositionAt returns where the player was at time t, interpolated between the
+// recorded samples and clamped to the oldest one still in the buffer
+func (p *Player) PositionAt(t time.Time) protocol.Vector3f {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	h := &p.history
+	if h.count == 0 {
+		return p.Position
+	}
+
+	newer := h.at(0)
+	if !t.Before(newer.time) {
+		return p.Position
+	}
+
+	for i := 1; i < h.count; i++ {
+		older := h.at(i)
+		if !t.Before(older.time) {
+			span := newer.time.Sub(older.time)
+			if span <= 0 {
+				return older.position
+			}
+			f := float32(t.Sub(older.time)) / float32(span)
+			return protocol.Vector3f{
+				X: older.position.X + (newer.position.X-older.position.X)*f,
+				Y: older.position.Y + (newer.position.Y-older.position.Y)*f,
+				Z: older.position.Z + (newer.position.Z-older.position.Z)*f,
+			}
+		}
+		newer = older
+	}
+
+	return newer.position
+}
@@ -4,9 +4,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/siohaza/fosilo/internal/network"
 	"github.com/siohaza/fosilo/internal/protocol"
-
-	"github.com/codecat/go-enet"
 )
 
 func GetClock() uint64 {
@@ -15,7 +14,7 @@ func GetClock() uint64 {
 
 type Player struct {
 	ID              uint8
-	Peer            enet.Peer
+	Peer            network.Peer
 	Name            string
 	Team            uint8
 	Weapon          protocol.WeaponType
@@ -75,14 +74,19 @@ type Player struct {
 
 	Permissions         uint64
 	LoginRetries        int
+	Account             string // registered account logged into, if any
+	Role                string // named role held, empty for none or bare permission bits
 	Muted               bool
 	Invisible           bool
+	Bot                 bool
 	Client              byte
 	Version             protocol.Vector3f
 	OSInfo              string
 	HandshakeChallenge  uint32
 	HandshakeComplete   bool
 	VersionInfoReceived bool
+	ExtensionsReceived  bool
+	ProtocolVersion     uint8
 	ClientIdentifier    byte
 	VersionMajor        int8
 	VersionMinor        int8
@@ -95,6 +99,8 @@ type Player struct {
 	LastRateLimitReset  time.Time
 	RateLimitViolations int
 
+	history positionHistory
+
 	mu sync.RWMutex
 }
 
@@ -109,7 +115,7 @@ const (
 	PlayerStateDead
 )
 
-func New(id uint8, peer enet.Peer) *Player {
+func New(id uint8, peer network.Peer) *Player {
 	return &Player{
 		ID:                  id,
 		Peer:                peer,
@@ -118,6 +124,7 @@ func New(id uint8, peer enet.Peer) *Player {
 		Blocks:              protocol.InitialBlocks,
 		Grenades:            protocol.InitialGrenades,
 		Alive:               false,
+		ProtocolVersion:     protocol.ProtocolVersion75,
 		SupportedExtensions: make(map[protocol.ExtensionID]uint8),
 		PacketCounts:        make(map[protocol.PacketType]int),
 		LastRateLimitReset:  time.Now(),
@@ -225,6 +232,7 @@ func (p *Player) Respawn(position protocol.Vector3f) {
 	p.Tool = protocol.ItemTypeGun
 	p.Reloading = false
 	p.HasIntel = false
+	p.history.reset()
 }
 
 func (p *Player) Restock() {
@@ -382,7 +390,7 @@ func (m *Manager) Get(id uint8) (*Player, bool) {
 	return player, ok
 }
 
-func (m *Manager) GetByPeer(peer enet.Peer) (*Player, bool) {
+func (m *Manager) GetByPeer(peer network.Peer) (*Player, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
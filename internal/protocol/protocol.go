@@ -11,12 +11,30 @@ import (
 )
 
 const (
-	MaxPlayers        = 32
+	// MaxPlayers is what vanilla clients handle, the world update carries
+	// exactly this many slots
+	MaxPlayers = 32
+	// MaxPlayersExtended is the cap for clients with the 256 players
+	// extension, id 255 stays reserved as "no player"
+	MaxPlayersExtended = 255
+
 	PlayerNameLen     = 16
 	TeamNameLen       = 10
 	ProtocolVersion75 = 3
+	ProtocolVersion76 = 4
 )
 
+// VersionString returns a protocol version the way players know it
+func VersionString(version uint8) string {
+	switch version {
+	case ProtocolVersion75:
+		return "0.75"
+	case ProtocolVersion76:
+		return "0.76"
+	}
+	return "unknown"
+}
+
 type PacketType uint8
 
 const (
@@ -54,6 +72,7 @@ const (
 	PacketTypeChangeWeapon     PacketType = 30
 
 	PacketTypeHandShakeInit    PacketType = 31
+	PacketTypeMapCached        PacketType = 31
 	PacketTypeHandShakeReturn  PacketType = 32
 	PacketTypeVersionRequest   PacketType = 33
 	PacketTypeVersionResponse  PacketType = 34
@@ -209,6 +228,19 @@ type PacketWorldUpdate struct {
 	Players  [MaxPlayers]PlayerPositionData
 }
 
+// WorldUpdateEntry is one player in the 256 players world update, which lists
+// only the players it carries instead of every slot. The 0.76 world update
+// has the same layout, there for the first 32 ids only
+type WorldUpdateEntry struct {
+	PlayerID uint8
+	PlayerPositionData
+}
+
+type PacketWorldUpdateExtended struct {
+	PacketID uint8
+	Players  []WorldUpdateEntry
+}
+
 type PacketInputData struct {
 	PacketID  uint8
 	PlayerID  uint8
@@ -367,6 +399,53 @@ func (p *PacketMapStart) Write(w io.Writer) error {
 	return err
 }
 
+// PacketMapStart76 is the 0.76 map start, it names the map and carries a CRC
+// of the uncompressed map so clients can answer from their cache
+type PacketMapStart76 struct {
+	PacketID uint8
+	MapSize  uint32
+	CRC32    uint32
+	MapName  string
+}
+
+func (p *PacketMapStart76) Write(w io.Writer) error {
+	name, err := StringToCP437(p.MapName)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 9, 10+len(name))
+	buf[0] = p.PacketID
+	binary.LittleEndian.PutUint32(buf[1:5], p.MapSize)
+	binary.LittleEndian.PutUint32(buf[5:9], p.CRC32)
+	buf = append(buf, name...)
+	buf = append(buf, 0)
+	_, err = w.Write(buf)
+	return err
+}
+
+func (p *PacketMapStart76) Read(data []byte) error {
+	if len(data) < 9 {
+		return fmt.Errorf("packet too short")
+	}
+	p.PacketID = data[0]
+	p.MapSize = binary.LittleEndian.Uint32(data[1:5])
+	p.CRC32 = binary.LittleEndian.Uint32(data[5:9])
+	name, err := CP437ToString(data[9:])
+	if err != nil {
+		return err
+	}
+	p.MapName = name
+	return nil
+}
+
+// PacketMapCached is the 0.76 reply to MapStart, it reuses the id 0.75
+// clients know as HandShakeInit
+type PacketMapCached struct {
+	PacketID uint8
+	Cached   uint8
+}
+
 type PacketMapChunk struct {
 	PacketID uint8
 	Data     []byte
@@ -617,6 +696,24 @@ func (p *PacketWorldUpdate) Read(r io.Reader) error {
 	return binary.Read(r, binary.LittleEndian, p)
 }
 
+func (p *PacketWorldUpdateExtended) Write(w io.Writer) error {
+	if _, err := w.Write([]byte{p.PacketID}); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, p.Players)
+}
+
+func (p *PacketWorldUpdateExtended) Read(data []byte) error {
+	const entrySize = 25
+	if len(data) < 1 || (len(data)-1)%entrySize != 0 {
+		return fmt.Errorf("invalid extended world update length %d", len(data))
+	}
+
+	p.PacketID = data[0]
+	p.Players = make([]WorldUpdateEntry, (len(data)-1)/entrySize)
+	return binary.Read(bytes.NewReader(data[1:]), binary.LittleEndian, p.Players)
+}
+
 func (p *PacketStateData) Write(w io.Writer) error {
 	var buf bytes.Buffer
 
@@ -717,6 +814,72 @@ func (p *PacketStateData) Write(w io.Writer) error {
 	return err
 }
 
+func (p *PacketStateData) Read(data []byte) error {
+	if len(data) < 32 {
+		return fmt.Errorf("state data packet too small")
+	}
+
+	p.PacketID = data[0]
+	p.PlayerID = data[1]
+	p.FogColor = Color3b{B: data[2], G: data[3], R: data[4]}
+	p.Team1Color = Color3b{B: data[5], G: data[6], R: data[7]}
+	p.Team2Color = Color3b{B: data[8], G: data[9], R: data[10]}
+	copy(p.Team1Name[:], data[11:21])
+	copy(p.Team2Name[:], data[21:31])
+	p.Gamemode = GamemodeType(data[31])
+
+	body := data[32:]
+	readVector := func(offset int) Vector3f {
+		return Vector3f{
+			X: math.Float32frombits(binary.LittleEndian.Uint32(body[offset:])),
+			Y: math.Float32frombits(binary.LittleEndian.Uint32(body[offset+4:])),
+			Z: math.Float32frombits(binary.LittleEndian.Uint32(body[offset+8:])),
+		}
+	}
+
+	switch p.Gamemode {
+	case GamemodeTypeCTF:
+		if len(body) < 52 {
+			return fmt.Errorf("ctf state too small")
+		}
+		p.CTFState.Team1Score = body[0]
+		p.CTFState.Team2Score = body[1]
+		p.CTFState.CaptureLimit = body[2]
+		p.CTFState.HeldIntels = body[3]
+
+		if p.CTFState.HeldIntels&1 != 0 {
+			p.CTFState.CarrierIDs[0] = body[4]
+		} else {
+			p.CTFState.Team1Intel = readVector(4)
+		}
+		if p.CTFState.HeldIntels&2 != 0 {
+			p.CTFState.CarrierIDs[1] = body[16]
+		} else {
+			p.CTFState.Team2Intel = readVector(16)
+		}
+
+		p.CTFState.Team1Base = readVector(28)
+		p.CTFState.Team2Base = readVector(40)
+
+	case GamemodeTypeTC:
+		if len(body) < 1 {
+			return fmt.Errorf("tc state too small")
+		}
+		count := int(body[0])
+		if count > len(p.TCState.Territories) || len(body) < 1+count*13 {
+			return fmt.Errorf("tc state has invalid territory count %d", count)
+		}
+		p.TCState.TerritoryCount = uint8(count)
+		for i := 0; i < count; i++ {
+			offset := 1 + i*13
+			pos := readVector(offset)
+			p.TCState.Territories[i] = Territory{X: pos.X, Y: pos.Y, Z: pos.Z, Team: body[offset+12]}
+		}
+	}
+
+	return nil
+}
+
 func (p *PacketChatMessage) Write(w io.Writer) error {
 	var buf bytes.Buffer
 	buf.WriteByte(p.PacketID)
@@ -818,6 +981,37 @@ func (p *PacketHandShakeReturn) Read(data []byte) error {
 	return nil
 }
 
+func (p *PacketHandShakeReturn) Write(w io.Writer) error {
+	var buf [5]byte
+	buf[0] = p.PacketID
+	binary.LittleEndian.PutUint32(buf[1:], p.Challenge)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func (p *PacketVersionResponse) Write(w io.Writer) error {
+	buf := []byte{
+		p.PacketID,
+		p.ClientIdentifier,
+		byte(p.VersionMajor),
+		byte(p.VersionMinor),
+		byte(p.VersionRevision),
+	}
+
+	osInfo := p.OSInfoRaw
+	if osInfo == nil && p.OSInfo != "" {
+		encoded, err := StringToCP437(p.OSInfo)
+		if err != nil {
+			return err
+		}
+		osInfo = encoded
+	}
+	buf = append(buf, osInfo...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
 func (p *PacketVersionResponse) Read(data []byte) error {
 	if len(data) < 5 {
 		return fmt.Errorf("version response packet too small")
@@ -0,0 +1,167 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// the 0.76 reference keeps StateData, ExistingPlayer and CreatePlayer byte
+// for byte as in 0.75, so these pin one layout for both versions
+
+func floatAt(t *testing.T, data []byte, offset int) float32 {
+	t.Helper()
+	if offset+4 > len(data) {
+		t.Fatalf("no float at offset %d in %d bytes", offset, len(data))
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(data[offset:]))
+}
+
+func TestExistingPlayerLayout(t *testing.T) {
+	p := PacketExistingPlayer{
+		PacketID: uint8(PacketTypeExistingPlayer),
+		PlayerID: 7,
+		Team:     1,
+		Weapon:   WeaponType(2),
+		Item:     ItemTypeBlock,
+		Kills:    0x01020304,
+		Color:    Color3b{B: 10, G: 20, R: 30},
+	}
+	copy(p.Name[:], "Deuce")
+
+	var buf bytes.Buffer
+	if err := WritePacket(&buf, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{9, 7, 1, 2, 1, 4, 3, 2, 1, 10, 20, 30, 'D', 'e', 'u', 'c', 'e'}
+	data := buf.Bytes()
+	if len(data) != 12+PlayerNameLen {
+		t.Fatalf("existing player is %d bytes, want %d", len(data), 12+PlayerNameLen)
+	}
+	if !bytes.Equal(data[:len(want)], want) {
+		t.Errorf("existing player starts % x, want % x", data[:len(want)], want)
+	}
+}
+
+func TestCreatePlayerLayout(t *testing.T) {
+	p := PacketCreatePlayer{
+		PacketID: uint8(PacketTypeCreatePlayer),
+		PlayerID: 3,
+		Weapon:   WeaponType(1),
+		Team:     0,
+		X:        1.5,
+		Y:        2.5,
+		Z:        3.5,
+	}
+	copy(p.Name[:], "Deuce")
+
+	var buf bytes.Buffer
+	if err := WritePacket(&buf, &p); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(data) != 16+PlayerNameLen {
+		t.Fatalf("create player is %d bytes, want %d", len(data), 16+PlayerNameLen)
+	}
+	if !bytes.Equal(data[:4], []byte{12, 3, 1, 0}) {
+		t.Errorf("create player header is % x, want id, player, weapon, team", data[:4])
+	}
+	for i, want := range []float32{1.5, 2.5, 3.5} {
+		if got := floatAt(t, data, 4+i*4); got != want {
+			t.Errorf("coordinate %d is %v, want %v", i, got, want)
+		}
+	}
+	if string(data[16:21]) != "Deuce" {
+		t.Errorf("name starts %q, want Deuce", data[16:21])
+	}
+}
+
+func TestStateDataCTFLayout(t *testing.T) {
+	p := PacketStateData{
+		PacketID:   uint8(PacketTypeStateData),
+		PlayerID:   5,
+		FogColor:   Color3b{B: 1, G: 2, R: 3},
+		Team1Color: Color3b{B: 4, G: 5, R: 6},
+		Team2Color: Color3b{B: 7, G: 8, R: 9},
+		Gamemode:   GamemodeTypeCTF,
+		CTFState: CTFStateData{
+			Team1Score:   2,
+			Team2Score:   4,
+			CaptureLimit: 10,
+			HeldIntels:   2,
+			CarrierIDs:   [2]uint8{0, 6},
+			Team1Intel:   Vector3f{X: 11, Y: 12, Z: 13},
+			Team1Base:    Vector3f{X: 21, Y: 22, Z: 23},
+			Team2Base:    Vector3f{X: 31, Y: 32, Z: 33},
+		},
+	}
+	copy(p.Team1Name[:], "Blue")
+	copy(p.Team2Name[:], "Green")
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if !bytes.Equal(data[:11], []byte{15, 5, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("state data header is % x", data[:11])
+	}
+	if string(data[11:15]) != "Blue" || string(data[21:26]) != "Green" {
+		t.Errorf("team names are %q and %q", data[11:21], data[21:31])
+	}
+	if data[31] != byte(GamemodeTypeCTF) {
+		t.Errorf("gamemode byte is %d", data[31])
+	}
+	if !bytes.Equal(data[32:36], []byte{2, 4, 10, 2}) {
+		t.Errorf("ctf scores are % x, want scores, limit, held flags", data[32:36])
+	}
+	for i, want := range []float32{11, 12, 13} {
+		if got := floatAt(t, data, 36+i*4); got != want {
+			t.Errorf("team 1 intel %d is %v, want %v", i, got, want)
+		}
+	}
+	// a held intel sends its carrier in place of the position
+	if data[48] != 6 || !bytes.Equal(data[49:60], make([]byte, 11)) {
+		t.Errorf("team 2 intel is % x, want carrier 6 and padding", data[48:60])
+	}
+	for i, want := range []float32{21, 22, 23, 31, 32, 33} {
+		if got := floatAt(t, data, 60+i*4); got != want {
+			t.Errorf("base coordinate %d is %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestWorldUpdate76Layout(t *testing.T) {
+	p := PacketWorldUpdateExtended{
+		PacketID: uint8(PacketTypeWorldUpdate),
+		Players: []WorldUpdateEntry{
+			{PlayerID: 4, PlayerPositionData: PlayerPositionData{X: 1, Y: 2, Z: 3}},
+			{PlayerID: 9, PlayerPositionData: PlayerPositionData{OY: 1}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(data) != 1+2*25 {
+		t.Fatalf("world update is %d bytes, want two 25 byte entries", len(data))
+	}
+	if data[0] != 2 || data[1] != 4 || data[26] != 9 {
+		t.Errorf("world update ids are %d, %d, %d", data[0], data[1], data[26])
+	}
+	if floatAt(t, data, 2) != 1 || floatAt(t, data, 10) != 3 || floatAt(t, data, 26+1+16) != 1 {
+		t.Errorf("world update entries are not id, position, orientation")
+	}
+
+	var back PacketWorldUpdateExtended
+	if err := back.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Players) != 2 || back.Players[1].PlayerID != 9 {
+		t.Errorf("read back %+v", back.Players)
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/accounts"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/pkg/lua"
+)
+
+// ownsName reports whether p is logged into the account its name belongs to
+func ownsName(p *player.Player) bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.Account != "" && strings.EqualFold(p.Account, strings.TrimSpace(p.Name))
+}
+
+// checkReservedName gives a player who joined under a registered name the
+// grace period to log in
+func (s *Server) checkReservedName(p *player.Player) {
+	if !s.config.Accounts.Enabled || p.Bot || ownsName(p) || !s.accounts.IsRegistered(p.GetName()) {
+		return
+	}
+
+	grace := time.Duration(s.config.Accounts.GracePeriod) * time.Second
+	s.nameGrace[p.ID] = time.Now().Add(grace)
+
+	verb := "renamed"
+	if s.config.Accounts.ReservedNameAction == "kick" {
+		verb = "kicked"
+	}
+	s.sendChatToPlayer(p, fmt.Sprintf("The name %s is registered. Use /login <password> within %d seconds or you will be %s",
+		p.GetName(), s.config.Accounts.GracePeriod, verb))
+}
+
+// enforceReservedNames acts on players whose grace period ran out without
+// a login
+func (s *Server) enforceReservedNames(now time.Time) {
+	for id, deadline := range s.nameGrace {
+		if now.Before(deadline) {
+			continue
+		}
+		delete(s.nameGrace, id)
+
+		p, ok := s.gameState.Players.Get(id)
+		if !ok || ownsName(p) {
+			continue
+		}
+
+		name := p.GetName()
+		s.logger.Info("player did not log in to a registered name", "player", id, "name", name,
+			"action", s.config.Accounts.ReservedNameAction)
+
+		if s.config.Accounts.ReservedNameAction == "kick" {
+			s.KickPlayer(id, "Name is registered to someone else")
+			continue
+		}
+		s.renamePlayer(p, s.freeName(id))
+		s.sendChatToPlayer(p, fmt.Sprintf("%s is registered to someone else, you are now %s", name, p.GetName()))
+	}
+}
+
+// freeName picks an unregistered stand-in name for a player
+func (s *Server) freeName(id uint8) string {
+	name := fmt.Sprintf("Deuce%d", id)
+	for s.accounts.IsRegistered(name) {
+		name += "_"
+	}
+	return name
+}
+
+// renamePlayer changes the name of a player in game. Clients only learn
+// names from create player packets, so a living player is recreated where
+// it stands, a dead one carries the new name into its next spawn
+func (s *Server) renamePlayer(p *player.Player, name string) {
+	p.Lock()
+	p.Name = name
+	p.Unlock()
+
+	if p.IsAlive() {
+		s.BroadcastCreatePlayer(p)
+	}
+}
+
+// passwords are hashed off the game loop, at most this many at once
+const maxPasswordChecks = 4
+
+// failed account logins before the player is kicked
+const maxLoginRetries = 3
+
+// checkPassword runs work, which hashes a password, away from the game loop
+// and then done with its outcome back on it as p. Every player has one check
+// going at a time and only a few run at once, so logins cannot keep the
+// server busy. done is skipped when p left in the meantime
+func (s *Server) checkPassword(p *player.Player, work func() error, done func(error)) error {
+	if s.passwordChecks[p.ID] {
+		return fmt.Errorf("your last password is still being checked")
+	}
+	if len(s.passwordChecks) >= maxPasswordChecks {
+		return fmt.Errorf("the server is busy, try again in a moment")
+	}
+	s.passwordChecks[p.ID] = true
+
+	go func() {
+		err := work()
+		s.post(func() {
+			delete(s.passwordChecks, p.ID)
+			if current, ok := s.gameState.Players.Get(p.ID); !ok || current != p {
+				return
+			}
+			s.runAs(p, func() { done(err) })
+		})
+	}()
+	return nil
+}
+
+// RegisterAccount registers the name a player is using and logs them in.
+// The outcome is sent to the player once the password is hashed
+func (s *Server) RegisterAccount(playerID uint8, password string) error {
+	if !s.config.Accounts.Enabled {
+		return fmt.Errorf("accounts are disabled on this server")
+	}
+	p, ok := s.gameState.Players.Get(playerID)
+	if !ok {
+		return fmt.Errorf("player not found")
+	}
+	if len(password) < s.config.Accounts.MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", s.config.Accounts.MinPasswordLength)
+	}
+
+	name := strings.TrimSpace(p.GetName())
+	if s.accounts.IsRegistered(name) {
+		return accounts.ErrNameTaken
+	}
+
+	return s.checkPassword(p, func() error {
+		return s.accounts.Register(name, password)
+	}, func(err error) {
+		if err != nil {
+			s.sendChatToPlayer(p, "Failed to register: "+err.Error())
+			return
+		}
+
+		p.Lock()
+		p.Account = name
+		p.Unlock()
+		delete(s.nameGrace, playerID)
+
+		s.logger.Info("account registered", "player", playerID, "name", name)
+		s.sendChatToPlayer(p, "Registered "+name+", use /login <password> when you join")
+	})
+}
+
+// LoginAccount logs a player into the account of the name they are using
+// and grants its role. The outcome is sent to the player once the password
+// is checked, too many wrong ones get them kicked
+func (s *Server) LoginAccount(playerID uint8, password string) error {
+	if !s.config.Accounts.Enabled {
+		return fmt.Errorf("accounts are disabled on this server")
+	}
+	p, ok := s.gameState.Players.Get(playerID)
+	if !ok {
+		return fmt.Errorf("player not found")
+	}
+
+	name := p.GetName()
+	if !s.accounts.IsRegistered(name) {
+		s.audit("login_failed", name, accounts.ErrNoAccount.Error())
+		return fmt.Errorf("%s is not registered, use /register <password>", name)
+	}
+
+	var account accounts.Account
+	return s.checkPassword(p, func() error {
+		var err error
+		account, err = s.accounts.Authenticate(name, password)
+		return err
+	}, func(err error) {
+		if err != nil {
+			s.audit("login_failed", name, err.Error())
+			if !errors.Is(err, accounts.ErrWrongPassword) {
+				s.sendChatToPlayer(p, "Failed to log in: "+err.Error())
+				return
+			}
+
+			p.Lock()
+			p.LoginRetries++
+			retries := p.LoginRetries
+			p.Unlock()
+			if retries >= maxLoginRetries {
+				s.KickPlayer(playerID, "Too many failed login attempts")
+				return
+			}
+			s.sendChatToPlayer(p, fmt.Sprintf("Incorrect password (%d attempts remaining)", maxLoginRetries-retries))
+			return
+		}
+
+		// the name may have changed while the password was checked
+		if !strings.EqualFold(strings.TrimSpace(p.GetName()), account.Name) {
+			s.sendChatToPlayer(p, "Your name changed, log in again")
+			return
+		}
+
+		p.Lock()
+		p.Account = account.Name
+		p.LoginRetries = 0
+		p.Unlock()
+		if err := s.luaCommands.Roles().Assign(p, account.Role); err != nil {
+			// the role was removed from the config since it was given
+			s.logger.Warn("account has an unknown role", "name", account.Name, "role", account.Role)
+		}
+		delete(s.nameGrace, playerID)
+
+		s.logger.Info("account logged in", "player", playerID, "name", account.Name, "role", account.Role)
+		s.audit("login", account.Name, account.Role)
+
+		if account.Role == "" {
+			s.sendChatToPlayer(p, "Logged in as "+account.Name)
+		} else {
+			s.sendChatToPlayer(p, "Logged in as "+account.Name+" ("+account.Role+")")
+		}
+	})
+}
+
+// SetAccountRole changes the role of an account, players logged into it
+// get the new permissions right away
+func (s *Server) SetAccountRole(name, role string) error {
+	roles := s.luaCommands.Roles()
+	if !roles.Exists(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	role = lua.NormalizeRole(role)
+	if role == "none" {
+		role = ""
+	}
+
+	if err := s.accounts.SetRole(name, role); err != nil {
+		return err
+	}
+
+	s.gameState.Players.ForEach(func(p *player.Player) {
+		p.RLock()
+		owner := p.Account != "" && strings.EqualFold(p.Account, strings.TrimSpace(name))
+		p.RUnlock()
+		if owner {
+			_ = roles.Assign(p, role)
+		}
+	})
+
+	s.logger.Info("account role changed", "name", name, "role", role)
+	return nil
+}
+
+// GetPlayerAccount returns the account a player is logged into and its role
+func (s *Server) GetPlayerAccount(playerID uint8) (string, string, bool) {
+	p, ok := s.gameState.Players.Get(playerID)
+	if !ok {
+		return "", "", false
+	}
+
+	p.RLock()
+	name := p.Account
+	p.RUnlock()
+	if name == "" {
+		return "", "", false
+	}
+
+	account, ok := s.accounts.Get(name)
+	if !ok {
+		return "", "", false
+	}
+	return account.Name, account.Role, true
+}
+
+// GetAccountRole returns the role of a registered account
+func (s *Server) GetAccountRole(name string) (string, bool) {
+	account, ok := s.accounts.Get(name)
+	if !ok {
+		return "", false
+	}
+	return account.Role, true
+}
+
+func (s *Server) AccountsEnabled() bool {
+	return s.config.Accounts.Enabled
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/anticheat"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// aimStatsFor returns the aim statistics of p, or nil when detection is off
+// or p is a bot
+func (s *Server) aimStatsFor(p *player.Player) *anticheat.AimStats {
+	if !s.config.AntiCheat.AimDetection || p.Bot {
+		return nil
+	}
+
+	stats, ok := s.aimStats[p.ID]
+	if !ok {
+		cfg := s.config.AntiCheat
+		// zero already means the default in the config, negative turns a
+		// check off there and zero does here
+		stats = anticheat.NewAimStats(anticheat.AimThresholds{
+			MinSamples:    cfg.AimMinSamples,
+			SnapSpeed:     math.Max(cfg.AimSnapSpeed, 0),
+			SnapRatio:     math.Max(cfg.AimSnapRatio, 0),
+			HeadshotRatio: math.Max(cfg.AimHeadshotRatio, 0),
+			MinReaction:   time.Duration(max(cfg.AimMinReactionMs, 0)) * time.Millisecond,
+			MaxAccuracy:   math.Max(cfg.AimMaxAccuracy, 0),
+		})
+		s.aimStats[p.ID] = stats
+	}
+	return stats
+}
+
+// recordAimHit adds a validated hit to the shooter's statistics and acts on
+// whatever now looks out of the ordinary
+func (s *Server) recordAimHit(p, target *player.Player, weapon protocol.WeaponType, hitType protocol.HitType) {
+	stats := s.aimStatsFor(p)
+	if stats == nil {
+		return
+	}
+
+	now := time.Now()
+	stats.Hit(now, weapon, hitType, s.reactionTime(p, target, now))
+
+	for _, flag := range stats.Evaluate() {
+		s.flagAim(p, flag)
+	}
+}
+
+func (s *Server) flagAim(p *player.Player, flag anticheat.Flag) {
+	name := p.GetName()
+	action := s.config.AntiCheat.AimAction
+	s.logger.Warn("suspicious aim", "player", name, "id", p.ID, "check", flag.Kind,
+		"detail", flag.Detail, "action", action)
+
+	s.recordViolation(p, anticheat.CategoryAim, flag.Detail)
+
+	if action == "log" {
+		return
+	}
+	s.notifyStaff(fmt.Sprintf("[anticheat] %s (#%d): %s", name, p.ID, flag.Detail))
+
+	reason := "Suspicious aim: " + flag.Detail
+	switch action {
+	case "kick":
+		s.KickPlayer(p.ID, reason)
+	case "ban":
+		if p.Peer != nil {
+			duration := time.Duration(s.config.AntiCheat.AimBanMinutes) * time.Minute
+			if err := s.banManager.AddBan(p.Peer.Address(), name, reason, "anticheat", duration); err != nil {
+				s.logger.Error("failed to ban player", "player", name, "error", err)
+			}
+		}
+		s.KickPlayer(p.ID, reason)
+	}
+}
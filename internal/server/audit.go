@@ -0,0 +1,71 @@
+package server
+
+import (
+	"github.com/siohaza/fosilo/internal/audit"
+	"github.com/siohaza/fosilo/internal/player"
+)
+
+// auditRecorder hands the ban manager's entries to the server so they are
+// stamped like the rest
+type auditRecorder struct {
+	s *Server
+}
+
+func (r auditRecorder) Record(e audit.Entry) error {
+	return r.s.recordAudit(e)
+}
+
+// recordAudit fills in the player whose command is running as the actor,
+// anything else is done by the server
+func (s *Server) recordAudit(e audit.Entry) error {
+	if s.auditLog == nil {
+		return nil
+	}
+
+	if p := s.actor.Load(); p != nil {
+		if e.Actor == "" {
+			e.Actor = p.GetName()
+		}
+		if e.IP == "" && p.Peer != nil {
+			e.IP = p.Peer.Address()
+		}
+	}
+	if e.Actor == "" {
+		e.Actor = "server"
+	}
+
+	if err := s.auditLog.Record(e); err != nil {
+		s.logger.Error("failed to write audit log", "action", e.Action, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Server) audit(action, target string, args ...string) {
+	_ = s.recordAudit(audit.Entry{Action: action, Target: target, Args: args})
+}
+
+// Audit records a privileged call of a Lua script. Only calls made while a
+// player's command runs are kept, gamemodes move and heal players all the
+// time and that is not administration
+func (s *Server) Audit(action, target string, args []string) {
+	if s.actor.Load() == nil {
+		return
+	}
+	s.audit(action, target, args...)
+}
+
+// runAs runs fn with p as the actor of whatever it records
+func (s *Server) runAs(p *player.Player, fn func()) {
+	s.actor.Store(p)
+	defer s.actor.Store(nil)
+	fn()
+}
+
+// AuditLog returns up to n of the latest entries about who, newest first
+func (s *Server) AuditLog(who string, n int) ([]audit.Entry, error) {
+	if s.auditLog == nil {
+		return nil, nil
+	}
+	return s.auditLog.Tail(who, n)
+}
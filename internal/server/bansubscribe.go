@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/bans"
+	"github.com/siohaza/fosilo/pkg/config"
+)
+
+// followBanLists keeps every configured subscription fresh until the server
+// stops
+func (s *Server) followBanLists() {
+	s.banManager.SetExclusions(s.config.Bans.Exclude)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, sub := range s.config.Bans.Subscriptions {
+		go s.followBanList(client, sub)
+	}
+}
+
+func (s *Server) followBanList(client *http.Client, cfg config.BanSubscription) {
+	format, err := bans.ParseFormat(cfg.Format)
+	if err != nil {
+		s.logger.Error("ban subscription disabled", "name", cfg.Name, "error", err)
+		return
+	}
+	sub := bans.Subscription{Name: cfg.Name, Location: cfg.Location, Format: format}
+
+	ticker := time.NewTicker(time.Duration(cfg.Refresh) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(s.ctx, time.Minute)
+		count, err := s.banManager.Refresh(ctx, client, sub)
+		cancel()
+		if err != nil {
+			s.logger.Warn("failed to refresh ban subscription", "name", sub.Name, "location", sub.Location, "error", err)
+		} else {
+			s.logger.Debug("refreshed ban subscription", "name", sub.Name, "bans", count)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/anticheat"
+	"github.com/siohaza/fosilo/internal/physics"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+const (
+	blockHealth = 100
+
+	// damage per hit as the 0.75 client applies it to blocks, on the high
+	// side so a legitimate break is never short
+	spadeBlockDamage   = 55
+	rifleBlockDamage   = 50
+	smgBlockDamage     = 34
+	shotgunBlockDamage = 20 // per pellet
+
+	spadeSwingInterval = 200 * time.Millisecond
+	spadeDigInterval   = time.Second
+
+	// reach from the eye to the centre of the block, with some room for
+	// the player having moved since
+	spadeReach = 6.0
+	gunReach   = 130.0
+
+	// damage nobody follows up on is forgotten after this long
+	blockDamageMemory = 30 * time.Second
+)
+
+type blockKey [3]int
+
+type blockDamage struct {
+	damage  int
+	updated time.Time
+}
+
+// blockTool follows the tool a player holds the trigger on, so swings and
+// automatic fire after the first shot still damage blocks
+type blockTool struct {
+	next    time.Time
+	lastDig time.Time
+	// rounds left in the magazine for the shots after the first, the server
+	// only sees the press so it counts them down itself
+	rounds int
+}
+
+// blockDamageFor returns the damage of one spade hit or one pellet
+func blockDamageFor(tool protocol.ItemType, weapon protocol.WeaponType) int {
+	if tool == protocol.ItemTypeSpade {
+		return spadeBlockDamage
+	}
+	switch weapon {
+	case protocol.WeaponTypeRifle:
+		return rifleBlockDamage
+	case protocol.WeaponTypeSMG:
+		return smgBlockDamage
+	case protocol.WeaponTypeShotgun:
+		return shotgunBlockDamage
+	}
+	return 0
+}
+
+func (s *Server) damageBlock(x, y, z, damage int, now time.Time) {
+	key := blockKey{x, y, z}
+	d, ok := s.blockDamage[key]
+	if !ok || now.Sub(d.updated) > blockDamageMemory {
+		d = &blockDamage{}
+		s.blockDamage[key] = d
+	}
+	d.damage += damage
+	d.updated = now
+}
+
+// damageBlockAlong damages the first block on the ray, if any within reach
+func (s *Server) damageBlockAlong(eye, direction protocol.Vector3f, reach float32, damage int, now time.Time) {
+	hit, _, block, _ := physics.RaycastVXL(s.gameState.Map, eye, direction, reach)
+	if hit {
+		s.damageBlock(int(block.X), int(block.Y), int(block.Z), damage, now)
+	}
+}
+
+// forgetBlocks drops tracked damage of blocks that no longer exist or were
+// left alone for too long, it runs about once a second
+func (s *Server) forgetBlocks(now time.Time) {
+	if now.Before(s.nextBlockPrune) {
+		return
+	}
+	s.nextBlockPrune = now.Add(time.Second)
+
+	for key, d := range s.blockDamage {
+		if now.Sub(d.updated) > blockDamageMemory || !s.gameState.Map.IsSolid(key[0], key[1], key[2]) {
+			delete(s.blockDamage, key)
+		}
+	}
+}
+
+// updateBlockTools runs every tick for living players and applies the
+// swings and follow-up shots the client performs while the trigger is held
+func (s *Server) updateBlockTools(p *player.Player, now time.Time) {
+	if !s.config.AntiCheat.BlockDamage {
+		return
+	}
+
+	p.RLock()
+	held := p.PrimaryFire
+	tool := p.Tool
+	weapon := p.Weapon
+	reloading := p.Reloading
+	magazine := int(p.MagazineAmmo)
+	p.RUnlock()
+
+	t := s.blockTool(p.ID)
+	if !held || (tool != protocol.ItemTypeSpade && tool != protocol.ItemTypeGun) || reloading {
+		t.next = time.Time{}
+		return
+	}
+	if !t.next.IsZero() && now.Before(t.next) {
+		return
+	}
+
+	pos := p.GetPosition()
+	ori := p.GetOrientation()
+
+	if tool == protocol.ItemTypeSpade {
+		s.damageBlockAlong(pos, ori, spadeReach, spadeBlockDamage, now)
+		t.next = now.Add(spadeSwingInterval)
+		return
+	}
+
+	interval := time.Duration(protocol.GetFireDelay(weapon)) * time.Millisecond
+	// the first shot of a press is handled by handleShot and already taken
+	// from the magazine
+	if t.next.IsZero() {
+		t.rounds = magazine
+		t.next = now.Add(interval)
+		return
+	}
+	if t.rounds <= 0 {
+		return
+	}
+	t.rounds--
+
+	// traced like handleShot does, one ray per pellet
+	eye := protocol.Vector3f{X: pos.X, Y: pos.Y, Z: pos.Z - 0.3}
+	for _, direction := range pelletDirections(weapon, ori) {
+		s.damageBlockAlong(eye, direction, gunReach, blockDamageFor(tool, weapon), now)
+	}
+	t.next = now.Add(interval)
+}
+
+func (s *Server) blockTool(id uint8) *blockTool {
+	t, ok := s.blockTools[id]
+	if !ok {
+		t = &blockTool{}
+		s.blockTools[id] = t
+	}
+	return t
+}
+
+// plausibleBlockDestroy checks a client's claim that it destroyed a block
+// when block damage tracking is on. The block has to be within reach of the
+// tool in hand and worn down to within one more spade hit or pellet of
+// breaking, every pellet is traced on its own so a close shotgun gets no
+// more slack than the others. A rejected block is sent back to the client,
+// which already removed it
+func (s *Server) plausibleBlockDestroy(p *player.Player, action protocol.BlockActionType, x, y, z int) bool {
+	if !s.config.AntiCheat.BlockDamage {
+		return true
+	}
+
+	p.RLock()
+	tool := p.Tool
+	weapon := p.Weapon
+	p.RUnlock()
+
+	reach := spadeReach
+	switch {
+	case tool == protocol.ItemTypeGun && action == protocol.BlockActionTypeSpadeGunDestroy:
+		reach = gunReach
+	case tool != protocol.ItemTypeSpade:
+		return s.rejectBlockDestroy(p, action, "destroyed a block without a spade or gun", x, y, z)
+	}
+
+	eye := p.GetPosition()
+	dx := float64(x) + 0.5 - float64(eye.X)
+	dy := float64(y) + 0.5 - float64(eye.Y)
+	dz := float64(z) + 0.5 - float64(eye.Z)
+	distance := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if distance > reach {
+		return s.rejectBlockDestroy(p, action, "destroyed a block out of reach", x, y, z)
+	}
+
+	now := time.Now()
+	if action == protocol.BlockActionTypeSpadeSecondaryDestroy {
+		t := s.blockTool(p.ID)
+		if now.Sub(t.lastDig) < spadeDigInterval-100*time.Millisecond {
+			return s.rejectBlockDestroy(p, action, "dug faster than the spade allows", x, y, z)
+		}
+		t.lastDig = now
+		return true
+	}
+
+	damage := 0
+	if d, ok := s.blockDamage[blockKey{x, y, z}]; ok && now.Sub(d.updated) <= blockDamageMemory {
+		damage = d.damage
+	}
+	// the hit that broke the block may still be on its way to us
+	slack := blockDamageFor(tool, weapon)
+	if tool == protocol.ItemTypeGun && weapon == protocol.WeaponTypeShotgun {
+		slack = s.shotgunSlack(x, y, z, distance, now)
+	}
+	if damage+slack < blockHealth {
+		return s.rejectBlockDestroy(p, action, "destroyed a block that was not damaged enough", x, y, z)
+	}
+	return true
+}
+
+// shotgunSlack is the damage a shotgun destroy may be short of. The client
+// spreads pellets at random while the server traces a fixed pattern, once
+// the spread is wider than a block the traced pellets say little about
+// which of the real ones hit it. A whole shot is allowed then, as long as a
+// traced pellet landed within the spread of the block lately
+func (s *Server) shotgunSlack(x, y, z int, distance float64, now time.Time) int {
+	radius := distance * shotgunSpread
+	if radius < 0.5 {
+		return shotgunBlockDamage
+	}
+
+	r := int(math.Ceil(radius))
+	for dx := -r; dx <= r; dx++ {
+		for dy := -r; dy <= r; dy++ {
+			for dz := -r; dz <= r; dz++ {
+				d, ok := s.blockDamage[blockKey{x + dx, y + dy, z + dz}]
+				if ok && now.Sub(d.updated) <= time.Second {
+					return shotgunBlockDamage * protocol.GetPelletCount(protocol.WeaponTypeShotgun)
+				}
+			}
+		}
+	}
+	return shotgunBlockDamage
+}
+
+func (s *Server) rejectBlockDestroy(p *player.Player, action protocol.BlockActionType, detail string, x, y, z int) bool {
+	s.logger.Warn("rejected block destroy", "player", p.GetName(), "reason", detail,
+		"x", x, "y", y, "z", z)
+	s.recordViolation(p, anticheat.CategoryBlockDamage, detail)
+
+	blocks := [][3]int{{x, y, z}}
+	if action == protocol.BlockActionTypeSpadeSecondaryDestroy {
+		blocks = append(blocks, [3]int{x, y, z - 1}, [3]int{x, y, z + 1})
+	}
+	s.resendBlocks(p, blocks)
+	return false
+}
+
+// resendBlocks puts blocks the client removed on its own back in its map.
+// They are built as p in their map colour, p's own colour is restored after
+func (s *Server) resendBlocks(p *player.Player, blocks [][3]int) {
+	if p.Peer == nil {
+		return
+	}
+
+	for _, b := range blocks {
+		if !s.gameState.Map.IsSolid(b[0], b[1], b[2]) {
+			continue
+		}
+		color := s.gameState.Map.Get(b[0], b[1], b[2])
+		s.sendPacket(p, &protocol.PacketSetColor{
+			PacketID: uint8(protocol.PacketTypeSetColor),
+			PlayerID: p.ID,
+			Color:    protocol.Color3b{B: uint8(color), G: uint8(color >> 8), R: uint8(color >> 16)},
+		}, true)
+		s.sendPacket(p, &protocol.PacketBlockAction{
+			PacketID: uint8(protocol.PacketTypeBlockAction),
+			PlayerID: p.ID,
+			Action:   protocol.BlockActionTypeBuild,
+			X:        int32(b[0]),
+			Y:        int32(b[1]),
+			Z:        int32(b[2]),
+		}, true)
+	}
+
+	p.RLock()
+	own := p.Color
+	p.RUnlock()
+	s.sendPacket(p, &protocol.PacketSetColor{
+		PacketID: uint8(protocol.PacketTypeSetColor),
+		PlayerID: p.ID,
+		Color:    own,
+	}, true)
+}
@@ -0,0 +1,489 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/physics"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+	"github.com/siohaza/fosilo/pkg/config"
+	"github.com/siohaza/fosilo/pkg/nav"
+)
+
+const (
+	botThinkInterval   = 100 * time.Millisecond
+	botBalanceInterval = time.Second
+	botSightRange      = 96.0
+	botStuckDistance   = 0.5
+	botArriveDistance  = 2.0
+
+	// paths are planned toward a point at most botPathRange away so each
+	// search stays small, and redone as the bot walks or the goal moves
+	botPathRange    = 48.0
+	botPathBudget   = 8000
+	botReplanPeriod = 2 * time.Second
+	botReplanMoved  = 4.0
+)
+
+// bot is the controller state for a server-side player with no connection
+type bot struct {
+	player *player.Player
+
+	nextThink  time.Time
+	targetID   uint8
+	hasTarget  bool
+	spottedAt  time.Time
+	strafeLeft bool
+	lastCheck  protocol.Vector3f
+	keys       protocol.KeyState
+
+	path     []nav.Node
+	pathGoal protocol.Vector3f
+	nextPlan time.Time
+}
+
+func (s *Server) botsEnabled() bool {
+	return s.config.Bots.Enabled && s.config.Bots.TargetPlayers > 0
+}
+
+// balanceBots adds or removes bots so humans plus bots match the target count
+func (s *Server) balanceBots(now time.Time) {
+	if now.Before(s.nextBotBalance) {
+		return
+	}
+	s.nextBotBalance = now.Add(botBalanceInterval)
+
+	for id, b := range s.bots {
+		if b.player.GetState() == player.PlayerStateDisconnected {
+			s.removeBot(id)
+		}
+	}
+
+	target := 0
+	if s.botsEnabled() {
+		target = min(s.config.Bots.TargetPlayers, s.config.Server.MaxPlayers)
+	}
+
+	humans := s.gameState.Players.Count() - len(s.bots)
+	wanted := max(target-humans, 0)
+
+	if len(s.bots) < wanted {
+		s.addBot()
+	} else if len(s.bots) > wanted {
+		s.removeAnyBot()
+	}
+}
+
+func (s *Server) addBot() bool {
+	playerID, ok := s.gameState.Players.FindFreeID(s.config.Server.MaxPlayers)
+	if !ok {
+		return false
+	}
+
+	p := player.New(playerID, nil)
+	p.Bot = true
+	p.Name = fmt.Sprintf("%s%d", s.config.Bots.NamePrefix, playerID)
+	p.SetTeam(s.smallerTeam())
+	p.SetWeapon(protocol.WeaponType(rand.Intn(3)))
+	p.Color = protocol.Color3b{B: 112, G: 112, R: 112}
+	p.Tool = protocol.ItemTypeGun
+	p.State = player.PlayerStateReady
+
+	s.gameState.Players.Add(p)
+	s.bots[playerID] = &bot{player: p}
+
+	s.callbacks.OnConnect(playerID)
+	s.finalizePlayerJoin(p)
+
+	s.logger.Info("bot joined", "id", playerID, "name", p.Name, "team", p.GetTeam())
+	return true
+}
+
+func (s *Server) removeAnyBot() bool {
+	for id := range s.bots {
+		s.removeBot(id)
+		return true
+	}
+	return false
+}
+
+func (s *Server) removeBot(id uint8) {
+	b, ok := s.bots[id]
+	if !ok {
+		return
+	}
+	delete(s.bots, id)
+	s.removePlayer(b.player)
+	s.logger.Info("bot left", "id", id, "name", b.player.GetName())
+}
+
+func (s *Server) smallerTeam() uint8 {
+	var counts [2]int
+	s.gameState.Players.ForEach(func(p *player.Player) {
+		if team := p.GetTeam(); team <= 1 {
+			counts[team]++
+		}
+	})
+	if counts[1] < counts[0] {
+		return 1
+	}
+	return 0
+}
+
+// updateBots runs every tick after physics, bots decide what to do at a
+// lower rate and the chosen keys are simulated by physics.MovePlayer
+func (s *Server) updateBots(now time.Time) {
+	s.balanceBots(now)
+
+	for _, b := range s.bots {
+		p := b.player
+
+		p.Lock()
+		p.LastReportedPos = p.Position
+		p.EyePos = p.Position
+		p.Unlock()
+
+		if !p.IsAlive() {
+			b.hasTarget = false
+			continue
+		}
+
+		if now.Before(b.nextThink) {
+			continue
+		}
+		b.nextThink = now.Add(botThinkInterval)
+
+		s.thinkBot(b, now)
+	}
+}
+
+func (s *Server) thinkBot(b *bot, now time.Time) {
+	p := b.player
+	pos := p.GetPosition()
+	eye := protocol.Vector3f{X: pos.X, Y: pos.Y, Z: pos.Z - 0.3}
+
+	enemy := s.findBotTarget(b, eye)
+
+	var keys protocol.KeyState
+	var aim protocol.Vector3f
+
+	if enemy != nil {
+		if !b.hasTarget || b.targetID != enemy.ID {
+			b.spottedAt = now
+		}
+		b.targetID = enemy.ID
+		b.hasTarget = true
+
+		target := enemy.GetPosition()
+		aim = s.botAim(eye, protocol.Vector3f{X: target.X, Y: target.Y, Z: target.Z - 0.5})
+
+		if rand.Intn(10) == 0 {
+			b.strafeLeft = !b.strafeLeft
+		}
+		if b.strafeLeft {
+			keys |= protocol.KeyStateLeft
+		} else {
+			keys |= protocol.KeyStateRight
+		}
+		if s.distance(pos, target) > 20 {
+			keys |= protocol.KeyStateForward
+		}
+	} else {
+		b.hasTarget = false
+
+		goal := s.botObjective(p)
+		dx, dy := goal.X-pos.X, goal.Y-pos.Y
+		if dx*dx+dy*dy > botArriveDistance*botArriveDistance {
+			waypoint := s.botWaypoint(b, pos, goal, now)
+			dx, dy = waypoint.X-pos.X, waypoint.Y-pos.Y
+			length := float32(math.Sqrt(float64(dx*dx + dy*dy)))
+			aim = protocol.Vector3f{X: dx / length, Y: dy / length}
+			keys |= protocol.KeyStateForward
+		} else {
+			aim = p.GetOrientation()
+		}
+	}
+
+	jump := false
+	if keys&protocol.KeyStateForward != 0 {
+		moved := s.distance(pos, b.lastCheck)
+		if moved < botStuckDistance || s.botBlocked(pos, aim) {
+			jump = true
+		}
+	}
+	b.lastCheck = pos
+
+	p.Lock()
+	p.Orientation = aim
+	p.KeyStates = keys
+	p.MoveForward = keys&protocol.KeyStateForward != 0
+	p.MoveBackwards = keys&protocol.KeyStateBackward != 0
+	p.MoveLeft = keys&protocol.KeyStateLeft != 0
+	p.MoveRight = keys&protocol.KeyStateRight != 0
+	if jump {
+		p.Jumping = true
+	}
+	p.Unlock()
+
+	if keys != b.keys || jump {
+		b.keys = keys
+		sentKeys := keys
+		if jump {
+			sentKeys |= protocol.KeyStateJump
+		}
+		packet := protocol.PacketInputData{
+			PacketID:  uint8(protocol.PacketTypeInputData),
+			PlayerID:  p.ID,
+			KeyStates: sentKeys,
+		}
+		s.broadcastPacketExcept(&packet, p.ID, false)
+	}
+
+	reaction := time.Duration(s.config.Bots.ReactionMs) * time.Millisecond
+	if enemy != nil && now.Sub(b.spottedAt) >= reaction {
+		s.botFire(p)
+	}
+}
+
+// botWaypoint returns the next point on the bot's route to goal, falling back
+// to heading straight for it when no route is known
+func (s *Server) botWaypoint(b *bot, pos, goal protocol.Vector3f, now time.Time) protocol.Vector3f {
+	if now.After(b.nextPlan) || s.distance(goal, b.pathGoal) > botReplanMoved {
+		b.nextPlan = now.Add(botReplanPeriod)
+		b.pathGoal = goal
+		b.path = s.planBotPath(pos, goal)
+	}
+
+	// drop the steps already reached
+	for len(b.path) > 0 {
+		next := b.path[0]
+		dx := float32(next.X) + 0.5 - pos.X
+		dy := float32(next.Y) + 0.5 - pos.Y
+		if dx*dx+dy*dy > 0.8*0.8 {
+			break
+		}
+		b.path = b.path[1:]
+	}
+
+	if len(b.path) == 0 {
+		return goal
+	}
+	return protocol.Vector3f{X: float32(b.path[0].X) + 0.5, Y: float32(b.path[0].Y) + 0.5}
+}
+
+func (s *Server) planBotPath(pos, goal protocol.Vector3f) []nav.Node {
+	graph := s.gameState.Nav
+	if graph == nil {
+		return nil
+	}
+
+	// the player position sits about two blocks above the feet while
+	// objectives are already at ground level
+	feetZ := int(pos.Z) + 2
+	goalZ := int(goal.Z)
+
+	dx, dy := goal.X-pos.X, goal.Y-pos.Y
+	if length := float32(math.Sqrt(float64(dx*dx + dy*dy))); length > botPathRange {
+		goal.X = pos.X + dx/length*botPathRange
+		goal.Y = pos.Y + dy/length*botPathRange
+		goalZ = feetZ
+	}
+
+	start, ok := graph.Snap(int(pos.X), int(pos.Y), feetZ)
+	if !ok {
+		return nil
+	}
+	end, ok := graph.Snap(int(goal.X), int(goal.Y), goalZ)
+	if !ok {
+		return nil
+	}
+
+	path, ok := graph.FindPathWithin(start, end, botPathBudget)
+	if !ok {
+		return nil
+	}
+	return path[1:]
+}
+
+// findBotTarget returns the closest living enemy the bot has line of sight to
+func (s *Server) findBotTarget(b *bot, eye protocol.Vector3f) *player.Player {
+	var closest *player.Player
+	closestDistance := float32(botSightRange)
+
+	s.gameState.Players.ForEach(func(other *player.Player) {
+		if !s.isValidTarget(b.player, other) {
+			return
+		}
+		otherPos := other.GetPosition()
+		otherEye := protocol.Vector3f{X: otherPos.X, Y: otherPos.Y, Z: otherPos.Z - 0.3}
+		distance := s.distance(eye, otherEye)
+		if distance >= closestDistance {
+			return
+		}
+		if !physics.CanSee(s.gameState.Map, eye, otherEye) {
+			return
+		}
+		closest = other
+		closestDistance = distance
+	})
+
+	return closest
+}
+
+// botAim points from eye at target with an error that shrinks as accuracy grows
+func (s *Server) botAim(eye, target protocol.Vector3f) protocol.Vector3f {
+	spread := float32(1-s.config.Bots.Accuracy) * 0.15
+	dir := protocol.Vector3f{
+		X: target.X - eye.X + (rand.Float32()*2-1)*spread*4,
+		Y: target.Y - eye.Y + (rand.Float32()*2-1)*spread*4,
+		Z: target.Z - eye.Z + (rand.Float32()*2-1)*spread*4,
+	}
+	length := s.calculateDistance(dir)
+	if length == 0 {
+		return protocol.Vector3f{X: 1}
+	}
+	return protocol.Vector3f{X: dir.X / length, Y: dir.Y / length, Z: dir.Z / length}
+}
+
+func (s *Server) botFire(p *player.Player) {
+	if !p.CanShoot() {
+		p.RLock()
+		empty := p.MagazineAmmo == 0 && !p.Reloading
+		p.RUnlock()
+		if empty && p.StartReload() {
+			p.RLock()
+			packet := protocol.PacketWeaponReload{
+				PacketID:     uint8(protocol.PacketTypeWeaponReload),
+				PlayerID:     p.ID,
+				MagazineAmmo: p.MagazineAmmo,
+				ReserveAmmo:  p.ReserveAmmo,
+			}
+			p.RUnlock()
+			s.broadcastPacketExcept(&packet, p.ID, true)
+		}
+		return
+	}
+
+	packet := protocol.PacketWeaponInput{
+		PacketID:    uint8(protocol.PacketTypeWeaponInput),
+		PlayerID:    p.ID,
+		WeaponInput: protocol.WeaponInputPrimary,
+	}
+	s.broadcastPacketExcept(&packet, p.ID, false)
+
+	s.handleShot(p)
+
+	packet.WeaponInput = 0
+	s.broadcastPacketExcept(&packet, p.ID, false)
+}
+
+// botObjective picks where a bot should head when no enemy is in sight
+func (s *Server) botObjective(p *player.Player) protocol.Vector3f {
+	gm, _ := config.ParseGamemode(s.config.Server.Gamemode)
+	team := p.GetTeam()
+	center := protocol.Vector3f{
+		X: float32(s.gameState.Map.Width()) / 2,
+		Y: float32(s.gameState.Map.Height()) / 2,
+	}
+
+	if gm == config.GamemodeTC {
+		if territory, ok := s.closestTerritory(p); ok {
+			return territory
+		}
+		return center
+	}
+	if !s.intelEnabled() {
+		if enemy := s.closestEnemy(p); enemy != nil {
+			return enemy.GetPosition()
+		}
+		return center
+	}
+
+	p.RLock()
+	hasIntel := p.HasIntel
+	p.RUnlock()
+	if hasIntel {
+		return s.gameState.GetBase(team)
+	}
+
+	slot := uint8(1 - team)
+	if gm.SingleIntel() {
+		slot = 0
+	}
+	intelPos, held := s.gameState.GetIntelState(slot)
+	if !held {
+		return intelPos
+	}
+
+	// escort or chase whoever is carrying it
+	if carrier, ok := s.gameState.Players.Get(s.gameState.Intel[slot].CarrierID); ok && carrier.ID != p.ID {
+		return carrier.GetPosition()
+	}
+	return center
+}
+
+// closestTerritory finds the nearest territory the bot's team does not
+// hold, once they hold them all the bot stays on the nearest one
+func (s *Server) closestTerritory(p *player.Player) (protocol.Vector3f, bool) {
+	pos := p.GetPosition()
+	team := p.GetTeam()
+	var closest, closestHeld protocol.Vector3f
+	closestDistance, closestHeldDistance := float32(math.MaxFloat32), float32(math.MaxFloat32)
+
+	for _, territory := range s.gameState.GetTerritories() {
+		distance := s.distance(pos, territory.Position)
+		if territory.Team == team {
+			if distance < closestHeldDistance {
+				closestHeld, closestHeldDistance = territory.Position, distance
+			}
+		} else if distance < closestDistance {
+			closest, closestDistance = territory.Position, distance
+		}
+	}
+
+	if closestDistance < math.MaxFloat32 {
+		return closest, true
+	}
+	return closestHeld, closestHeldDistance < math.MaxFloat32
+}
+
+func (s *Server) closestEnemy(p *player.Player) *player.Player {
+	pos := p.GetPosition()
+	var closest *player.Player
+	closestDistance := float32(math.MaxFloat32)
+
+	s.gameState.Players.ForEach(func(other *player.Player) {
+		if !s.isValidTarget(p, other) {
+			return
+		}
+		if distance := s.distance(pos, other.GetPosition()); distance < closestDistance {
+			closest = other
+			closestDistance = distance
+		}
+	})
+
+	return closest
+}
+
+// botBlocked reports a wall one block ahead at foot height
+func (s *Server) botBlocked(pos, dir protocol.Vector3f) bool {
+	x := int(pos.X + dir.X*1.2)
+	y := int(pos.Y + dir.Y*1.2)
+	z := int(pos.Z + 1)
+	return s.gameState.Map.IsSolid(x, y, z) || s.gameState.Map.IsSolid(x, y, z+1)
+}
+
+func (s *Server) distance(a, b protocol.Vector3f) float32 {
+	return s.calculateDistance(protocol.Vector3f{X: a.X - b.X, Y: a.Y - b.Y, Z: a.Z - b.Z})
+}
+
+// dropBot marks a bot for removal on the next balance pass so callers that
+// are iterating over players never see the slot disappear underneath them
+func (s *Server) dropBot(p *player.Player) {
+	p.Lock()
+	p.State = player.PlayerStateDisconnected
+	p.Unlock()
+}
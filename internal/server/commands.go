@@ -1,14 +1,17 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/siohaza/fosilo/internal/audit"
 	"github.com/siohaza/fosilo/internal/player"
 	"github.com/siohaza/fosilo/internal/protocol"
+	"github.com/siohaza/fosilo/pkg/lua"
 )
 
 func (s *Server) sendChatToPlayer(p *player.Player, message string) {
@@ -28,6 +31,23 @@ func (s *Server) sendChatToPlayer(p *player.Player, message string) {
 	s.sendPacket(p, &packet, true)
 }
 
+// notifyStaff tells every moderator, admin and manager in game about
+// something that needs a human to look at it
+func (s *Server) notifyStaff(message string) {
+	staff := uint64(1)<<lua.PermissionModerator | uint64(1)<<lua.PermissionAdmin | uint64(1)<<lua.PermissionManager
+	s.gameState.Players.ForEach(func(p *player.Player) {
+		if p.GetState() != player.PlayerStateReady {
+			return
+		}
+		p.RLock()
+		perms := p.Permissions
+		p.RUnlock()
+		if perms&staff != 0 {
+			s.sendChatToPlayer(p, message)
+		}
+	})
+}
+
 func (s *Server) broadcastChat(message string, chatType protocol.ChatType) {
 	chatMsg, err := protocol.StringToCP437(message)
 	if err != nil {
@@ -67,10 +87,15 @@ func (s *Server) KickPlayer(playerID uint8, reason string) {
 	}
 
 	s.broadcastChat(fmt.Sprintf("%s was kicked: %s", p.GetName(), reason), protocol.ChatTypeSystem)
+	s.audit("kick", p.GetName(), reason)
 
-	time.AfterFunc(100*time.Millisecond, func() {
-		s.network.DisconnectPeerWithReason(p.Peer, false, uint32(protocol.DisconnectReasonKicked))
-	})
+	if p.Bot {
+		s.dropBot(p)
+	} else {
+		time.AfterFunc(100*time.Millisecond, func() {
+			s.network.DisconnectPeerWithReason(p.Peer, false, uint32(protocol.DisconnectReasonKicked))
+		})
+	}
 
 	s.logger.Info("player kicked", "target", p.GetName(), "reason", reason)
 }
@@ -104,6 +129,10 @@ func (s *Server) BroadcastShortPlayerData(p *player.Player) {
 }
 
 func (s *Server) DisconnectPlayerWithReason(p *player.Player, reason uint32) {
+	if p != nil && p.Bot {
+		s.dropBot(p)
+		return
+	}
 	if p == nil || p.Peer == nil {
 		return
 	}
@@ -171,7 +200,21 @@ func (s *Server) handleCommand(p *player.Player, message string) bool {
 		return true
 	}
 
-	result, err := s.luaCommands.Execute(p, cmdName, args)
+	var result string
+	var err error
+	s.runAs(p, func() {
+		result, err = s.luaCommands.Execute(p, cmdName, args)
+
+		// commands everyone may use are left out, the trail is for staff
+		if cmd := s.luaCommands.Get(cmdName); cmd != nil && cmd.Permission > lua.PermissionNone {
+			action := "command"
+			if errors.Is(err, lua.ErrPermissionDenied) {
+				action = "command_denied"
+			}
+			_ = s.recordAudit(audit.Entry{Action: action, Args: append([]string{cmd.Name}, args...)})
+		}
+	})
+
 	if err != nil {
 		if strings.Contains(err.Error(), "unknown command") {
 			s.sendChatToPlayer(p, "Unknown command. Type /help for available commands.")
@@ -0,0 +1,395 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/demo"
+	"github.com/siohaza/fosilo/internal/network"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// StartDemo begins recording the current match into the demo directory and
+// returns the path of the new file
+func (s *Server) StartDemo() (string, error) {
+	s.demoMu.Lock()
+	defer s.demoMu.Unlock()
+
+	if s.demo != nil {
+		return s.demo.Path(), fmt.Errorf("already recording to %s", s.demo.Path())
+	}
+	return s.startDemoLocked()
+}
+
+// StopDemo finishes the current recording and returns the path it was saved to
+func (s *Server) StopDemo() (string, error) {
+	s.demoMu.Lock()
+	defer s.demoMu.Unlock()
+
+	if s.demo == nil {
+		return "", fmt.Errorf("not recording")
+	}
+	return s.closeDemoLocked()
+}
+
+func (s *Server) IsRecordingDemo() bool {
+	s.demoMu.Lock()
+	defer s.demoMu.Unlock()
+	return s.demo != nil
+}
+
+// rotateDemo closes the recording of the previous map and starts a new file,
+// recording carries on across maps once started and always starts when the
+// config asks for it
+func (s *Server) rotateDemo() {
+	s.demoMu.Lock()
+	defer s.demoMu.Unlock()
+
+	recording := s.demo != nil
+	if recording {
+		if _, err := s.closeDemoLocked(); err != nil {
+			s.logger.Error("failed to close demo", "error", err)
+		}
+	}
+
+	if recording || s.config.Demo.Enabled {
+		if _, err := s.startDemoLocked(); err != nil {
+			s.logger.Error("failed to start demo recording", "error", err)
+		}
+	}
+}
+
+func (s *Server) startDemoLocked() (string, error) {
+	dir := s.config.Demo.Directory
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create demo directory: %w", err)
+	}
+
+	mapName := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' || r == ':' {
+			return '_'
+		}
+		return r
+	}, s.GetCurrentMapName())
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.demo", time.Now().Format("20060102-150405"), mapName))
+
+	rec, err := demo.Create(path, protocol.ProtocolVersion75)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.writeSnapshot(rec.WritePacket); err != nil {
+		rec.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	s.demo = rec
+	s.logger.Info("demo recording started", "path", path)
+	return path, nil
+}
+
+func (s *Server) closeDemoLocked() (string, error) {
+	path := s.demo.Path()
+	packets := s.demo.Packets()
+	err := s.demo.Close()
+	s.demo = nil
+	if s.relay == nil {
+		s.resetViewers()
+	}
+	if err != nil {
+		return path, fmt.Errorf("failed to close demo: %w", err)
+	}
+
+	s.logger.Info("demo recording saved", "path", path, "packets", packets)
+	return path, nil
+}
+
+// writeSnapshot hands out what a client joining right now would receive,
+// the map, game state, intel and players already in game, so demos and the
+// relay can start from an empty client
+func (s *Server) writeSnapshot(out func(data []byte) error) error {
+	write := func(packet interface{}) error {
+		data, err := marshalPacket(packet)
+		if err != nil {
+			return err
+		}
+		for _, data := range s.forViewers(data, false) {
+			if err := out(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	mapData, _, err := s.compressedMap()
+	if err != nil {
+		return err
+	}
+
+	if err := write(&protocol.PacketMapStart{
+		PacketID: uint8(protocol.PacketTypeMapStart),
+		MapSize:  uint32(len(mapData)),
+	}); err != nil {
+		return err
+	}
+	for i := 0; i < len(mapData); i += mapChunkSize {
+		end := min(i+mapChunkSize, len(mapData))
+		if err := write(&protocol.PacketMapChunk{
+			PacketID: uint8(protocol.PacketTypeMapChunk),
+			Data:     mapData[i:end],
+		}); err != nil {
+			return err
+		}
+	}
+
+	stateData := s.gameState.GetStateData(viewerID)
+	if err := write(&stateData); err != nil {
+		return err
+	}
+
+	if s.intelEnabled() {
+		for team := uint8(0); team < 2; team++ {
+			position, _ := s.gameState.GetIntelState(team)
+			if err := write(&protocol.PacketMoveObject{
+				PacketID: uint8(protocol.PacketTypeMoveObject),
+				ObjectID: team,
+				Team:     team,
+				X:        position.X,
+				Y:        position.Y,
+				Z:        position.Z,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	var playerErr error
+	s.gameState.Players.ForEach(func(p *player.Player) {
+		if playerErr != nil || p.GetState() != player.PlayerStateReady {
+			return
+		}
+		packet := existingPlayerPacket(p)
+		playerErr = write(&packet)
+	})
+	return playerErr
+}
+
+// viewerID is the player id demos and relay viewers watch from, the last one
+// vanilla clients know. Servers below 32 slots never hand it out, on larger
+// ones a player holding it is shown to viewers under a stand-in id instead
+const viewerID = protocol.MaxPlayers - 1
+
+// viewerStream tracks the stand-in for the player on viewerID. left is that
+// player once their leave went out, until they are gone from the game
+type viewerStream struct {
+	mu      sync.Mutex
+	standIn uint8
+	shown   bool
+	left    *player.Player
+}
+
+// forViewers rewrites a packet for demos and the relay so the player on
+// viewerID appears under the stand-in, returning what to pass on. Packets
+// about them are dropped while there is no free id to show them under. Only
+// the live stream picks and moves the stand-in, announcing it ahead of data,
+// so snapshots never show viewers something the live stream did not
+func (s *Server) forViewers(data []byte, live bool) [][]byte {
+	s.viewer.mu.Lock()
+	defer s.viewer.mu.Unlock()
+
+	var out [][]byte
+	if live {
+		out = s.updateStandIn()
+	}
+
+	if len(data) == 0 {
+		return out
+	}
+	if protocol.PacketType(data[0]) == protocol.PacketTypeWorldUpdate {
+		return append(out, s.viewerWorldUpdate(data))
+	}
+
+	offsets := playerIDOffsets(data)
+	names := false
+	for _, i := range offsets {
+		names = names || data[i] == viewerID
+	}
+	if !names {
+		return append(out, data)
+	}
+	if !s.viewer.shown {
+		return out
+	}
+
+	data = append([]byte(nil), data...)
+	for _, i := range offsets {
+		if data[i] == viewerID {
+			data[i] = s.viewer.standIn
+		}
+	}
+	if protocol.PacketType(data[0]) == protocol.PacketTypePlayerLeft {
+		s.viewer.shown = false
+		s.viewer.left, _ = s.gameState.Players.Get(viewerID)
+	}
+	return append(out, data)
+}
+
+// updateStandIn takes the player on viewerID off a stand-in a real player
+// now holds, and shows them under a free id whenever they are not shown
+func (s *Server) updateStandIn() [][]byte {
+	var out [][]byte
+	if s.viewer.shown {
+		if _, taken := s.gameState.Players.Get(s.viewer.standIn); !taken {
+			return nil
+		}
+		if data, err := marshalPacket(&protocol.PacketPlayerLeft{
+			PacketID: uint8(protocol.PacketTypePlayerLeft),
+			PlayerID: s.viewer.standIn,
+		}); err == nil {
+			out = append(out, data)
+		}
+		s.viewer.shown = false
+	}
+
+	p, ok := s.gameState.Players.Get(viewerID)
+	if !ok || p == s.viewer.left || p.GetState() != player.PlayerStateReady {
+		return out
+	}
+
+	// the highest free ids are the last ones the game hands out
+	for id := int(viewerID) - 1; id >= 0; id-- {
+		if _, taken := s.gameState.Players.Get(uint8(id)); taken {
+			continue
+		}
+		packet := existingPlayerPacket(p)
+		packet.PlayerID = uint8(id)
+		if data, err := marshalPacket(&packet); err == nil {
+			s.viewer.standIn = uint8(id)
+			s.viewer.shown = true
+			out = append(out, data)
+		}
+		break
+	}
+	return out
+}
+
+// viewerWorldUpdate moves the viewerID entry of a world update to the
+// stand-in, viewers never see an entry for their own id
+func (s *Server) viewerWorldUpdate(data []byte) []byte {
+	const entrySize = 24
+	at := func(id uint8) int { return 1 + int(id)*entrySize }
+	if len(data) < at(viewerID)+entrySize {
+		return data
+	}
+
+	data = append([]byte(nil), data...)
+	entry := data[at(viewerID) : at(viewerID)+entrySize]
+	if s.viewer.shown {
+		copy(data[at(s.viewer.standIn):], entry)
+	}
+	clear(entry)
+	return data
+}
+
+// resetViewers forgets the stand-in once nothing records or relays the match,
+// the next snapshot picks one again
+func (s *Server) resetViewers() {
+	s.viewer.mu.Lock()
+	defer s.viewer.mu.Unlock()
+	s.viewer.shown = false
+	s.viewer.left = nil
+}
+
+// recordPacket passes a packet everyone in game sees on to the demo and the
+// spectator relay
+func (s *Server) recordPacket(data []byte) {
+	// demos and the relay are watched with vanilla clients
+	if refersToHighSlot(data) {
+		return
+	}
+
+	s.demoMu.Lock()
+	defer s.demoMu.Unlock()
+
+	if s.relay == nil && s.demo == nil {
+		return
+	}
+	for _, data := range s.forViewers(data, true) {
+		if s.relay != nil {
+			s.relay.Feed(data)
+		}
+		if s.demo == nil {
+			continue
+		}
+		if err := s.demo.WritePacket(data); err != nil {
+			s.logger.Error("failed to record demo packet, stopping recording", "error", err)
+			if _, err := s.closeDemoLocked(); err != nil {
+				s.logger.Error("failed to close demo", "error", err)
+			}
+		}
+	}
+}
+
+// StartRelay starts the delayed spectator relay on net and seeds it with the
+// current map
+func (s *Server) StartRelay(net network.Transport) error {
+	relay, err := NewRelayWithTransport(s.config, net, s.logger)
+	if err != nil {
+		return err
+	}
+	if err := relay.Start(); err != nil {
+		return err
+	}
+
+	s.relay = relay
+	s.feedRelaySnapshot()
+	s.logger.Info("spectator relay listening", "port", s.config.Relay.Port, "delay", s.config.Relay.Delay)
+	return nil
+}
+
+func (s *Server) feedRelaySnapshot() {
+	if s.relay == nil {
+		return
+	}
+	err := s.writeSnapshot(func(data []byte) error {
+		s.relay.Feed(data)
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("failed to feed map to spectator relay", "error", err)
+	}
+}
+
+// isPrivatePacket reports packets that only make sense to the connection they
+// were sent to, like the join handshake or the receiving player's own HP, so
+// they are kept out of demos
+func isPrivatePacket(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+
+	switch protocol.PacketType(data[0]) {
+	case protocol.PacketTypeMapStart,
+		protocol.PacketTypeMapChunk,
+		protocol.PacketTypeStateData,
+		protocol.PacketTypeHandShakeInit,
+		protocol.PacketTypeVersionRequest,
+		protocol.PacketTypeExtensionInfo,
+		protocol.PacketTypeExistingPlayer,
+		protocol.PacketTypeSetHP,
+		protocol.PacketTypeWeaponReload,
+		protocol.PacketTypePlayerProperties,
+		protocol.PacketTypeCreatePlayer,
+		protocol.PacketTypeChatMessage,
+		protocol.PacketTypeRestock,
+		protocol.PacketTypeWorldUpdate:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/physics"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// hiddenPosition is where culled enemies are reported, below the bottom of
+// the map where nothing can be drawn or aimed at
+var hiddenPosition = protocol.PlayerPositionData{X: 0, Y: 0, Z: 128, OX: 1}
+
+type espPair struct {
+	viewer uint8
+	target uint8
+}
+
+type espVisibility struct {
+	visibleUntil time.Time
+	checked      time.Time
+	// since is the last check that still had the target out of sight before
+	// it came into view, zero when there was none. reacted is set once the
+	// viewer hit it after that
+	since   time.Time
+	reacted bool
+}
+
+func (s *Server) espCulling() bool {
+	return s.config.AntiCheat.ESPCulling && s.gameState.Map != nil
+}
+
+// trackVisibility reports whether line of sight is needed at all, aim
+// detection uses it for reaction times even when nothing is culled
+func (s *Server) trackVisibility() bool {
+	return (s.config.AntiCheat.ESPCulling || s.config.AntiCheat.AimDetection) && s.gameState.Map != nil
+}
+
+// updateVisibility refreshes which enemies each player may see. Pairs still
+// well inside their grace period are left alone, the rest are raycast stalest
+// first until the per tick budget runs out, so with a full server a pair can
+// lag behind by a few updates rather than stalling the tick
+func (s *Server) updateVisibility(players []*player.Player, now time.Time) {
+	grace := time.Duration(s.config.AntiCheat.ESPGraceMs) * time.Millisecond
+	viewDistance := float32(s.config.AntiCheat.ESPViewDistance)
+
+	type candidate struct {
+		pair         espPair
+		vis          *espVisibility
+		from, target protocol.Vector3f
+	}
+	var candidates []candidate
+
+	for _, viewer := range players {
+		viewerTeam := viewer.GetTeam()
+		from := viewer.GetPosition()
+		for _, target := range players {
+			if target.GetTeam() == viewerTeam {
+				continue
+			}
+
+			pair := espPair{viewer: viewer.ID, target: target.ID}
+			vis, ok := s.visibility[pair]
+			if !ok {
+				vis = &espVisibility{}
+				s.visibility[pair] = vis
+			}
+			if vis.visibleUntil.Sub(now) > grace/2 {
+				continue
+			}
+
+			to := target.GetPosition()
+			dx, dy := to.X-from.X, to.Y-from.Y
+			if float32(math.Sqrt(float64(dx*dx+dy*dy))) > viewDistance {
+				vis.checked = now
+				continue
+			}
+			candidates = append(candidates, candidate{pair: pair, vis: vis, from: from, target: to})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].vis.checked.Before(candidates[j].vis.checked)
+	})
+
+	budget := s.config.AntiCheat.ESPChecksPerTick
+	for _, c := range candidates {
+		if budget <= 0 {
+			break
+		}
+
+		// the head first, then the feet two blocks further down
+		budget--
+		visible := physics.CanSee(s.gameState.Map, c.from, c.target)
+		if !visible && budget > 0 {
+			budget--
+			feet := c.target
+			feet.Z += 2
+			visible = physics.CanSee(s.gameState.Map, c.from, feet)
+		}
+
+		previous := c.vis.checked
+		c.vis.checked = now
+		if visible {
+			if !now.Before(c.vis.visibleUntil) {
+				c.vis.since = previous
+				c.vis.reacted = false
+			}
+			c.vis.visibleUntil = now.Add(grace)
+		}
+	}
+}
+
+// canSee reports whether target may be sent to viewer as it is
+func (s *Server) canSee(viewer, target *player.Player, now time.Time) bool {
+	if viewer.ID == target.ID {
+		return true
+	}
+	viewerTeam := viewer.GetTeam()
+	if viewerTeam > 1 || target.GetTeam() == viewerTeam {
+		return true
+	}
+
+	vis, ok := s.visibility[espPair{viewer: viewer.ID, target: target.ID}]
+	return ok && now.Before(vis.visibleUntil)
+}
+
+// reactionTime returns how long target had been in sight of viewer the first
+// time viewer hits it, or -1 for any later hit or when it is not known.
+// Checks are spread over several updates and target came into sight some
+// time after the last one that missed it, so this can come out too slow but
+// never too fast
+func (s *Server) reactionTime(viewer, target *player.Player, now time.Time) time.Duration {
+	vis, ok := s.visibility[espPair{viewer: viewer.ID, target: target.ID}]
+	if !ok || vis.reacted || vis.since.IsZero() || !now.Before(vis.visibleUntil) {
+		return -1
+	}
+	vis.reacted = true
+	return now.Sub(vis.since)
+}
+
+func (s *Server) forgetVisibility(id uint8) {
+	for pair := range s.visibility {
+		if pair.viewer == id || pair.target == id {
+			delete(s.visibility, pair)
+		}
+	}
+}
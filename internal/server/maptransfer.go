@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/network"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+	"github.com/siohaza/fosilo/pkg/vxl"
+)
+
+const (
+	mapChunkSize = 8192
+
+	// mapTransferWindow caps the unacknowledged data queued for one peer, the
+	// same window ENet keeps for reliable packets when no bandwidth limit is set
+	mapTransferWindow = 64 * 1024
+
+	// mapFallbackInterval paces transfers one chunk at a time when the
+	// transport can't report what the peer has left to acknowledge
+	mapFallbackInterval = 50 * time.Millisecond
+
+	mapTransferTimeout = 60 * time.Second
+)
+
+// mapCache keeps the compressed map around so a wave of joins compresses it
+// once, it is only reused while the map and its generation still match
+type mapCache struct {
+	m          *vxl.Map
+	generation uint64
+	data       []byte
+	crc        uint32
+}
+
+// compressedMap serializes the current map and zlib compresses it the way
+// clients expect it in MapChunk packets, along with the CRC32 of the
+// uncompressed map that 0.76 clients look their cache up by. The returned
+// data is shared between callers and must not be modified
+func (s *Server) compressedMap() ([]byte, uint32, error) {
+	m := s.gameState.Map
+	if c := s.mapCache; c.data != nil && c.m == m && c.generation == m.Generation() {
+		return c.data, c.crc, nil
+	}
+
+	mapData, err := m.Write()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to write map: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(mapData); err != nil {
+		return nil, 0, fmt.Errorf("failed to compress map data: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, fmt.Errorf("failed to finalize map compression: %w", err)
+	}
+
+	s.mapCache = mapCache{
+		m:          m,
+		generation: m.Generation(),
+		data:       compressed.Bytes(),
+		crc:        crc32.ChecksumIEEE(mapData),
+	}
+	return s.mapCache.data, s.mapCache.crc, nil
+}
+
+// mapOffer is a 0.76 map start waiting on the client to tell us whether it
+// already has the map cached
+type mapOffer struct {
+	data []byte
+	then func()
+}
+
+// mapTransfer is a map being streamed to one player from the run loop
+type mapTransfer struct {
+	player   *player.Player
+	data     []byte
+	offset   int
+	then     func()
+	deadline time.Time
+	// next is when an unpaced transfer may send its next chunk
+	next time.Time
+}
+
+// mapSender streams compressed maps to connecting players. The game server
+// and the replay server each own one and drive it from their run loop
+type mapSender struct {
+	network   network.Transport
+	logger    *slog.Logger
+	offers    map[uint8]mapOffer
+	transfers map[uint8]*mapTransfer
+	unpaced   bool
+}
+
+func newMapSender(net network.Transport, logger *slog.Logger) *mapSender {
+	return &mapSender{
+		network:   net,
+		logger:    logger,
+		offers:    make(map[uint8]mapOffer),
+		transfers: make(map[uint8]*mapTransfer),
+	}
+}
+
+// sendMapData starts transferring the current map and calls then once the
+// client has all of it, for 0.76 only after it answered with MapCached
+func (s *Server) sendMapData(p *player.Player, then func()) error {
+	data, crc, err := s.compressedMap()
+	if err != nil {
+		return err
+	}
+	s.maps.send(p, data, crc, s.GetCurrentMapName(), then)
+	return nil
+}
+
+// send starts transferring data, a compressed map, and calls then once the
+// client has all of it. 0.76 clients are offered the map by name and CRC
+// first and only get it if they answer that they don't have it cached
+func (m *mapSender) send(p *player.Player, data []byte, crc uint32, name string, then func()) {
+	if p.ProtocolVersion == protocol.ProtocolVersion76 {
+		m.logger.Info("offering map", "player", p.ID, "size", len(data), "crc", crc)
+		m.offers[p.ID] = mapOffer{data: data, then: then}
+		m.sendPacket(p, &protocol.PacketMapStart76{
+			PacketID: uint8(protocol.PacketTypeMapStart),
+			MapSize:  uint32(len(data)),
+			CRC32:    crc,
+			MapName:  name,
+		})
+		return
+	}
+
+	m.logger.Info("sending map start", "player", p.ID, "size", len(data))
+	m.sendPacket(p, &protocol.PacketMapStart{
+		PacketID: uint8(protocol.PacketTypeMapStart),
+		MapSize:  uint32(len(data)),
+	})
+
+	m.start(p, data, then)
+}
+
+func (m *mapSender) handleMapCached(p *player.Player, data []byte) {
+	offer, ok := m.offers[p.ID]
+	if !ok || len(data) < 2 {
+		return
+	}
+	delete(m.offers, p.ID)
+
+	if data[1] != 0 {
+		m.logger.Info("client has the map cached", "player", p.ID)
+		offer.then()
+		return
+	}
+	m.start(p, offer.data, offer.then)
+}
+
+// forget drops whatever is being sent to the player with the given id
+func (m *mapSender) forget(id uint8) {
+	delete(m.offers, id)
+	delete(m.transfers, id)
+}
+
+func (m *mapSender) start(p *player.Player, data []byte, then func()) {
+	now := time.Now()
+	t := &mapTransfer{
+		player:   p,
+		data:     data,
+		then:     then,
+		deadline: now.Add(mapTransferTimeout),
+	}
+	m.transfers[p.ID] = t
+	m.logger.Debug("sending map chunks", "player", p.ID,
+		"num_chunks", (len(data)+mapChunkSize-1)/mapChunkSize)
+
+	m.pumpTransfer(t, now)
+}
+
+// pump tops up every transfer in progress, it runs every tick
+func (m *mapSender) pump(now time.Time) {
+	for _, t := range m.transfers {
+		m.pumpTransfer(t, now)
+	}
+}
+
+// pumpTransfer sends as many chunks as the peer has room for. Room is what
+// is left of the window after the reliable data the peer still has to
+// acknowledge, so a peer that drains slowly is sent less
+func (m *mapSender) pumpTransfer(t *mapTransfer, now time.Time) {
+	p := t.player
+
+	if now.After(t.deadline) {
+		delete(m.transfers, p.ID)
+		m.logger.Error("map send timeout", "player", p.ID, "sent", t.offset, "size", len(t.data))
+		m.network.DisconnectPeerWithReason(p.Peer, false, uint32(protocol.DisconnectReasonUndefined))
+		return
+	}
+
+	inTransit, ok := p.Peer.PendingReliable()
+	pending := int(inTransit)
+	if !ok {
+		if !m.unpaced {
+			m.unpaced = true
+			m.logger.Warn("transport can't report unacknowledged data, pacing map transfers at a fixed rate",
+				"chunk", mapChunkSize, "interval", mapFallbackInterval)
+		}
+		if now.Before(t.next) {
+			return
+		}
+		t.next = now.Add(mapFallbackInterval)
+		// one chunk fills the window for this pass
+		pending = mapTransferWindow - mapChunkSize
+	}
+	for t.offset < len(t.data) && pending < mapTransferWindow {
+		end := min(t.offset+mapChunkSize, len(t.data))
+		m.sendPacket(p, &protocol.PacketMapChunk{
+			PacketID: uint8(protocol.PacketTypeMapChunk),
+			Data:     t.data[t.offset:end],
+		})
+
+		// ENet only counts data once it leaves the send queue, so what is
+		// queued in this pass is added here
+		pending += end - t.offset
+		t.offset = end
+	}
+
+	if t.offset < len(t.data) {
+		return
+	}
+
+	delete(m.transfers, p.ID)
+	m.logger.Debug("finished sending map chunks", "player", p.ID)
+	t.then()
+}
+
+func (m *mapSender) sendPacket(p *player.Player, packet interface{}) {
+	data, err := marshalPacket(packet)
+	if err != nil {
+		m.logger.Error("failed to encode packet", "error", err)
+		return
+	}
+	if err := m.network.SendPacket(p.Peer, data, true); err != nil {
+		m.logger.Error("failed to send packet", "error", err)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/anticheat"
+	"github.com/siohaza/fosilo/internal/physics"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+const (
+	// a little over sprinting speed, in blocks per second
+	maxMoveSpeed = 12.0
+
+	// latency past this is not allowed for, the player is simply corrected
+	maxMoveLatency = time.Second
+
+	// strikes older than this no longer count towards a correction
+	moveStrikeWindow = 5 * time.Second
+)
+
+type movementState struct {
+	strikes    int
+	lastStrike time.Time
+	// anchor is where the simulation had the player when the strikes began,
+	// reports are measured from it until they add up or the player is back
+	// within reach of it
+	anchor     protocol.Vector3f
+	anchorAt   time.Time
+	graceUntil time.Time
+
+	lastRTT uint32
+	jitter  float64 // milliseconds
+}
+
+// validateMovement compares a reported position with where the server's own
+// simulation has the player. A report that diverges by more than latency and
+// jitter could explain, or puts the body inside solid blocks, is a strike but
+// is still accepted since a lag spike looks the same. Once MovementStrikes
+// reports in a row diverge the player is pulled back and the report dropped
+func (s *Server) validateMovement(p *player.Player, reported protocol.Vector3f) bool {
+	cfg := s.config.AntiCheat
+	if !cfg.MovementValidation || p.Bot || p.Peer == nil || s.gameState.Map == nil {
+		return true
+	}
+
+	st, ok := s.movement[p.ID]
+	if !ok {
+		st = &movementState{}
+		s.movement[p.ID] = st
+	}
+
+	now := time.Now()
+	// positions sent before our last correction arrived are meaningless
+	if now.Before(st.graceUntil) {
+		return false
+	}
+
+	rtt := p.Peer.RoundTripTime()
+	if st.lastRTT != 0 {
+		diff := math.Abs(float64(rtt) - float64(st.lastRTT))
+		st.jitter += (diff - st.jitter) / 8
+	}
+	st.lastRTT = rtt
+
+	latency := time.Duration(float64(rtt)/2+2*st.jitter) * time.Millisecond
+	if latency > maxMoveLatency {
+		latency = maxMoveLatency
+	}
+	tolerance := cfg.MovementTolerance + maxMoveSpeed*latency.Seconds()
+
+	if now.Sub(st.lastStrike) > moveStrikeWindow {
+		st.strikes = 0
+	}
+
+	// accepted reports move the simulation along, so while there are strikes
+	// the reference stays where they began and the allowance grows with time
+	predicted := p.GetPosition()
+	reference := predicted
+	if st.strikes > 0 {
+		reference = st.anchor
+		tolerance += maxMoveSpeed * now.Sub(st.anchorAt).Seconds()
+	}
+
+	dx, dy, dz := reported.X-reference.X, reported.Y-reference.Y, reported.Z-reference.Z
+	divergence := math.Sqrt(float64(dx*dx + dy*dy + dz*dz))
+
+	p.RLock()
+	crouching := p.Crouching
+	p.RUnlock()
+	inSolid := physics.BodyInSolid(s.gameState.Map, reported, crouching)
+
+	if !inSolid && divergence <= tolerance {
+		st.strikes = 0
+		return true
+	}
+
+	if st.strikes == 0 {
+		st.anchor = predicted
+		st.anchorAt = now
+	}
+	st.strikes++
+	st.lastStrike = now
+	s.logger.Debug("position diverged from simulation", "player", p.GetName(),
+		"divergence", divergence, "tolerance", tolerance, "in_solid", inSolid, "strikes", st.strikes)
+
+	if st.strikes < cfg.MovementStrikes {
+		return true
+	}
+	st.strikes = 0
+	s.correctMovement(p, st, st.anchor, now)
+	return false
+}
+
+// correctMovement puts the client back where the server last agreed with it,
+// what happens to players who keep needing it is up to the violation ledger
+func (s *Server) correctMovement(p *player.Player, st *movementState, pos protocol.Vector3f, now time.Time) {
+	s.SendPlayerPositionPacketTo(p.ID, pos, p.GetOrientation(), p.ID)
+
+	p.Lock()
+	p.Position = pos
+	p.EyePos = pos
+	p.LastReportedPos = pos
+	p.Velocity = protocol.Vector3f{}
+	p.Unlock()
+
+	rtt := time.Duration(st.lastRTT) * time.Millisecond
+	st.graceUntil = now.Add(rtt + 250*time.Millisecond)
+
+	s.logger.Warn("corrected player movement", "player", p.GetName(), "id", p.ID)
+	s.recordViolation(p, anticheat.CategoryMovement, "position diverged from the server")
+}
@@ -0,0 +1,782 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/demo"
+	"github.com/siohaza/fosilo/internal/network"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+	"github.com/siohaza/fosilo/pkg/config"
+)
+
+const (
+	replayTickRate = 10 * time.Millisecond
+	replayMinSpeed = 0.1
+	replayMaxSpeed = 16.0
+)
+
+// ReplayServer hosts a recorded demo for regular clients, everyone who
+// connects watches as a spectator while admins steer playback from chat.
+// In live mode it is the spectator relay instead, fed packets from the running
+// match and playing them a fixed delay behind it.
+// Viewers are taken through the same admission and map transfer as players
+// joining the game server, the recorded map is sent with a mapSender
+type ReplayServer struct {
+	config     *config.Config
+	network    network.Transport
+	logger     *slog.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
+	running    atomic.Bool
+	loopDone   chan struct{}
+	maxViewers int
+
+	live    bool
+	delay   time.Duration
+	started time.Time
+
+	// mu guards everything below, a relay is fed from the game loop while it
+	// plays
+	mu      sync.Mutex
+	packets []demo.Packet
+	// every viewer is told it is the slot the recording was made from,
+	// viewers never see each other so they can all share it
+	viewerID uint8
+	// viewers hold their own slots in players so the map sender can tell
+	// them apart, nobody else ever sees those ids
+	players *player.Manager
+	viewers map[network.Peer]*replayViewer
+	maps    *mapSender
+	// packets from mapStart up to snapshotEnd are the map and state of the
+	// map being played, mapData is its compressed map and nextMap where the
+	// map start of one still being played in begins
+	mapStart    int
+	snapshotEnd int
+	mapData     []byte
+	nextMap     int
+
+	cursor   int
+	position time.Duration
+	speed    float64
+	paused   bool
+	lastTick time.Time
+}
+
+type replayViewer struct {
+	player *player.Player
+	name   string
+	// loaded is set once the viewer has the map and caught up to the cursor
+	loaded bool
+	joined bool
+	admin  bool
+}
+
+func NewReplay(cfg *config.Config, demoPath string, logger *slog.Logger) (*ReplayServer, error) {
+	file, err := os.Open(demoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open demo: %w", err)
+	}
+	defer file.Close()
+
+	net, err := network.NewServer(cfg.Server.Port, cfg.Server.MaxPlayers, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network server: %w", err)
+	}
+
+	return NewReplayWithTransport(cfg, file, net, logger)
+}
+
+// NewReplayWithTransport loads a demo from r and serves it over net
+func NewReplayWithTransport(cfg *config.Config, r io.Reader, net network.Transport, logger *slog.Logger) (*ReplayServer, error) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+		}))
+	}
+	if net == nil {
+		return nil, fmt.Errorf("transport is nil")
+	}
+
+	reader, err := demo.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if reader.ProtocolVersion != protocol.ProtocolVersion75 {
+		return nil, fmt.Errorf("demo was recorded with protocol version %d, only %d is supported",
+			reader.ProtocolVersion, protocol.ProtocolVersion75)
+	}
+
+	var packets []demo.Packet
+	for {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read demo: %w", err)
+		}
+		if len(packet.Data) > 0 {
+			packets = append(packets, packet)
+		}
+	}
+
+	mapStart, snapshotEnd, ok := findSnapshot(packets, len(packets), false)
+	if !ok {
+		return nil, fmt.Errorf("demo has no map or state data")
+	}
+
+	replay := newReplayServer(cfg, net, logger, cfg.Server.MaxPlayers)
+	replay.packets = packets
+	replay.useSnapshot(mapStart, snapshotEnd)
+	replay.cursor = snapshotEnd
+	replay.position = packets[snapshotEnd-1].Time
+	return replay, nil
+}
+
+// NewRelay creates the delayed spectator relay on its own port
+func NewRelay(cfg *config.Config, logger *slog.Logger) (*ReplayServer, error) {
+	net, err := network.NewServer(cfg.Relay.Port, cfg.Relay.MaxViewers, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create relay network server: %w", err)
+	}
+	return NewRelayWithTransport(cfg, net, logger)
+}
+
+// NewRelayWithTransport creates an empty relay serving over net, the match
+// reaches it through Feed
+func NewRelayWithTransport(cfg *config.Config, net network.Transport, logger *slog.Logger) (*ReplayServer, error) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+		}))
+	}
+	if net == nil {
+		return nil, fmt.Errorf("transport is nil")
+	}
+
+	relay := newReplayServer(cfg, net, logger, cfg.Relay.MaxViewers)
+	relay.live = true
+	relay.delay = time.Duration(cfg.Relay.Delay) * time.Second
+	relay.started = time.Now()
+	return relay, nil
+}
+
+func newReplayServer(cfg *config.Config, net network.Transport, logger *slog.Logger, maxViewers int) *ReplayServer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ReplayServer{
+		config:     cfg,
+		network:    net,
+		logger:     logger,
+		ctx:        ctx,
+		cancel:     cancel,
+		maxViewers: maxViewers,
+		players:    player.NewManager(),
+		viewers:    make(map[network.Peer]*replayViewer),
+		maps:       newMapSender(net, logger),
+		nextMap:    -1,
+		speed:      1,
+	}
+}
+
+// findSnapshot looks for a map start followed by its state data before end
+// and returns where it starts and the index right after the state data,
+// either the first such snapshot or with last set the latest one
+func findSnapshot(packets []demo.Packet, end int, last bool) (int, int, bool) {
+	mapStart, found, foundEnd := -1, -1, -1
+	for i := 0; i < end; i++ {
+		packet := packets[i]
+		switch protocol.PacketType(packet.Data[0]) {
+		case protocol.PacketTypeMapStart:
+			mapStart = i
+		case protocol.PacketTypeStateData:
+			if mapStart >= 0 && len(packet.Data) > 1 {
+				found, foundEnd = mapStart, i+1
+				if !last {
+					return found, foundEnd, true
+				}
+				mapStart = -1
+			}
+		}
+	}
+	return found, foundEnd, found >= 0
+}
+
+// useSnapshot makes the map recorded from mapStart to snapshotEnd the one
+// viewers are loaded with
+func (r *ReplayServer) useSnapshot(mapStart, snapshotEnd int) {
+	var mapData []byte
+	for _, packet := range r.packets[mapStart:snapshotEnd] {
+		if protocol.PacketType(packet.Data[0]) == protocol.PacketTypeMapChunk {
+			mapData = append(mapData, packet.Data[1:]...)
+		}
+	}
+
+	r.mapStart = mapStart
+	r.snapshotEnd = snapshotEnd
+	r.mapData = mapData
+	r.viewerID = r.packets[snapshotEnd-1].Data[1]
+}
+
+// Feed queues a packet from the live match, anything spectators shouldn't
+// see is dropped here
+func (r *ReplayServer) Feed(data []byte) {
+	if !r.live || len(data) == 0 || !relaySafe(data) {
+		return
+	}
+
+	r.mu.Lock()
+	r.packets = append(r.packets, demo.Packet{Time: time.Since(r.started), Data: data})
+	r.mu.Unlock()
+}
+
+func (r *ReplayServer) Start() error {
+	if err := r.network.Start(); err != nil {
+		return fmt.Errorf("failed to start network: %w", err)
+	}
+
+	r.running.Store(true)
+	r.lastTick = time.Now()
+
+	if r.live {
+		r.logger.Info("spectator relay started", "delay", r.delay)
+	} else {
+		r.logger.Info("replay started", "packets", len(r.packets), "length", r.length().Round(time.Second))
+	}
+
+	r.loopDone = make(chan struct{})
+	go r.run()
+	return nil
+}
+
+func (r *ReplayServer) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.running.Store(false)
+	if r.loopDone != nil {
+		<-r.loopDone
+	}
+
+	r.network.Stop()
+	if r.live {
+		r.logger.Info("spectator relay stopped")
+	} else {
+		r.logger.Info("replay stopped")
+	}
+}
+
+func (r *ReplayServer) run() {
+	defer close(r.loopDone)
+
+	ticker := time.NewTicker(replayTickRate)
+	defer ticker.Stop()
+
+	for r.running.Load() {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.advance()
+		}
+
+		serviceNetwork(r.network, r.logger, r)
+	}
+}
+
+func (r *ReplayServer) length() time.Duration {
+	if len(r.packets) == 0 {
+		return 0
+	}
+	return r.packets[len(r.packets)-1].Time
+}
+
+// advance moves the playback clock forward and sends every packet that is due
+func (r *ReplayServer) advance() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(r.lastTick)
+	r.lastTick = now
+	r.maps.pump(now)
+
+	if r.paused {
+		return
+	}
+
+	if r.live {
+		r.position = now.Sub(r.started) - r.delay
+	} else {
+		r.position += time.Duration(float64(elapsed) * r.speed)
+	}
+
+	for r.cursor < len(r.packets) && r.packets[r.cursor].Time <= r.position {
+		data := r.packets[r.cursor].Data
+		r.cursor++
+
+		// a new map reaches viewers through the map sender once its state
+		// data closes the snapshot, like a map change on the game server
+		switch protocol.PacketType(data[0]) {
+		case protocol.PacketTypeMapStart:
+			r.nextMap = r.cursor - 1
+			continue
+		case protocol.PacketTypeMapChunk:
+			continue
+		case protocol.PacketTypeStateData:
+			if r.nextMap >= 0 && len(data) > 1 {
+				r.useSnapshot(r.nextMap, r.cursor)
+				r.nextMap = -1
+				for _, viewer := range r.viewers {
+					r.load(viewer)
+				}
+			}
+			continue
+		}
+
+		reliable := replayReliable(data)
+		for peer, viewer := range r.viewers {
+			if !viewer.loaded {
+				continue
+			}
+			if err := r.network.SendPacket(peer, data, reliable); err != nil {
+				r.logger.Error("failed to send replay packet", "error", err)
+			}
+		}
+	}
+
+	if r.live {
+		// a new map makes everything before it useless for late joiners
+		if r.mapStart > 0 {
+			drop := r.mapStart
+			r.packets = append(r.packets[:0], r.packets[drop:]...)
+			r.cursor -= drop
+			r.mapStart = 0
+			r.snapshotEnd -= drop
+			if r.nextMap >= 0 {
+				r.nextMap -= drop
+			}
+		}
+		return
+	}
+
+	if r.cursor >= len(r.packets) {
+		r.paused = true
+		r.position = r.length()
+		r.broadcastChat("End of recording, use /play to watch again")
+	}
+}
+
+func (r *ReplayServer) handleConnect(peer network.Peer, version uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// recordings hold 0.75 packets, 0.76 clients would misread the map start
+	p, reason := admitPeer(r.players, r.maxViewers, peer, version, protocol.ProtocolVersion75)
+	if p == nil {
+		peer.DisconnectNow(uint32(reason))
+		return
+	}
+
+	viewer := &replayViewer{player: p}
+	r.viewers[peer] = viewer
+	r.load(viewer)
+	r.logger.Info("viewer connected", "address", peer.Address())
+}
+
+func (r *ReplayServer) handleDisconnect(peer network.Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	viewer, ok := r.viewers[peer]
+	if !ok {
+		return
+	}
+	delete(r.viewers, peer)
+	r.maps.forget(viewer.player.ID)
+	r.players.Remove(viewer.player.ID)
+	r.logger.Info("viewer disconnected", "address", peer.Address())
+}
+
+// load sends the viewer the map being played, then the recorded state and
+// everything lasting that happened since. Until then they are left out of
+// playback, a relay that has not played its first map yet loads them later
+func (r *ReplayServer) load(viewer *replayViewer) {
+	viewer.loaded = false
+	if r.mapData == nil {
+		return
+	}
+
+	r.maps.send(viewer.player, r.mapData, 0, "", func() {
+		peer := viewer.player.Peer
+		if err := r.network.SendPacket(peer, r.packets[r.snapshotEnd-1].Data, true); err != nil {
+			r.logger.Error("failed to send replay state", "error", err)
+			return
+		}
+		r.catchUp(peer, r.snapshotEnd, r.cursor)
+		if viewer.joined {
+			r.sendSpectator(viewer)
+		}
+		viewer.loaded = true
+	})
+}
+
+// catchUp brings a client from the packet at index from up to the cursor,
+// only sending what leaves a lasting mark on the world so a late joiner or
+// a seek doesn't replay every shot and chat line
+func (r *ReplayServer) catchUp(peer network.Peer, from, to int) {
+	lastWorldUpdate := -1
+	for i := from; i < to; i++ {
+		data := r.packets[i].Data
+		if protocol.PacketType(data[0]) == protocol.PacketTypeWorldUpdate {
+			lastWorldUpdate = i
+			continue
+		}
+		if !replayPersistent(data) {
+			continue
+		}
+		if err := r.network.SendPacket(peer, data, true); err != nil {
+			r.logger.Error("failed to send replay packet", "error", err)
+			return
+		}
+	}
+
+	if lastWorldUpdate >= 0 {
+		r.network.SendPacket(peer, r.packets[lastWorldUpdate].Data, false)
+	}
+}
+
+func (r *ReplayServer) handlePacket(peer network.Peer, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	viewer, ok := r.viewers[peer]
+	if !ok || len(data) == 0 {
+		return
+	}
+
+	switch protocol.PacketType(data[0]) {
+	case protocol.PacketTypeExistingPlayer:
+		if len(data) > 12 {
+			viewer.name = string(bytes.TrimRight(data[12:], "\x00"))
+		}
+		viewer.joined = true
+		if viewer.loaded {
+			r.sendSpectator(viewer)
+		}
+
+	case protocol.PacketTypeChatMessage:
+		var packet protocol.PacketChatMessage
+		if err := packet.Read(data); err != nil {
+			return
+		}
+		message := strings.TrimSpace(string(bytes.TrimRight(packet.Message, "\x00")))
+		if strings.HasPrefix(message, "/") {
+			r.handleCommand(peer, viewer, message)
+		}
+	}
+}
+
+// sendSpectator puts the viewer in spectator mode whatever team they picked
+func (r *ReplayServer) sendSpectator(viewer *replayViewer) {
+	packet := protocol.PacketCreatePlayer{
+		PacketID: uint8(protocol.PacketTypeCreatePlayer),
+		PlayerID: r.viewerID,
+		Team:     spectatorClientTeamID,
+		X:        256,
+		Y:        256,
+		Z:        0,
+	}
+	copy(packet.Name[:protocol.PlayerNameLen-1], viewer.name)
+
+	data, err := marshalPacket(&packet)
+	if err != nil {
+		return
+	}
+	r.network.SendPacket(viewer.player.Peer, data, true)
+}
+
+func (r *ReplayServer) handleCommand(peer network.Peer, viewer *replayViewer, message string) {
+	fields := strings.Fields(strings.TrimPrefix(message, "/"))
+	if len(fields) == 0 {
+		return
+	}
+	command := strings.ToLower(fields[0])
+	args := fields[1:]
+
+	if r.live {
+		// the delay is the whole point of the relay, nobody gets to skip it
+		r.sendChat(peer, r.status())
+		return
+	}
+
+	if command == "login" {
+		if len(args) == 1 && r.checkPassword(args[0]) {
+			viewer.admin = true
+			r.sendChat(peer, "Logged in, you can now control playback")
+		} else {
+			r.sendChat(peer, "Invalid password")
+		}
+		return
+	}
+
+	if command == "status" || command == "time" {
+		r.sendChat(peer, r.status())
+		return
+	}
+
+	if command != "pause" && command != "play" && command != "resume" && command != "seek" && command != "speed" {
+		r.sendChat(peer, "Commands: /status, /pause, /play, /seek <[+-]time>, /speed <factor>, /login <password>")
+		return
+	}
+
+	// playback is shared, without an admin or manager password nobody
+	// gets to steer it
+	if !viewer.admin {
+		if r.config.Passwords.Admin == "" && r.config.Passwords.Manager == "" {
+			r.sendChat(peer, "Playback control is off, no admin password is set")
+		} else {
+			r.sendChat(peer, "You need to /login to control playback")
+		}
+		return
+	}
+
+	switch command {
+	case "pause":
+		r.paused = true
+		r.broadcastChat("Playback paused at " + formatReplayTime(r.position))
+
+	case "play", "resume":
+		if r.cursor >= len(r.packets) {
+			r.seek(0)
+		}
+		r.paused = false
+		r.broadcastChat("Playback resumed")
+
+	case "seek":
+		if len(args) != 1 {
+			r.sendChat(peer, "Usage: /seek <time>, for example /seek 5:30, /seek +30 or /seek -10")
+			return
+		}
+		target, err := parseReplayTime(args[0], r.position)
+		if err != nil {
+			r.sendChat(peer, err.Error())
+			return
+		}
+		r.seek(target)
+		r.broadcastChat("Seeked to " + formatReplayTime(r.position))
+
+	case "speed":
+		if len(args) != 1 {
+			r.sendChat(peer, fmt.Sprintf("Playback speed is %.2gx", r.speed))
+			return
+		}
+		speed, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "x"), 64)
+		if err != nil || speed < replayMinSpeed || speed > replayMaxSpeed {
+			r.sendChat(peer, fmt.Sprintf("Speed must be between %.1g and %.0f", replayMinSpeed, replayMaxSpeed))
+			return
+		}
+		r.speed = speed
+		r.broadcastChat(fmt.Sprintf("Playback speed set to %.2gx", speed))
+	}
+}
+
+// checkPassword accepts the admin and manager passwords from the config
+func (r *ReplayServer) checkPassword(password string) bool {
+	passwords := r.config.Passwords
+	return (passwords.Admin != "" && password == passwords.Admin) ||
+		(passwords.Manager != "" && password == passwords.Manager)
+}
+
+// seek jumps to target, going forward only sends what happened in between
+// while going back, or past a map change, loads every viewer again
+func (r *ReplayServer) seek(target time.Duration) {
+	_, first, _ := findSnapshot(r.packets, len(r.packets), false)
+	if start := r.packets[first-1].Time; target < start {
+		target = start
+	}
+	if target > r.length() {
+		target = r.length()
+	}
+
+	index := first
+	for index < len(r.packets) && r.packets[index].Time <= target {
+		index++
+	}
+
+	from := r.cursor
+	r.cursor = index
+	r.position = target
+	r.lastTick = time.Now()
+	r.nextMap = -1
+
+	mapStart, snapshotEnd, _ := findSnapshot(r.packets, index, true)
+	if index >= from && mapStart == r.mapStart {
+		for peer, viewer := range r.viewers {
+			if viewer.loaded {
+				r.catchUp(peer, from, index)
+			}
+		}
+		return
+	}
+
+	r.useSnapshot(mapStart, snapshotEnd)
+	for _, viewer := range r.viewers {
+		r.load(viewer)
+	}
+}
+
+func (r *ReplayServer) status() string {
+	if r.live {
+		return fmt.Sprintf("Live relay, %d seconds behind the match", int(r.delay.Seconds()))
+	}
+
+	state := "playing"
+	if r.paused {
+		state = "paused"
+	}
+	return fmt.Sprintf("%s / %s, %s at %.2gx", formatReplayTime(r.position), formatReplayTime(r.length()), state, r.speed)
+}
+
+func (r *ReplayServer) sendChat(peer network.Peer, message string) {
+	data, err := replayChatPacket(message)
+	if err != nil {
+		return
+	}
+	r.network.SendPacket(peer, data, true)
+}
+
+func (r *ReplayServer) broadcastChat(message string) {
+	for peer := range r.viewers {
+		r.sendChat(peer, message)
+	}
+}
+
+func replayChatPacket(message string) ([]byte, error) {
+	chatMsg, err := protocol.StringToCP437(message)
+	if err != nil {
+		return nil, err
+	}
+	return marshalPacket(&protocol.PacketChatMessage{
+		PacketID: uint8(protocol.PacketTypeChatMessage),
+		PlayerID: 255,
+		Type:     protocol.ChatTypeSystem,
+		Message:  chatMsg,
+	})
+}
+
+// replayPersistent reports packets that change the world or the player list,
+// as opposed to momentary ones like shots, input and chat
+func replayPersistent(data []byte) bool {
+	switch protocol.PacketType(data[0]) {
+	case protocol.PacketTypeExistingPlayer,
+		protocol.PacketTypeCreatePlayer,
+		protocol.PacketTypePlayerLeft,
+		protocol.PacketTypeKillAction,
+		protocol.PacketTypeMoveObject,
+		protocol.PacketTypeBlockAction,
+		protocol.PacketTypeBlockLine,
+		protocol.PacketTypeSetColor,
+		protocol.PacketTypeSetTool,
+		protocol.PacketTypeChangeWeapon,
+		protocol.PacketTypeShortPlayerData,
+		protocol.PacketTypeIntelPickup,
+		protocol.PacketTypeIntelDrop,
+		protocol.PacketTypeIntelCapture,
+		protocol.PacketTypeTerritoryCapture,
+		protocol.PacketTypeFogColor:
+		return true
+	}
+	return false
+}
+
+// relaySafe reports packets a delayed spectator may see: the world update,
+// block, kill and chat streams plus what is needed to make sense of them.
+// Inputs, tools and grenades are left out and team chat never reaches the
+// recorders in the first place
+func relaySafe(data []byte) bool {
+	switch protocol.PacketType(data[0]) {
+	case protocol.PacketTypeChatMessage:
+		return len(data) > 2 && protocol.ChatType(data[2]) != protocol.ChatTypeTeam
+	case protocol.PacketTypeWorldUpdate,
+		protocol.PacketTypeBlockAction,
+		protocol.PacketTypeBlockLine,
+		protocol.PacketTypeSetColor,
+		protocol.PacketTypeKillAction,
+		protocol.PacketTypeMapStart,
+		protocol.PacketTypeMapChunk,
+		protocol.PacketTypeStateData,
+		protocol.PacketTypeExistingPlayer,
+		protocol.PacketTypeCreatePlayer,
+		protocol.PacketTypePlayerLeft,
+		protocol.PacketTypeShortPlayerData,
+		protocol.PacketTypeMoveObject,
+		protocol.PacketTypeIntelPickup,
+		protocol.PacketTypeIntelDrop,
+		protocol.PacketTypeIntelCapture,
+		protocol.PacketTypeTerritoryCapture,
+		protocol.PacketTypeProgressBar,
+		protocol.PacketTypeFogColor:
+		return true
+	}
+	return false
+}
+
+func replayReliable(data []byte) bool {
+	switch protocol.PacketType(data[0]) {
+	case protocol.PacketTypeWorldUpdate,
+		protocol.PacketTypePositionData,
+		protocol.PacketTypeOrientationData,
+		protocol.PacketTypeInputData,
+		protocol.PacketTypeWeaponInput:
+		return false
+	}
+	return true
+}
+
+// parseReplayTime reads seconds, mm:ss or hh:mm:ss, a leading + or - makes
+// it relative to current
+func parseReplayTime(arg string, current time.Duration) (time.Duration, error) {
+	relative := 0
+	if strings.HasPrefix(arg, "+") {
+		relative = 1
+		arg = arg[1:]
+	} else if strings.HasPrefix(arg, "-") {
+		relative = -1
+		arg = arg[1:]
+	}
+
+	var seconds float64
+	for _, part := range strings.Split(arg, ":") {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid time %q", arg)
+		}
+		seconds = seconds*60 + value
+	}
+
+	offset := time.Duration(seconds * float64(time.Second))
+	switch relative {
+	case 1:
+		return current + offset, nil
+	case -1:
+		return current - offset, nil
+	}
+	return offset, nil
+}
+
+func formatReplayTime(d time.Duration) string {
+	total := int(d.Seconds())
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
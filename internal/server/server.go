@@ -2,7 +2,6 @@ package server
 
 import (
 	"bytes"
-	"compress/zlib"
 	"context"
 	"encoding/binary"
 	"fmt"
@@ -11,12 +10,19 @@ import (
 	"log/slog"
 	"math"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/siohaza/fosilo/internal/accounts"
+	"github.com/siohaza/fosilo/internal/anticheat"
+	"github.com/siohaza/fosilo/internal/audit"
 	"github.com/siohaza/fosilo/internal/bans"
 	"github.com/siohaza/fosilo/internal/callbacks"
+	"github.com/siohaza/fosilo/internal/demo"
 	"github.com/siohaza/fosilo/internal/gamemode"
 	"github.com/siohaza/fosilo/internal/gamestate"
 	"github.com/siohaza/fosilo/internal/masterserver"
@@ -32,14 +38,15 @@ import (
 	"github.com/siohaza/fosilo/pkg/lua"
 	"github.com/siohaza/fosilo/pkg/vxl"
 	"github.com/siohaza/fosilo/pkg/vxlgen"
-
-	"github.com/codecat/go-enet"
 )
 
 const (
 	fallbackTerrainColor  uint32 = 0x674028
 	spectatorTeamID       uint8  = 255
 	spectatorClientTeamID uint8  = 2
+
+	// lateral slack in blocks when checking a hit against the shooter's aim
+	hitTolerance float32 = 5.0
 )
 
 func toInternalTeamID(team uint8) (uint8, bool) {
@@ -62,16 +69,18 @@ func toNetworkTeamID(team uint8) uint8 {
 
 type Server struct {
 	config               *config.Config
-	network              *network.Server
+	network              network.Transport
 	gameState            *gamestate.GameState
 	gameMode             gamemode.GameMode
 	logger               *slog.Logger
-	running              bool
+	running              atomic.Bool
 	tickRate             time.Duration
 	startTime            time.Time
 	luaCommands          *lua.CommandManager
 	voteManager          *vote.Manager
 	banManager           *bans.Manager
+	accounts             *accounts.Store
+	auditLog             *audit.Log
 	masterServers        []*masterserver.Client
 	pingHandler          *ping.Handler
 	currentMap           int
@@ -80,7 +89,36 @@ type Server struct {
 	callbacks            *callbacks.CallbackChain
 	ctx                  context.Context
 	cancel               context.CancelFunc
+	loopDone             chan struct{}
 	pendingMapRotationAt time.Time
+	bots                 map[uint8]*bot
+	nextBotBalance       time.Time
+	demo                 *demo.Recorder
+	demoMu               sync.Mutex
+	relay                *ReplayServer
+	viewer               viewerStream
+	pendingJoins         map[uint8]pendingJoin
+	maps                 *mapSender
+	mapCache             mapCache
+	visibility           map[espPair]*espVisibility
+	aimStats             map[uint8]*anticheat.AimStats
+	movement             map[uint8]*movementState
+	ledgers              map[uint8]*anticheat.Ledger
+	ledgerKeys           map[uint8]string
+	leftLedgers          map[string]*anticheat.Ledger
+	blockDamage          map[blockKey]*blockDamage
+	blockTools           map[uint8]*blockTool
+	nextBlockPrune       time.Time
+
+	// players using a registered name, until when they have to log in
+	nameGrace map[uint8]time.Time
+	// players whose password is being hashed in the background
+	passwordChecks map[uint8]bool
+	// work handed back to the game loop from other goroutines
+	tasks chan func()
+
+	// the player whose command is running, audit entries are theirs
+	actor atomic.Pointer[player.Player]
 }
 
 func New(cfg *config.Config, logger *slog.Logger) (*Server, error) {
@@ -95,6 +133,26 @@ func New(cfg *config.Config, logger *slog.Logger) (*Server, error) {
 		return nil, fmt.Errorf("failed to create network server: %w", err)
 	}
 
+	return NewWithTransport(cfg, net, logger)
+}
+
+// NewWithTransport creates a server on top of an existing transport, such as a
+// network.Loopback for tests and simulations
+func NewWithTransport(cfg *config.Config, net network.Transport, logger *slog.Logger) (*Server, error) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+		}))
+	}
+	if net == nil {
+		return nil, fmt.Errorf("transport is nil")
+	}
+
+	roles, err := lua.NewRoles(cfg.Roles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid roles: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	srv := &Server{
@@ -104,15 +162,46 @@ func New(cfg *config.Config, logger *slog.Logger) (*Server, error) {
 		tickRate: time.Second / 60,
 		ctx:      ctx,
 		cancel:   cancel,
+		bots:     make(map[uint8]*bot),
+
+		pendingJoins: make(map[uint8]pendingJoin),
+		maps:         newMapSender(net, logger),
+		visibility:   make(map[espPair]*espVisibility),
+		aimStats:     make(map[uint8]*anticheat.AimStats),
+		movement:     make(map[uint8]*movementState),
+		ledgers:      make(map[uint8]*anticheat.Ledger),
+		ledgerKeys:   make(map[uint8]string),
+		leftLedgers:  make(map[string]*anticheat.Ledger),
+		blockDamage:  make(map[blockKey]*blockDamage),
+		blockTools:   make(map[uint8]*blockTool),
+		nameGrace:    make(map[uint8]time.Time),
+
+		passwordChecks: make(map[uint8]bool),
+		tasks:          make(chan func(), 64),
 	}
 
-	srv.banManager = bans.NewManager("data/bans.json")
+	srv.banManager = bans.NewManager(bans.DefaultPath)
 	if err := srv.banManager.Load(); err != nil {
 		logger.Warn("failed to load bans", "error", err)
 	}
 
+	auditPath := cfg.Server.AuditLog
+	if auditPath == "" {
+		auditPath = audit.DefaultPath
+	}
+	if srv.auditLog, err = audit.Open(auditPath); err != nil {
+		logger.Warn("failed to open audit log", "error", err)
+	}
+	srv.banManager.SetAudit(auditRecorder{srv})
+
+	srv.accounts = accounts.NewStore(accounts.DefaultPath)
+	if err := srv.accounts.Load(); err != nil {
+		logger.Warn("failed to load accounts", "error", err)
+	}
+
 	srv.voteManager = vote.NewManager()
 	srv.luaCommands = lua.NewCommandManager(logger)
+	srv.luaCommands.SetRoles(roles)
 	srv.callbacks = callbacks.NewCallbackChain()
 
 	pingPort := cfg.Server.Port + 1
@@ -175,10 +264,25 @@ func (s *Server) Start() error {
 	s.updatePingServerInfo()
 
 	s.startTime = time.Now()
-	s.running = true
+	s.running.Store(true)
 
 	s.syncIntelPositions()
 
+	if s.config.Demo.Enabled {
+		if _, err := s.StartDemo(); err != nil {
+			s.logger.Error("failed to start demo recording", "error", err)
+		}
+	}
+
+	if s.config.Relay.Enabled {
+		relayNet, err := network.NewServer(s.config.Relay.Port, s.config.Relay.MaxViewers, s.logger)
+		if err != nil {
+			s.logger.Error("failed to create spectator relay", "error", err)
+		} else if err := s.StartRelay(relayNet); err != nil {
+			s.logger.Error("failed to start spectator relay", "error", err)
+		}
+	}
+
 	s.logger.Info("server started", "name", s.config.Server.Name)
 
 	if s.config.Server.Master {
@@ -204,8 +308,10 @@ func (s *Server) Start() error {
 		}
 	}
 
+	s.loopDone = make(chan struct{})
 	go s.run()
 	go s.startPeriodicAnnouncements()
+	s.followBanLists()
 
 	return nil
 }
@@ -217,7 +323,11 @@ func (s *Server) Stop() {
 		s.cancel()
 	}
 
-	s.running = false
+	s.running.Store(false)
+	// the run loop still touches the network, let it finish its pass first
+	if s.loopDone != nil {
+		<-s.loopDone
+	}
 
 	if s.voteManager != nil {
 		s.voteManager.Stop()
@@ -225,6 +335,22 @@ func (s *Server) Stop() {
 
 	s.network.Stop()
 
+	if s.IsRecordingDemo() {
+		if _, err := s.StopDemo(); err != nil {
+			s.logger.Error("failed to close demo", "error", err)
+		}
+	}
+
+	if s.relay != nil {
+		s.relay.Stop()
+	}
+
+	if s.auditLog != nil {
+		if err := s.auditLog.Close(); err != nil {
+			s.logger.Error("failed to close audit log", "error", err)
+		}
+	}
+
 	if s.pingHandler != nil {
 		s.pingHandler.Stop()
 	}
@@ -281,7 +407,13 @@ func (s *Server) ReloadGamemode() error {
 	return nil
 }
 
+// GetConfigPassword returns the shared password of a role, none while
+// accounts replace them
 func (s *Server) GetConfigPassword(role string) string {
+	if s.config.Accounts.Enabled && !s.config.Accounts.SharedPasswords {
+		return ""
+	}
+
 	switch role {
 	case "manager":
 		return s.config.Passwords.Manager
@@ -776,13 +908,15 @@ func setVxlgenIntelPositions(vxlMap *vxl.Map, mapCfg *config.MapConfig, result *
 }
 
 func (s *Server) run() {
+	defer close(s.loopDone)
+
 	ticker := time.NewTicker(s.tickRate)
 	defer ticker.Stop()
 
 	worldUpdateTicker := time.NewTicker(time.Second / 10)
 	defer worldUpdateTicker.Stop()
 
-	for s.running {
+	for s.running.Load() {
 		select {
 		case <-s.ctx.Done():
 			s.logger.Info("server context cancelled, exiting run loop")
@@ -793,19 +927,34 @@ func (s *Server) run() {
 
 		case <-worldUpdateTicker.C:
 			s.sendWorldUpdate()
+
+		case task := <-s.tasks:
+			task()
 		}
 
 		s.handleNetworkEvents()
 	}
 }
 
+// post runs fn on the game loop, it is how other goroutines hand back what
+// they worked out
+func (s *Server) post(fn func()) {
+	select {
+	case s.tasks <- fn:
+	case <-s.ctx.Done():
+	}
+}
+
 func (s *Server) update() {
 	dt := float32(s.tickRate.Seconds())
 	gameTime := float32(time.Since(s.startTime).Seconds())
+	now := time.Now()
 
 	s.gameState.Players.ForEach(func(p *player.Player) {
 		if p.IsAlive() {
 			fallDamage := physics.MovePlayer(p, s.gameState.Map, dt, gameTime)
+			p.RecordPosition(now)
+			s.updateBlockTools(p, now)
 			if fallDamage > 0 {
 				if s.damagePlayer(p.ID, uint8(fallDamage), p.GetPosition(), protocol.HurtTypeFall) {
 					s.handleEnvironmentKill(p, protocol.KillTypeFall)
@@ -831,11 +980,18 @@ func (s *Server) update() {
 		}
 	})
 
+	s.expirePendingJoins(now)
+	s.maps.pump(now)
+	s.forgetBlocks(now)
+	s.enforceReservedNames(now)
+
 	if !s.pendingMapRotationAt.IsZero() && time.Now().After(s.pendingMapRotationAt) {
 		s.pendingMapRotationAt = time.Time{}
 		s.rotateMap()
 	}
 
+	s.updateBots(now)
+
 	s.updateGrenades(dt)
 
 	if s.gameState.IsTimeLimitReached() {
@@ -849,11 +1005,20 @@ func (s *Server) update() {
 	}
 }
 
-func (s *Server) handleNetworkEvents() {
+// peerHandler is what sits on top of a transport, the game server and the
+// replay server both take their peers through serviceNetwork
+type peerHandler interface {
+	handleConnect(peer network.Peer, version uint32)
+	handleDisconnect(peer network.Peer)
+	handlePacket(peer network.Peer, data []byte)
+}
+
+// serviceNetwork hands up to 100 queued transport events to h
+func serviceNetwork(net network.Transport, logger *slog.Logger, h peerHandler) {
 	for i := 0; i < 100; i++ {
-		event, err := s.network.Service(0)
+		event, err := net.Service(0)
 		if err != nil {
-			s.logger.Error("network service error", "error", err)
+			logger.Error("network service error", "error", err)
 			continue
 		}
 
@@ -863,39 +1028,69 @@ func (s *Server) handleNetworkEvents() {
 
 		switch event.Type {
 		case network.EventTypeConnect:
-			s.handleConnect(event.Peer)
+			h.handleConnect(event.Peer, event.Version)
 
 		case network.EventTypeDisconnect:
-			s.handleDisconnect(event.Peer)
+			h.handleDisconnect(event.Peer)
 
 		case network.EventTypeReceive:
-			s.handlePacket(event.Peer, event.Data)
+			h.handlePacket(event.Peer, event.Data)
 		}
 	}
 }
 
-func (s *Server) handleConnect(peer enet.Peer) {
-	ip := peer.GetAddress().String()
+// admitPeer starts every connection, the peer has to speak one of versions
+// and is given the lowest free of slots in players. It is added in the
+// loading state, or nil is returned with the reason to disconnect it with
+func admitPeer(players *player.Manager, slots int, peer network.Peer, version uint32, versions ...uint32) (*player.Player, protocol.DisconnectReason) {
+	if !slices.Contains(versions, version) {
+		return nil, protocol.DisconnectReasonWrongVersion
+	}
+
+	playerID, ok := players.FindFreeID(slots)
+	if !ok {
+		return nil, protocol.DisconnectReasonServerFull
+	}
+
+	p := player.New(playerID, peer)
+	p.ProtocolVersion = uint8(version)
+	p.State = player.PlayerStateLoading
+	players.Add(p)
+	return p, protocol.DisconnectReasonUndefined
+}
+
+func (s *Server) handleNetworkEvents() {
+	serviceNetwork(s.network, s.logger, s)
+}
+
+func (s *Server) handleConnect(peer network.Peer, version uint32) {
+	ip := peer.Address()
 
 	if banned, ban := s.banManager.IsBanned(ip); banned {
-		s.logger.Info("banned player attempted to connect", "ip", ip, "reason", ban.Reason)
+		s.logger.Info("banned player attempted to connect", "ip", ip, "reason", ban.Reason, "source", ban.Source)
 		peer.DisconnectNow(uint32(protocol.DisconnectReasonBanned))
 		return
 	}
 
-	playerID, ok := s.gameState.Players.FindFreeID(s.config.Server.MaxPlayers)
-	if !ok {
+	admit := func() (*player.Player, protocol.DisconnectReason) {
+		return admitPeer(s.gameState.Players, s.config.Server.MaxPlayers, peer, version,
+			protocol.ProtocolVersion75, protocol.ProtocolVersion76)
+	}
+	p, reason := admit()
+	if reason == protocol.DisconnectReasonServerFull && s.removeAnyBot() {
+		p, reason = admit()
+	}
+	switch reason {
+	case protocol.DisconnectReasonWrongVersion:
+		s.logger.Info("rejecting unsupported protocol version", "ip", ip, "version", version)
+	case protocol.DisconnectReasonServerFull:
 		s.logger.Warn("server full, rejecting connection")
-		peer.DisconnectNow(uint32(protocol.DisconnectReasonServerFull))
+	}
+	if p == nil {
+		peer.DisconnectNow(uint32(reason))
 		return
 	}
-
-	p := player.New(playerID, peer)
-	s.gameState.Players.Add(p)
-
-	p.Lock()
-	p.State = player.PlayerStateLoading
-	p.Unlock()
+	playerID := p.ID
 
 	s.callbacks.OnConnect(playerID)
 
@@ -910,17 +1105,22 @@ func (s *Server) handleConnect(peer enet.Peer) {
 
 	s.updatePingServerInfo()
 
-	s.logger.Info("player connected", "id", playerID, "address", ip)
+	s.logger.Info("player connected", "id", playerID, "address", ip, "protocol", protocol.VersionString(uint8(version)))
 }
 
-func (s *Server) handleDisconnect(peer enet.Peer) {
+func (s *Server) handleDisconnect(peer network.Peer) {
 	p, ok := s.gameState.Players.GetByPeer(peer)
 	if !ok {
 		return
 	}
 
 	s.logger.Info("player disconnected", "id", p.ID, "name", p.GetName())
+	s.removePlayer(p)
+}
 
+// removePlayer drops the intel the player carried, tells everyone they left
+// and frees their slot
+func (s *Server) removePlayer(p *player.Player) {
 	p.RLock()
 	hasIntel := p.HasIntel
 	team := p.Team
@@ -937,6 +1137,14 @@ func (s *Server) handleDisconnect(peer enet.Peer) {
 	s.broadcastPlayerLeft(p.ID)
 
 	s.voteManager.HandlePlayerDisconnect(p.ID)
+	delete(s.pendingJoins, p.ID)
+	s.maps.forget(p.ID)
+	s.forgetVisibility(p.ID)
+	delete(s.aimStats, p.ID)
+	delete(s.movement, p.ID)
+	delete(s.blockTools, p.ID)
+	delete(s.nameGrace, p.ID)
+	s.retainLedger(p)
 
 	s.callbacks.OnDisconnect(p.ID)
 
@@ -961,7 +1169,6 @@ func (s *Server) checkRateLimit(p *player.Player, packetType protocol.PacketType
 	now := time.Now()
 
 	p.Lock()
-	defer p.Unlock()
 
 	if now.Sub(p.LastRateLimitReset) >= time.Second {
 		p.PacketCounts = make(map[protocol.PacketType]int)
@@ -979,17 +1186,7 @@ func (s *Server) checkRateLimit(p *player.Player, packetType protocol.PacketType
 			"id", p.ID,
 			"packets", p.TotalPacketCount,
 			"violations", p.RateLimitViolations)
-
-		if p.RateLimitViolations >= 5 {
-			s.logger.Warn("disconnecting player for excessive rate limit violations",
-				"player", p.Name,
-				"id", p.ID)
-			peer := p.Peer
-			p.Unlock()
-			peer.DisconnectLater(0)
-			return false
-		}
-		return false
+		return s.rateLimitViolation(p, "burst")
 	}
 
 	var perTypeLimit int
@@ -1013,23 +1210,33 @@ func (s *Server) checkRateLimit(p *player.Player, packetType protocol.PacketType
 			"count", p.PacketCounts[packetType],
 			"limit", perTypeLimit,
 			"violations", p.RateLimitViolations)
+		return s.rateLimitViolation(p, fmt.Sprintf("packet type %d", packetType))
+	}
 
-		if p.RateLimitViolations >= 5 {
-			s.logger.Warn("disconnecting player for excessive rate limit violations",
-				"player", p.Name,
-				"id", p.ID)
-			peer := p.Peer
-			p.Unlock()
-			peer.DisconnectLater(0)
-			return false
-		}
+	p.Unlock()
+	return true
+}
+
+// rateLimitViolation is called with p locked, it unlocks p and drops the
+// packet that went over the limit
+func (s *Server) rateLimitViolation(p *player.Player, detail string) bool {
+	violations := p.RateLimitViolations
+	peer := p.Peer
+	p.Unlock()
+
+	if violations >= 5 {
+		s.logger.Warn("disconnecting player for excessive rate limit violations",
+			"player", p.GetName(),
+			"id", p.ID)
+		peer.DisconnectLater(0)
 		return false
 	}
 
-	return true
+	s.recordViolation(p, anticheat.CategoryRateLimit, detail)
+	return false
 }
 
-func (s *Server) handlePacket(peer enet.Peer, data []byte) {
+func (s *Server) handlePacket(peer network.Peer, data []byte) {
 	if len(data) < 1 {
 		return
 	}
@@ -1070,6 +1277,9 @@ func (s *Server) handlePacket(peer enet.Peer, data []byte) {
 	case protocol.PacketTypeExistingPlayer:
 		s.handleExistingPlayer(p, data)
 
+	case protocol.PacketTypeMapCached:
+		s.maps.handleMapCached(p, data)
+
 	case protocol.PacketTypeBlockAction:
 		s.handleBlockAction(p, data)
 
@@ -1122,11 +1332,20 @@ func (s *Server) handlePositionData(p *player.Player, data []byte) {
 		return
 	}
 
+	pos := protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
+	if !s.validateMovement(p, pos) {
+		return
+	}
+
 	p.Lock()
-	p.Position = protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
-	p.EyePos = protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
-	p.LastReportedPos = protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
-	p.Velocity = protocol.Vector3f{X: 0, Y: 0, Z: 0}
+	p.Position = pos
+	p.EyePos = pos
+	p.LastReportedPos = pos
+	// validated players keep their simulated velocity so the next report is
+	// compared against a simulation that did not restart from standing still
+	if !s.config.AntiCheat.MovementValidation {
+		p.Velocity = protocol.Vector3f{X: 0, Y: 0, Z: 0}
+	}
 	p.Unlock()
 }
 
@@ -1144,7 +1363,12 @@ func (s *Server) handleOrientationData(p *player.Player, data []byte) {
 		return
 	}
 
-	p.SetOrientation(protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z})
+	ori := protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
+	p.SetOrientation(ori)
+
+	if stats := s.aimStatsFor(p); stats != nil {
+		stats.Orientation(time.Now(), ori)
+	}
 }
 
 func (s *Server) handleInputData(p *player.Player, data []byte) {
@@ -1210,6 +1434,9 @@ func (s *Server) handleShot(p *player.Player) {
 	}
 
 	s.callbacks.OnWeaponFire(p)
+	if stats := s.aimStatsFor(p); stats != nil {
+		stats.Shot(p.GetWeapon())
+	}
 
 	pos := p.GetPosition()
 	ori := p.GetOrientation()
@@ -1220,13 +1447,23 @@ func (s *Server) handleShot(p *player.Player) {
 		Z: pos.Z - 0.3,
 	}
 
-	weapon := p.GetWeapon()
+	for _, direction := range pelletDirections(p.GetWeapon(), ori) {
+		s.processShot(p, eyePos, direction)
+	}
+}
+
+// shotgunSpread is how far apart pelletDirections puts shotgun pellets
+const shotgunSpread = 0.05
+
+// pelletDirections spreads the pellets of one shot around ori
+func pelletDirections(weapon protocol.WeaponType, ori protocol.Vector3f) []protocol.Vector3f {
 	pellets := protocol.GetPelletCount(weapon)
 	spread := float32(0.01)
 	if weapon == protocol.WeaponTypeShotgun {
-		spread = 0.05
+		spread = shotgunSpread
 	}
 
+	directions := make([]protocol.Vector3f, 0, pellets)
 	for i := 0; i < pellets; i++ {
 		direction := ori
 
@@ -1244,8 +1481,9 @@ func (s *Server) handleShot(p *player.Player) {
 			}
 		}
 
-		s.processShot(p, eyePos, direction)
+		directions = append(directions, direction)
 	}
+	return directions
 }
 
 func (s *Server) isValidTarget(shooter, target *player.Player) bool {
@@ -1277,9 +1515,7 @@ func (s *Server) determineHitType(closestPoint, targetPos protocol.Vector3f) pro
 	return protocol.HitTypeLegs
 }
 
-func (s *Server) checkPlayerHit(eyePos, direction protocol.Vector3f, target *player.Player, maxDistance float32) (bool, float32, protocol.HitType) {
-	targetPos := target.GetPosition()
-
+func (s *Server) checkPlayerHit(eyePos, direction, targetPos protocol.Vector3f, maxDistance float32) (bool, float32, protocol.HitType) {
 	toTarget := protocol.Vector3f{
 		X: targetPos.X - eyePos.X,
 		Y: targetPos.Y - eyePos.Y,
@@ -1325,7 +1561,8 @@ func (s *Server) findClosestPlayerHit(shooter *player.Player, eyePos, direction
 			return
 		}
 
-		hit, distance, ht := s.checkPlayerHit(eyePos, direction, target, closestDistance)
+		targetPos := s.rewoundPosition(shooter, target)
+		hit, distance, ht := s.checkPlayerHit(eyePos, direction, targetPos, closestDistance)
 		if hit {
 			closestPlayer = target
 			closestDistance = distance
@@ -1370,23 +1607,20 @@ func (s *Server) checkWinConditionAndRotate() {
 func (s *Server) processShot(shooter *player.Player, eyePos, direction protocol.Vector3f) {
 	maxRange := float32(128.0)
 
-	closestPlayer, hitType, _ := s.findClosestPlayerHit(shooter, eyePos, direction, maxRange)
-	hit, hitPos, _, _ := physics.RaycastVXL(s.gameState.Map, eyePos, direction, maxRange)
+	closestPlayer, hitType, playerDistance := s.findClosestPlayerHit(shooter, eyePos, direction, maxRange)
+	hit, hitPos, hitBlock, _ := physics.RaycastVXL(s.gameState.Map, eyePos, direction, maxRange)
 
-	if closestPlayer != nil {
-		targetPos := closestPlayer.GetPosition()
-		playerDistance := s.calculateDistance(protocol.Vector3f{
-			X: targetPos.X - eyePos.X,
-			Y: targetPos.Y - eyePos.Y,
-			Z: targetPos.Z - eyePos.Z,
-		})
-
-		terrainDistance := s.calculateDistance(protocol.Vector3f{
-			X: hitPos.X - eyePos.X,
-			Y: hitPos.Y - eyePos.Y,
-			Z: hitPos.Z - eyePos.Z,
-		})
+	terrainDistance := s.calculateDistance(protocol.Vector3f{
+		X: hitPos.X - eyePos.X,
+		Y: hitPos.Y - eyePos.Y,
+		Z: hitPos.Z - eyePos.Z,
+	})
+	if hit && s.config.AntiCheat.BlockDamage && (closestPlayer == nil || terrainDistance <= playerDistance) {
+		s.damageBlock(int(hitBlock.X), int(hitBlock.Y), int(hitBlock.Z),
+			blockDamageFor(protocol.ItemTypeGun, shooter.GetWeapon()), time.Now())
+	}
 
+	if closestPlayer != nil {
 		if !hit || playerDistance < terrainDistance {
 			damage := physics.CalculateDamage(shooter.Weapon, hitType, playerDistance*playerDistance)
 			if s.damagePlayer(closestPlayer.ID, damage, eyePos, protocol.HurtTypeWeapon) {
@@ -1400,6 +1634,22 @@ func (s *Server) processShot(shooter *player.Player, eyePos, direction protocol.
 	}
 }
 
+// rewoundPosition returns where the target was when the shooter saw it,
+// going back by the shooter's round trip time up to the configured window
+func (s *Server) rewoundPosition(shooter, target *player.Player) protocol.Vector3f {
+	if !s.config.AntiCheat.LagCompensation || shooter.Peer == nil {
+		return target.GetPosition()
+	}
+
+	rewind := time.Duration(shooter.Peer.RoundTripTime()) * time.Millisecond
+	maxRewind := time.Duration(s.config.AntiCheat.MaxRewindMs) * time.Millisecond
+	if rewind > maxRewind {
+		rewind = maxRewind
+	}
+
+	return target.PositionAt(time.Now().Add(-rewind))
+}
+
 func (s *Server) validateHitTarget(attacker, target *player.Player) bool {
 	if attacker.Team > 1 || target.GetTeam() > 1 {
 		return false
@@ -1427,21 +1677,25 @@ func (s *Server) validateWeaponState(p *player.Player, hitType protocol.HitType)
 	if hitType == protocol.HitTypeMelee {
 		if tool != protocol.ItemTypeSpade {
 			s.logger.Warn("melee hit with wrong tool", "player", name, "tool", tool)
+			s.recordViolation(p, anticheat.CategoryWeapon, "melee hit with wrong tool")
 			return false
 		}
 	} else {
 		if tool != protocol.ItemTypeGun {
 			s.logger.Warn("weapon hit with wrong tool", "player", name, "tool", tool)
+			s.recordViolation(p, anticheat.CategoryWeapon, "weapon hit with wrong tool")
 			return false
 		}
 
 		if reloading {
 			s.logger.Warn("hit while reloading", "player", name)
+			s.recordViolation(p, anticheat.CategoryWeapon, "hit while reloading")
 			return false
 		}
 
 		if magazineAmmo == 0 {
 			s.logger.Warn("hit with no ammo", "player", name)
+			s.recordViolation(p, anticheat.CategoryWeapon, "hit with no ammo")
 			return false
 		}
 	}
@@ -1461,6 +1715,7 @@ func (s *Server) validateWeaponRange(p *player.Player, targetName string, weapon
 			"distance", distance,
 			"max", maxWeaponRange,
 			"weapon", weapon)
+		s.recordViolation(p, anticheat.CategoryWeapon, "hit out of weapon range")
 		return false
 	}
 
@@ -1523,7 +1778,7 @@ func (s *Server) handleHit(p *player.Player, data []byte) {
 	}
 
 	pos := p.GetPosition()
-	targetPos := target.GetPosition()
+	targetPos := s.rewoundPosition(p, target)
 	distance := s.calculateDistance(protocol.Vector3f{
 		X: targetPos.X - pos.X,
 		Y: targetPos.Y - pos.Y,
@@ -1539,11 +1794,20 @@ func (s *Server) handleHit(p *player.Player, data []byte) {
 			return
 		}
 	} else {
+		// the aim cone is only checked against rewound positions
+		if s.config.AntiCheat.LagCompensation && !physics.ValidateHit(pos, targetPos, p.GetOrientation(), hitTolerance) {
+			s.logger.Debug("hit outside aim cone",
+				"player", p.GetName(),
+				"target", target.GetName())
+			return
+		}
 		if !s.validateWeaponRange(p, target.GetName(), weapon, pos, targetPos, distance) {
 			return
 		}
 	}
 
+	s.recordAimHit(p, target, weapon, packet.HitType)
+
 	damage := physics.CalculateDamage(weapon, packet.HitType, distance)
 
 	target, ok = s.gameState.Players.Get(packet.PlayerID)
@@ -1632,6 +1896,10 @@ func (s *Server) handleExistingPlayer(p *player.Player, data []byte) {
 		return
 	}
 
+	if !s.admitSlot(p, data) {
+		return
+	}
+
 	playerID := data[1]
 	teamRaw := data[2]
 	team, ok := toInternalTeamID(teamRaw)
@@ -1670,7 +1938,7 @@ func (s *Server) handleExistingPlayer(p *player.Player, data []byte) {
 	_ = kills
 
 	if banned, ban := s.banManager.IsBannedByName(name); banned {
-		s.logger.Info("banned player attempted to join", "name", name, "reason", ban.Reason)
+		s.logger.Info("banned player attempted to join", "name", name, "reason", ban.Reason, "source", ban.Source)
 		p.Peer.DisconnectNow(uint32(protocol.DisconnectReasonBanned))
 		return
 	}
@@ -1687,7 +1955,9 @@ func (s *Server) handleExistingPlayer(p *player.Player, data []byte) {
 	p.Unlock()
 
 	s.logger.Info("player joined", "player", p.ID, "name", name, "team", team)
+	s.restoreLedger(p)
 	s.finalizePlayerJoin(p)
+	s.checkReservedName(p)
 }
 
 func (s *Server) handleBlockAction(p *player.Player, data []byte) {
@@ -1715,11 +1985,19 @@ func (s *Server) handleBlockAction(p *player.Player, data []byte) {
 		return
 	}
 
+	if !s.canModifyBlock(p, x, y) {
+		return
+	}
+
 	if packet.Action == protocol.BlockActionTypeBuild {
 		if z >= s.gameState.Map.Depth()-2 {
 			return
 		}
 
+		if s.isBuildForbidden(x, y) {
+			return
+		}
+
 		if !s.gameState.Map.HasNeighbors(x, y, z) {
 			return
 		}
@@ -1734,6 +2012,7 @@ func (s *Server) handleBlockAction(p *player.Player, data []byte) {
 		if p.BlockPlaceQuota <= 0 {
 			p.Unlock()
 			s.logger.Warn("block place rate limit exceeded", "player", p.GetName())
+			s.recordViolation(p, anticheat.CategoryBlockQuota, "block placing")
 			return
 		}
 
@@ -1748,6 +2027,7 @@ func (s *Server) handleBlockAction(p *player.Player, data []byte) {
 
 			color := uint32(colorRGB.R)<<16 | uint32(colorRGB.G)<<8 | uint32(colorRGB.B)
 			s.gameState.Map.Set(x, y, z, color)
+			delete(s.blockDamage, blockKey{x, y, z})
 
 			packet.PlayerID = p.ID
 			s.broadcastPacket(&packet, true)
@@ -1763,12 +2043,17 @@ func (s *Server) handleBlockAction(p *player.Player, data []byte) {
 		if p.BlockDestroyQuota <= 0 {
 			p.Unlock()
 			s.logger.Warn("block destroy rate limit exceeded", "player", p.GetName())
+			s.recordViolation(p, anticheat.CategoryBlockQuota, "block destroying")
 			return
 		}
 
 		p.BlockDestroyQuota--
 		p.Unlock()
 
+		if !s.plausibleBlockDestroy(p, packet.Action, x, y, z) {
+			return
+		}
+
 		if s.gameState.Map.IsSolid(x, y, z) {
 			if packet.Action == protocol.BlockActionTypeSpadeGunDestroy {
 				p.Lock()
@@ -1795,9 +2080,79 @@ func (s *Server) handleBlockAction(p *player.Player, data []byte) {
 			}
 		}
 
-		s.gameState.Map.SetAir(x, y, z)
+		removed := [][3]int{{x, y, z}}
+		if packet.Action == protocol.BlockActionTypeSpadeSecondaryDestroy {
+			removed = append(removed, [3]int{x, y, z - 1}, [3]int{x, y, z + 1})
+		}
+		for _, b := range removed {
+			s.gameState.Map.SetAir(b[0], b[1], b[2])
+		}
 		packet.PlayerID = p.ID
 		s.broadcastPacket(&packet, true)
+
+		s.collapseFloatingBlocks(p.ID, removed)
+	}
+}
+
+// canModifyBlock reports whether p may build or destroy at the given column,
+// map protected sectors are off limits to everyone below admin
+func (s *Server) canModifyBlock(p *player.Player, x, y int) bool {
+	if !s.gameState.IsProtected(x, y) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+
+	p.RLock()
+	perms := p.Permissions
+	p.RUnlock()
+	return perms&(uint64(1<<4)|uint64(1<<5)) != 0
+}
+
+// isBuildForbidden covers gamemode specific no-build zones such as the
+// murderball penalty areas
+func (s *Server) isBuildForbidden(x, y int) bool {
+	return s.gameState.Gamemode == config.GamemodeMurderball && s.gameState.InPenaltyArea(x, y)
+}
+
+func (s *Server) IsProtected(x, y int) bool {
+	return s.gameState.IsProtected(x, y)
+}
+
+func (s *Server) SetSectorProtected(sector string, protected bool) error {
+	if err := s.gameState.SetSectorProtected(sector, protected); err != nil {
+		return err
+	}
+	s.logger.Info("sector protection changed", "sector", sector, "protected", protected)
+	return nil
+}
+
+func (s *Server) GetProtectedSectors() []string {
+	return s.gameState.ProtectedSectors()
+}
+
+func (s *Server) collapseFloatingBlocks(playerID uint8, removed [][3]int) {
+	collapsed := 0
+	for _, r := range removed {
+		for _, b := range s.gameState.Map.FloatingBlocks(r[0], r[1], r[2]) {
+			s.gameState.Map.SetAir(b[0], b[1], b[2])
+
+			blockPacket := protocol.PacketBlockAction{
+				PacketID: uint8(protocol.PacketTypeBlockAction),
+				PlayerID: playerID,
+				Action:   protocol.BlockActionTypeSpadeGunDestroy,
+				X:        int32(b[0]),
+				Y:        int32(b[1]),
+				Z:        int32(b[2]),
+			}
+			s.broadcastPacket(&blockPacket, true)
+			collapsed++
+		}
+	}
+
+	if collapsed > 0 {
+		s.logger.Debug("floating blocks collapsed", "player", playerID, "blocks", collapsed)
 	}
 }
 
@@ -1841,6 +2196,17 @@ func (s *Server) handleBlockLine(p *player.Player, data []byte) {
 		return
 	}
 
+	for i := 0; i <= maxLen; i++ {
+		x, y := x1, y1
+		if maxLen > 0 {
+			x = x1 + (x2-x1)*i/maxLen
+			y = y1 + (y2-y1)*i/maxLen
+		}
+		if !s.canModifyBlock(p, x, y) || s.isBuildForbidden(x, y) {
+			return
+		}
+	}
+
 	p.Lock()
 	if p.Blocks < uint8(blocksNeeded) {
 		p.Unlock()
@@ -1984,13 +2350,7 @@ func (s *Server) handleChatMessage(p *player.Player, data []byte) {
 		playerTeam := p.GetTeam()
 		s.gameState.Players.ForEach(func(target *player.Player) {
 			if target.GetTeam() == playerTeam && target.GetState() == player.PlayerStateReady {
-				target.RLock()
-				peer := target.Peer
-				target.RUnlock()
-				data, err := marshalPacket(&packet)
-				if err == nil {
-					s.network.SendPacket(peer, data, true)
-				}
+				s.sendPacket(target, &packet, true)
 			}
 		})
 	} else {
@@ -2119,10 +2479,15 @@ func (s *Server) handleExtensionInfo(p *player.Player, data []byte) {
 	for _, entry := range packet.Entries {
 		p.AddExtension(entry.ExtensionID, entry.ExtensionVersion)
 	}
+	p.Lock()
+	p.ExtensionsReceived = true
+	p.Unlock()
 
 	s.logger.Info("client extensions registered",
 		"player", p.Name,
 		"count", len(packet.Entries))
+
+	s.resumePendingJoin(p)
 }
 
 func (s *Server) sendExtensionInfo(p *player.Player) {
@@ -2153,71 +2518,6 @@ func (s *Server) sendExtensionInfo(p *player.Player) {
 	s.logger.Debug("sent extension info", "player", p.ID, "extensions", len(packet.Entries))
 }
 
-func (s *Server) sendMapData(p *player.Player) error {
-	s.logger.Debug("writing map data", "player", p.ID)
-	mapData, err := s.gameState.Map.Write()
-	if err != nil {
-		return fmt.Errorf("failed to write map: %w", err)
-	}
-	s.logger.Debug("map data written", "player", p.ID, "size", len(mapData))
-
-	var compressed bytes.Buffer
-	zw := zlib.NewWriter(&compressed)
-	if _, err := zw.Write(mapData); err != nil {
-		return fmt.Errorf("failed to compress map data: %w", err)
-	}
-	if err := zw.Close(); err != nil {
-		return fmt.Errorf("failed to finalize map compression: %w", err)
-	}
-	s.logger.Debug("map data compressed", "player", p.ID, "compressed_size", compressed.Len())
-
-	data := compressed.Bytes()
-
-	startPacket := protocol.PacketMapStart{
-		PacketID: uint8(protocol.PacketTypeMapStart),
-		MapSize:  uint32(len(data)),
-	}
-	s.logger.Info("sending map start", "player", p.ID, "size", len(data))
-	s.sendPacket(p, &startPacket, true)
-
-	chunkSize := 8192
-	numChunks := (len(data) + chunkSize - 1) / chunkSize
-	s.logger.Debug("sending map chunks", "player", p.ID, "num_chunks", numChunks)
-
-	timeout := time.After(60 * time.Second)
-
-	for i := 0; i < len(data); i += chunkSize {
-		select {
-		case <-timeout:
-			return fmt.Errorf("map send timeout for player %d", p.ID)
-		default:
-		}
-
-		if p.GetState() == player.PlayerStateDisconnected {
-			return fmt.Errorf("player %d disconnected during map send", p.ID)
-		}
-
-		end := i + chunkSize
-		if end > len(data) {
-			end = len(data)
-		}
-
-		chunkPacket := protocol.PacketMapChunk{
-			PacketID: uint8(protocol.PacketTypeMapChunk),
-			Data:     data[i:end],
-		}
-
-		s.sendPacket(p, &chunkPacket, true)
-
-		if i%10 == 0 {
-			time.Sleep(time.Millisecond)
-		}
-	}
-	s.logger.Debug("finished sending map chunks", "player", p.ID)
-
-	return nil
-}
-
 func (s *Server) sendStateDataPacket(p *player.Player) {
 	s.logger.Info("preparing state data", "player", p.ID)
 	stateData := s.gameState.GetStateData(p.ID)
@@ -2246,41 +2546,41 @@ func (s *Server) sendIntelPositions(p *player.Player) {
 
 func (s *Server) sendInitialPackets(p *player.Player) error {
 	s.logger.Info("sending map/state", "player", p.ID)
-	if err := s.sendMapData(p); err != nil {
-		return err
-	}
-	s.logger.Debug("map data sent successfully", "player", p.ID)
-
-	s.sendStateDataPacket(p)
-	s.sendIntelPositions(p)
-	s.logger.Debug("calling sendExistingPlayersData", "player", p.ID)
-	s.sendExistingPlayersData(p)
+	return s.sendMapData(p, func() {
+		s.logger.Debug("map data sent successfully", "player", p.ID)
 
-	s.sendVersionRequest(p)
+		s.sendStateDataPacket(p)
+		s.sendIntelPositions(p)
+		s.logger.Debug("calling sendExistingPlayersData", "player", p.ID)
+		s.sendExistingPlayersData(p)
 
-	p.Lock()
-	p.State = player.PlayerStateWaitingForExistingPlayer
-	p.Unlock()
-	s.logger.Info("player waiting for ExistingPlayer packet", "player", p.ID)
+		// the version and extension handshake only exists for 0.75
+		if p.ProtocolVersion == protocol.ProtocolVersion75 {
+			s.sendVersionRequest(p)
+		}
 
-	return nil
+		p.Lock()
+		p.State = player.PlayerStateWaitingForExistingPlayer
+		p.Unlock()
+		s.logger.Info("player waiting for ExistingPlayer packet", "player", p.ID)
+	})
 }
 
 func (s *Server) sendMapStart(p *player.Player) {
 	s.logger.Info("sending map for reconnect/map change", "player", p.ID)
-	if err := s.sendMapData(p); err != nil {
-		s.logger.Error("failed to send map data", "error", err)
-		return
-	}
+	err := s.sendMapData(p, func() {
+		s.sendStateDataPacket(p)
+		s.sendIntelPositions(p)
 
-	s.sendStateDataPacket(p)
-	s.sendIntelPositions(p)
-
-	p.Lock()
-	p.State = player.PlayerStateReady
-	p.Unlock()
+		p.Lock()
+		p.State = player.PlayerStateReady
+		p.Unlock()
 
-	s.finalizePlayerJoin(p)
+		s.finalizePlayerJoin(p)
+	})
+	if err != nil {
+		s.logger.Error("failed to send map data", "error", err)
+	}
 }
 
 func (s *Server) sendExistingPlayersData(p *player.Player) {
@@ -2289,24 +2589,28 @@ func (s *Server) sendExistingPlayersData(p *player.Player) {
 			return
 		}
 
-		other.RLock()
-		packet := protocol.PacketExistingPlayer{
-			PacketID: uint8(protocol.PacketTypeExistingPlayer),
-			PlayerID: other.ID,
-			Team:     toNetworkTeamID(other.Team),
-			Weapon:   other.Weapon,
-			Item:     other.Tool,
-			Kills:    other.Kills,
-			Color:    other.Color,
-		}
-		copy(packet.Name[:], other.Name)
-
-		other.RUnlock()
-
+		packet := existingPlayerPacket(other)
 		s.sendPacket(p, &packet, true)
 	})
 }
 
+func existingPlayerPacket(p *player.Player) protocol.PacketExistingPlayer {
+	p.RLock()
+	defer p.RUnlock()
+
+	packet := protocol.PacketExistingPlayer{
+		PacketID: uint8(protocol.PacketTypeExistingPlayer),
+		PlayerID: p.ID,
+		Team:     toNetworkTeamID(p.Team),
+		Weapon:   p.Weapon,
+		Item:     p.Tool,
+		Kills:    p.Kills,
+		Color:    p.Color,
+	}
+	copy(packet.Name[:], p.Name)
+	return packet
+}
+
 func (s *Server) finalizePlayerJoin(p *player.Player) {
 	p.Lock()
 	p.HasIntel = false
@@ -2632,7 +2936,7 @@ func (s *Server) changePlayerTeam(p *player.Player, team uint8) {
 
 func (s *Server) getCarrierIntelSlot(carrierTeam uint8) uint8 {
 	gm, _ := config.ParseGamemode(s.config.Server.Gamemode)
-	if gm == config.GamemodeBabel {
+	if gm.SingleIntel() {
 		return 0
 	}
 	return 1 - carrierTeam
@@ -2649,6 +2953,14 @@ func (s *Server) broadcastIntelDrop(playerID uint8, team uint8, position protoco
 	}
 	s.broadcastPacket(&packet, true)
 	s.broadcastMoveObject(team, position)
+
+	gm, _ := config.ParseGamemode(s.config.Server.Gamemode)
+	if !gm.TracksIntelDrops() {
+		return
+	}
+	if p, ok := s.gameState.Players.Get(playerID); ok {
+		s.callbacks.OnIntelDrop(p, team)
+	}
 }
 
 func (s *Server) BroadcastTerritoryCapture(playerID, entityID, winning, state uint8) {
@@ -2686,9 +2998,12 @@ func (s *Server) sendWeaponReload(p *player.Player) {
 }
 
 func (s *Server) sendWorldUpdate() {
-	worldUpdate := protocol.PacketWorldUpdate{
-		PacketID: uint8(protocol.PacketTypeWorldUpdate),
+	type worldEntry struct {
+		player *player.Player
+		data   protocol.PlayerPositionData
 	}
+	var entries []worldEntry
+	var inGame []*player.Player
 
 	s.gameState.Players.ForEach(func(p *player.Player) {
 		if p.GetState() == player.PlayerStateReady && p.GetTeam() <= 1 {
@@ -2697,22 +3012,85 @@ func (s *Server) sendWorldUpdate() {
 			p.RUnlock()
 			ori := p.GetOrientation()
 
-			worldUpdate.Players[p.ID] = protocol.PlayerPositionData{
-				X:  pos.X,
-				Y:  pos.Y,
-				Z:  pos.Z,
-				OX: ori.X,
-				OY: ori.Y,
-				OZ: ori.Z,
-			}
+			entries = append(entries, worldEntry{
+				player: p,
+				data: protocol.PlayerPositionData{
+					X:  pos.X,
+					Y:  pos.Y,
+					Z:  pos.Z,
+					OX: ori.X,
+					OY: ori.Y,
+					OZ: ori.Z,
+				},
+			})
+			inGame = append(inGame, p)
 		}
 	})
 
+	culling := s.espCulling()
+	now := time.Now()
+	if s.trackVisibility() {
+		s.updateVisibility(inGame, now)
+	}
+
+	// build fills both layouts for viewer, or unculled for a nil viewer.
+	// Clients with the 256 players extension get the same positions keyed by
+	// player id, which also reaches the slots past 32
+	build := func(viewer *player.Player) (protocol.PacketWorldUpdate, protocol.PacketWorldUpdateExtended) {
+		worldUpdate := protocol.PacketWorldUpdate{
+			PacketID: uint8(protocol.PacketTypeWorldUpdate),
+		}
+		extended := protocol.PacketWorldUpdateExtended{
+			PacketID: uint8(protocol.PacketTypeWorldUpdate),
+		}
+		for _, e := range entries {
+			data := e.data
+			if viewer != nil && !s.canSee(viewer, e.player, now) {
+				data = hiddenPosition
+			}
+			if e.player.ID < protocol.MaxPlayers {
+				worldUpdate.Players[e.player.ID] = data
+			}
+			extended.Players = append(extended.Players, protocol.WorldUpdateEntry{
+				PlayerID:           e.player.ID,
+				PlayerPositionData: data,
+			})
+		}
+		return worldUpdate, extended
+	}
+
+	worldUpdate, extended := build(nil)
+
+	// demos and the relay see everything, record the update once rather
+	// than per send
+	if data, err := marshalPacket(&worldUpdate); err == nil {
+		s.recordPacket(data)
+	}
+
 	s.gameState.Players.ForEach(func(p *player.Player) {
 		if p.GetState() != player.PlayerStateReady {
 			return
 		}
-		s.sendPacket(p, &worldUpdate, false)
+
+		legacy, ext := worldUpdate, extended
+		if culling && p.GetTeam() <= 1 {
+			legacy, ext = build(p)
+		}
+		switch {
+		case extendedSlots(p):
+			s.sendPacket(p, &ext, false)
+		case p.ProtocolVersion == protocol.ProtocolVersion76:
+			// 0.76 keys entries by id too, but only knows the first 32
+			update := protocol.PacketWorldUpdateExtended{PacketID: ext.PacketID}
+			for _, entry := range ext.Players {
+				if entry.PlayerID < protocol.MaxPlayers {
+					update.Players = append(update.Players, entry)
+				}
+			}
+			s.sendPacket(p, &update, false)
+		default:
+			s.sendPacket(p, &legacy, false)
+		}
 	})
 }
 
@@ -2749,6 +3127,7 @@ func (s *Server) explodeGrenade(grenade *gamestate.Grenade) {
 		return
 	}
 
+	thrower, _ := s.gameState.Players.Get(grenade.PlayerID)
 	var destroyedBlocks []protocol.Vector3i
 
 	for dx := -1; dx <= 1; dx++ {
@@ -2758,6 +3137,9 @@ func (s *Server) explodeGrenade(grenade *gamestate.Grenade) {
 				if bz >= 62 {
 					continue
 				}
+				if !s.canModifyBlock(thrower, bx, by) {
+					continue
+				}
 				if s.gameState.Map.IsInside(bx, by, bz) && s.gameState.Map.IsSolid(bx, by, bz) {
 					destroyedBlocks = append(destroyedBlocks, protocol.Vector3i{
 						X: int32(bx),
@@ -2770,7 +3152,7 @@ func (s *Server) explodeGrenade(grenade *gamestate.Grenade) {
 	}
 
 	var throwerTeam uint8 = 255
-	if thrower, ok := s.gameState.Players.Get(grenade.PlayerID); ok {
+	if thrower != nil {
 		throwerTeam = thrower.Team
 	}
 
@@ -2821,12 +3203,14 @@ func (s *Server) explodeGrenade(grenade *gamestate.Grenade) {
 		}
 	})
 
+	removed := make([][3]int, 0, len(destroyedBlocks))
 	for _, block := range destroyedBlocks {
 		bx := int(block.X)
 		by := int(block.Y)
 		bz := int(block.Z)
 
 		s.gameState.Map.SetAir(bx, by, bz)
+		removed = append(removed, [3]int{bx, by, bz})
 
 		blockPacket := protocol.PacketBlockAction{
 			PacketID: uint8(protocol.PacketTypeBlockAction),
@@ -2838,6 +3222,7 @@ func (s *Server) explodeGrenade(grenade *gamestate.Grenade) {
 		}
 		s.broadcastPacket(&blockPacket, true)
 	}
+	s.collapseFloatingBlocks(grenade.PlayerID, removed)
 
 	if thrower, ok := s.gameState.Players.Get(grenade.PlayerID); ok {
 		s.callbacks.OnGrenadeExplode(thrower, grenade.Position.X, grenade.Position.Y, grenade.Position.Z)
@@ -2862,12 +3247,20 @@ func marshalPacket(packet interface{}) ([]byte, error) {
 }
 
 func (s *Server) sendPacket(p *player.Player, packet interface{}, reliable bool) {
+	if p.Peer == nil {
+		return
+	}
+
 	data, err := marshalPacket(packet)
 	if err != nil {
 		s.logger.Error("failed to encode packet", "error", err)
 		return
 	}
 
+	if refersToHighSlot(data) && !extendedSlots(p) {
+		return
+	}
+
 	if len(data) > 0 {
 		level := slog.LevelDebug
 		if data[0] == uint8(protocol.PacketTypeHandShakeInit) || data[0] == uint8(protocol.PacketTypeMapStart) || data[0] == uint8(protocol.PacketTypeStateData) {
@@ -2883,6 +3276,10 @@ func (s *Server) sendPacket(p *player.Player, packet interface{}, reliable bool)
 	if err := s.network.SendPacket(p.Peer, data, reliable); err != nil {
 		s.logger.Error("failed to send packet", "error", err)
 	}
+
+	if !isPrivatePacket(data) {
+		s.recordPacket(data)
+	}
 }
 
 func (s *Server) broadcastPacket(packet interface{}, reliable bool) {
@@ -2891,6 +3288,22 @@ func (s *Server) broadcastPacket(packet interface{}, reliable bool) {
 		s.logger.Error("failed to encode packet", "error", err)
 		return
 	}
+	s.recordPacket(data)
+
+	if refersToHighSlot(data) {
+		s.gameState.Players.ForEach(func(p *player.Player) {
+			p.RLock()
+			peer := p.Peer
+			p.RUnlock()
+			if peer == nil || !extendedSlots(p) {
+				return
+			}
+			if err := s.network.SendPacket(peer, data, reliable); err != nil {
+				s.logger.Error("failed to send packet", "player", p.ID, "error", err)
+			}
+		})
+		return
+	}
 
 	if err := s.network.Broadcast(data, reliable); err != nil {
 		s.logger.Error("failed to broadcast packet", "error", err)
@@ -2903,12 +3316,17 @@ func (s *Server) broadcastPacketExcept(packet interface{}, exceptID uint8, relia
 		s.logger.Error("failed to encode packet", "error", err)
 		return
 	}
+	s.recordPacket(data)
+	highSlot := refersToHighSlot(data)
 
 	s.gameState.Players.ForEach(func(p *player.Player) {
 		if p.ID != exceptID && p.GetState() == player.PlayerStateReady {
 			p.RLock()
 			peer := p.Peer
 			p.RUnlock()
+			if peer == nil || (highSlot && !extendedSlots(p)) {
+				return
+			}
 			if err := s.network.SendPacket(peer, data, reliable); err != nil {
 				s.logger.Error("failed to send packet", "player", p.ID, "error", err)
 			}
@@ -2952,7 +3370,7 @@ func (s *Server) broadcastShortPlayerData(p *player.Player) {
 }
 
 func (s *Server) broadcastMoveObject(team uint8, position protocol.Vector3f) {
-	if !s.running || !s.intelEnabled() {
+	if !s.running.Load() || !s.intelEnabled() {
 		return
 	}
 	packet := protocol.PacketMoveObject{
@@ -2969,7 +3387,7 @@ func (s *Server) broadcastMoveObject(team uint8, position protocol.Vector3f) {
 // sends a position/orientation packet for a player without updating server state
 // if player id is 255, broadcasts to all players
 func (s *Server) SendPlayerPositionPacketTo(playerID uint8, pos, ori protocol.Vector3f, toPlayerID uint8) {
-	if !s.running {
+	if !s.running.Load() {
 		return
 	}
 
@@ -2987,13 +3405,14 @@ func (s *Server) SendPlayerPositionPacketTo(playerID uint8, pos, ori protocol.Ve
 
 	if toPlayerID == 255 {
 		// broadcast to all players
+		s.recordPacket(data)
 		if err := s.network.Broadcast(data, false); err != nil {
 			s.logger.Error("failed to broadcast position packet", "error", err)
 		}
 	} else {
 		// send to specific player
 		targetPlayer, ok := s.gameState.Players.Get(toPlayerID)
-		if ok && targetPlayer.GetState() == player.PlayerStateReady {
+		if ok && targetPlayer.GetState() == player.PlayerStateReady && targetPlayer.Peer != nil {
 			if err := s.network.SendPacket(targetPlayer.Peer, data, false); err != nil {
 				s.logger.Error("failed to send position packet", "player", toPlayerID, "error", err)
 			}
@@ -3002,7 +3421,7 @@ func (s *Server) SendPlayerPositionPacketTo(playerID uint8, pos, ori protocol.Ve
 }
 
 func (s *Server) SendIntelPositionPacketOnly(objectID uint8, team uint8, position protocol.Vector3f) {
-	if !s.running {
+	if !s.running.Load() {
 		return
 	}
 
@@ -3018,7 +3437,7 @@ func (s *Server) SendIntelPositionPacketOnly(objectID uint8, team uint8, positio
 }
 
 func (s *Server) syncIntelPositions() {
-	if !s.running || !s.intelEnabled() {
+	if !s.running.Load() || !s.intelEnabled() {
 		return
 	}
 	for team := uint8(0); team < 2; team++ {
@@ -3139,8 +3558,8 @@ func (s *Server) checkIntelPickup(p *player.Player) {
 	gm, _ := config.ParseGamemode(s.config.Server.Gamemode)
 
 	var intelTeam uint8
-	if gm == config.GamemodeBabel {
-		// in babel, check the center flag (team 0)
+	if gm.SingleIntel() {
+		// in babel and murderball, check the shared flag (team 0)
 		intelTeam = 0
 	} else {
 		// in ctf/tdm check opposite team's flag
@@ -3221,6 +3640,23 @@ func (s *Server) checkBabelIntelCapture(p *player.Player, pos protocol.Vector3f)
 	return false
 }
 
+func (s *Server) checkMurderballGoal(p *player.Player, pos protocol.Vector3f) {
+	inside, defined := s.gameState.InGoal(p.Team, pos)
+	if !defined {
+		// no goal volumes in the map metadata, score at the base like ctf
+		inside = s.isNearBase(pos, p.Team)
+	}
+	if !inside {
+		return
+	}
+
+	if s.gameMode.OnIntelCapture(p, p.Team) {
+		if s.gameState.CaptureIntel(p.ID, p.Team) {
+			s.handleCaptureSuccess(p)
+		}
+	}
+}
+
 func (s *Server) checkCTFIntelCapture(p *player.Player, pos protocol.Vector3f) {
 	if !s.isNearBase(pos, p.Team) {
 		return
@@ -3250,6 +3686,8 @@ func (s *Server) checkIntelCapture(p *player.Player) {
 
 	if gm == config.GamemodeBabel {
 		s.checkBabelIntelCapture(p, pos)
+	} else if gm == config.GamemodeMurderball {
+		s.checkMurderballGoal(p, pos)
 	} else {
 		s.checkCTFIntelCapture(p, pos)
 	}
@@ -3406,6 +3844,7 @@ func (s *Server) getTeamName(team uint8) string {
 
 func (s *Server) changeMap(mapName string) error {
 	s.logger.Info("changing map", "spec", mapName)
+	s.audit("map_change", "", mapName)
 
 	s.gameState.ClearGrenades()
 
@@ -3419,10 +3858,24 @@ func (s *Server) changeMap(mapName string) error {
 		s.gameState.Players.Add(p)
 	}
 
+	s.rotateDemo()
+	s.feedRelaySnapshot()
+
 	displayName := s.GetCurrentMapName()
 	reportName := s.getReportedMapName()
 
 	s.gameState.Players.ForEach(func(p *player.Player) {
+		if p.Bot {
+			// bots have no map to load, bring them back once clients caught up
+			p.Lock()
+			p.Alive = false
+			p.HasIntel = false
+			p.State = player.PlayerStateDead
+			p.RespawnTime = time.Now().Add(time.Duration(s.config.Server.RespawnTime) * time.Second)
+			p.Unlock()
+			return
+		}
+
 		if p.GetState() == player.PlayerStateReady {
 			p.Lock()
 			p.State = player.PlayerStateLoading
@@ -3441,7 +3894,7 @@ func (s *Server) changeMap(mapName string) error {
 
 	s.logger.Info("map changed", "spec", mapName, "display", displayName)
 
-	if s.running {
+	if s.running.Load() {
 		s.syncIntelPositions()
 	}
 
@@ -3480,7 +3933,7 @@ func (s *Server) startPeriodicAnnouncements() {
 			return
 
 		case <-ticker.C:
-			if !s.running {
+			if !s.running.Load() {
 				return
 			}
 
@@ -3503,7 +3956,13 @@ func (s *Server) StartVotekick(instigator *player.Player, victimID uint8, reason
 		BanDuration: 30 * time.Minute,
 		PublicVotes: true,
 		OnSuccess: func(p *player.Player, reason string, duration time.Duration) {
-			ip := p.Peer.GetAddress().String()
+			// bots have no address to ban, voting one out just removes it
+			if p.Bot {
+				s.dropBot(p)
+				return
+			}
+
+			ip := p.Peer.Address()
 			err := s.banManager.AddBan(ip, p.Name, reason, instigator.Name, duration)
 			if err != nil {
 				s.logger.Error("failed to ban player", "error", err)
@@ -0,0 +1,1018 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/accounts"
+	"github.com/siohaza/fosilo/internal/bans"
+	"github.com/siohaza/fosilo/internal/demo"
+	"github.com/siohaza/fosilo/internal/gamestate"
+	"github.com/siohaza/fosilo/internal/network"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+	"github.com/siohaza/fosilo/pkg/client"
+	"github.com/siohaza/fosilo/pkg/config"
+)
+
+func startLoopbackServer(t *testing.T, configure ...func(*config.Config)) (*Server, *network.Loopback) {
+	t.Helper()
+
+	srv, transport := newLoopbackServer(t, configure...)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+
+	return srv, transport
+}
+
+// newLoopbackServer creates a server without starting it, for tests that
+// swap parts of it first
+func newLoopbackServer(t *testing.T, configure ...func(*config.Config)) (*Server, *network.Loopback) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cfg, err := config.LoadConfig("config/config-ctf.toml")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	cfg.Server.Maps = []string{"classicgen"}
+	cfg.Server.Master = false
+	cfg.Server.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	transport := network.NewLoopback()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv, err := NewWithTransport(cfg, transport, logger)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	return srv, transport
+}
+
+func dialLoopback(t *testing.T, transport *network.Loopback, opts client.Options) *client.Client {
+	t.Helper()
+
+	conn, err := transport.Dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(conn, opts)
+	t.Cleanup(c.Disconnect)
+
+	if err := c.WaitForMap(10 * time.Second); err != nil {
+		t.Fatalf("waiting for map: %v", err)
+	}
+	return c
+}
+
+func TestLoopbackJoin(t *testing.T) {
+	srv, transport := startLoopbackServer(t)
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+
+	if _, ok := c.ServerExtension(protocol.ExtensionIDPlayerProperties); !ok {
+		t.Error("server did not advertise the player properties extension")
+	}
+
+	c.Disconnect()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.gameState.Players.Count() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("player was not removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoopbackChatAndBuild(t *testing.T) {
+	_, transport := startLoopbackServer(t)
+
+	builder := dialLoopback(t, transport, client.Options{Name: "builder", Team: 0})
+	watcher := dialLoopback(t, transport, client.Options{Name: "watcher", Team: 1})
+
+	for _, c := range []*client.Client{builder, watcher} {
+		if err := c.Join(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitForSpawn(5 * time.Second); err != nil {
+			t.Fatalf("waiting for spawn: %v", err)
+		}
+	}
+
+	var heard string
+	watcher.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		if playerID == builder.PlayerID() {
+			heard = message
+		}
+	})
+	if err := builder.Chat("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.WaitFor(func() bool { return heard != "" }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for chat: %v", err)
+	}
+	if heard != "hello" {
+		t.Fatalf("got chat %q, want %q", heard, "hello")
+	}
+
+	pos := builder.Position()
+	x, y := int(pos.X), int(pos.Y)
+	z := builder.Map().FindTopBlock(x, y) - 1
+	if err := builder.Build(x, y, z); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.WaitFor(func() bool { return watcher.Map().IsSolid(x, y, z) }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for block at %d,%d,%d: %v", x, y, z, err)
+	}
+}
+
+func TestBotsMakeRoomForHumans(t *testing.T) {
+	srv, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.Bots.Enabled = true
+		cfg.Bots.TargetPlayers = 2
+	})
+
+	waitForPlayers := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for srv.gameState.Players.Count() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d players, want %d", srv.gameState.Players.Count(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForPlayers(2)
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+
+	waitForPlayers(2)
+	countBots := func() int {
+		bots := 0
+		for _, p := range c.Players() {
+			if strings.HasPrefix(p.Name, "Bot") {
+				bots++
+			}
+		}
+		return bots
+	}
+	if err := c.WaitFor(func() bool { return countBots() == 1 }, 5*time.Second); err != nil {
+		t.Errorf("client sees %d bots, want 1", countBots())
+	}
+}
+
+func TestDemoRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	srv, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.Demo.Directory = dir
+	})
+
+	path, err := srv.StartDemo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+	echoed := false
+	c.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		echoed = echoed || message == "recorded"
+	})
+	if err := c.Chat("recorded"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitFor(func() bool { return echoed }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for chat: %v", err)
+	}
+
+	if _, err := srv.StopDemo(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	r, err := demo.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[protocol.PacketType]int)
+	for {
+		packet, err := r.Next()
+		if err != nil {
+			break
+		}
+		seen[protocol.PacketType(packet.Data[0])]++
+		if protocol.PacketType(packet.Data[0]) == protocol.PacketTypeStateData && packet.Data[1] == c.PlayerID() {
+			t.Errorf("demo viewer shares id %d with a player", c.PlayerID())
+		}
+	}
+
+	for _, packetType := range []protocol.PacketType{
+		protocol.PacketTypeMapStart,
+		protocol.PacketTypeMapChunk,
+		protocol.PacketTypeStateData,
+		protocol.PacketTypeCreatePlayer,
+		protocol.PacketTypeChatMessage,
+		protocol.PacketTypeWorldUpdate,
+	} {
+		if seen[packetType] == 0 {
+			t.Errorf("demo has no packets of type %d", packetType)
+		}
+	}
+	if seen[protocol.PacketTypeMapStart] != 1 {
+		t.Errorf("expected only the snapshot map, got %d map starts", seen[protocol.PacketTypeMapStart])
+	}
+
+	file.Seek(0, io.SeekStart)
+	transport = network.NewLoopback()
+	// without an admin or manager password nobody may steer playback
+	cfg := *srv.config
+	cfg.Passwords.Admin = ""
+	cfg.Passwords.Manager = ""
+	replay, err := NewReplayWithTransport(&cfg, file, transport, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(replay.Stop)
+
+	viewer := dialLoopback(t, transport, client.Options{Name: "viewer", Team: 0})
+	if err := viewer.Join(); err != nil {
+		t.Fatal(err)
+	}
+
+	var replies []string
+	viewer.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		replies = append(replies, message)
+	})
+	if err := viewer.WaitFor(func() bool {
+		for _, p := range viewer.Players() {
+			if p.Name == "Deuce" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second); err != nil {
+		t.Fatalf("waiting for the recorded player: %v", err)
+	}
+
+	if err := viewer.Chat("/status"); err != nil {
+		t.Fatal(err)
+	}
+	if err := viewer.WaitFor(func() bool { return len(replies) > 0 }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for status reply: %v", err)
+	}
+
+	if err := viewer.Chat("/pause"); err != nil {
+		t.Fatal(err)
+	}
+	refused := func() bool {
+		for _, reply := range replies {
+			if strings.Contains(reply, "control is off") {
+				return true
+			}
+		}
+		return false
+	}
+	if err := viewer.WaitFor(refused, 5*time.Second); err != nil {
+		t.Fatalf("pause without a password was not refused, replies %q: %v", replies, err)
+	}
+}
+
+func TestRelayDelaysMatch(t *testing.T) {
+	srv, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.Relay.Delay = 1
+	})
+
+	relayTransport := network.NewLoopback()
+	if err := srv.StartRelay(relayTransport); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+
+	viewer := dialLoopback(t, relayTransport, client.Options{Name: "viewer", Team: 0})
+	if err := viewer.Join(); err != nil {
+		t.Fatal(err)
+	}
+
+	var relayed time.Time
+	viewer.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		if message == "live" {
+			relayed = time.Now()
+		}
+	})
+
+	sent := time.Now()
+	if err := c.Chat("live"); err != nil {
+		t.Fatal(err)
+	}
+	if err := viewer.WaitFor(func() bool { return !relayed.IsZero() }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for relayed chat: %v", err)
+	}
+	if lag := relayed.Sub(sent); lag < 900*time.Millisecond {
+		t.Errorf("chat reached the relay after %v, want about a second", lag)
+	}
+
+	if players := len(srv.gameState.Players.GetAll()); players != 1 {
+		t.Errorf("relay viewer joined the match, server has %d players", players)
+	}
+}
+
+func TestExtendedSlotsNeed256Players(t *testing.T) {
+	srv, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.Server.MaxPlayers = protocol.MaxPlayers + 2
+	})
+
+	// hold the legacy range with players that never finish joining
+	for id := uint8(0); id < protocol.MaxPlayers; id++ {
+		srv.gameState.Players.Add(player.New(id, nil))
+	}
+
+	legacy := dialLoopback(t, transport, client.Options{
+		Name: "legacy",
+		Extensions: []protocol.ExtensionEntry{
+			{ExtensionID: protocol.ExtensionIDPlayerProperties, ExtensionVersion: 1},
+		},
+	})
+	if legacy.PlayerID() < protocol.MaxPlayers {
+		t.Fatalf("expected a slot past the legacy range, got %d", legacy.PlayerID())
+	}
+	if err := legacy.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.WaitForSpawn(5 * time.Second); err != client.ErrDisconnected {
+		t.Fatalf("expected the legacy client to be turned away, got %v", err)
+	}
+	if legacy.DisconnectReason() != uint32(protocol.DisconnectReasonServerFull) {
+		t.Errorf("disconnect reason = %d, want server full", legacy.DisconnectReason())
+	}
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+	if c.PlayerID() < protocol.MaxPlayers {
+		t.Errorf("expected a slot past the legacy range, got %d", c.PlayerID())
+	}
+}
+
+func TestProtocol76Alongside75(t *testing.T) {
+	srv, transport := startLoopbackServer(t)
+
+	old := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := old.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for 0.75 spawn: %v", err)
+	}
+
+	conn, err := transport.DialVersion("", protocol.ProtocolVersion76)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(conn, client.Options{Name: "Newer", Team: 1, ProtocolVersion: protocol.ProtocolVersion76})
+	t.Cleanup(c.Disconnect)
+	if err := c.WaitForMap(10 * time.Second); err != nil {
+		t.Fatalf("waiting for 0.76 map: %v", err)
+	}
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for 0.76 spawn: %v", err)
+	}
+
+	p, ok := srv.gameState.Players.Get(c.PlayerID())
+	if !ok || p.ProtocolVersion != protocol.ProtocolVersion76 {
+		t.Fatalf("expected the server to track the client as 0.76")
+	}
+
+	// 0.76 world updates list players by id, 25 bytes each
+	var update []byte
+	c.Handle(protocol.PacketTypeWorldUpdate, func(data []byte) {
+		update = append([]byte(nil), data...)
+	})
+	if err := c.WaitFor(func() bool { return update != nil }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for a world update: %v", err)
+	}
+	if (len(update)-1)%25 != 0 || len(update) == 1+protocol.MaxPlayers*24 {
+		t.Fatalf("0.76 client got a world update of %d bytes, want entries of 25", len(update))
+	}
+	ids := map[uint8]bool{}
+	for i := 1; i < len(update); i += 25 {
+		ids[update[i]] = true
+	}
+	if !ids[old.PlayerID()] || !ids[c.PlayerID()] {
+		t.Errorf("0.76 world update lists ids %v, want both players", ids)
+	}
+
+	heard := false
+	c.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		heard = heard || message == "hello from 0.75"
+	})
+	if err := old.Chat("hello from 0.75"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitFor(func() bool { return heard }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for chat across versions: %v", err)
+	}
+
+	rejected, err := transport.DialVersion("", 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.New(rejected, client.Options{}).WaitForMap(2 * time.Second); err != client.ErrDisconnected {
+		t.Errorf("expected an unknown protocol version to be turned away, got %v", err)
+	}
+}
+
+func TestESPCullingHidesUnseenEnemies(t *testing.T) {
+	_, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.AntiCheat.ESPCulling = true
+		// nobody is close enough to be seen, so no raycast can let the
+		// enemy through
+		cfg.AntiCheat.ESPViewDistance = 0.5
+	})
+
+	clients := make([]*client.Client, 0, 3)
+	for _, opts := range []client.Options{
+		{Name: "viewer", Team: 0},
+		{Name: "mate", Team: 0},
+		{Name: "enemy", Team: 1},
+	} {
+		c := dialLoopback(t, transport, opts)
+		if err := c.Join(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitForSpawn(5 * time.Second); err != nil {
+			t.Fatalf("waiting for %s to spawn: %v", opts.Name, err)
+		}
+		// world updates carry the last position each client reported
+		if err := c.SetPosition(c.Position()); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	viewer, mate, enemy := clients[0], clients[1], clients[2]
+
+	err := viewer.WaitFor(func() bool {
+		m, ok := viewer.Player(mate.PlayerID())
+		return ok && m.Position != (protocol.Vector3f{}) && m.Position.Z < 64
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("waiting for the teammate's position: %v", err)
+	}
+
+	err = viewer.WaitFor(func() bool {
+		e, ok := viewer.Player(enemy.PlayerID())
+		return ok && e.Position.Z == hiddenPosition.Z
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("enemy out of sight was not hidden: %v", err)
+	}
+}
+
+func TestMovementValidationCorrectsTeleports(t *testing.T) {
+	srv, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.AntiCheat.MovementValidation = true
+	})
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+	spawn := c.Position()
+
+	// jump a long way across the map, a few times in a row
+	far := protocol.Vector3f{X: spawn.X + 60, Y: spawn.Y, Z: spawn.Z}
+	if spawn.X > 256 {
+		far.X = spawn.X - 60
+	}
+	near := func(pos protocol.Vector3f) bool {
+		return pos.X-spawn.X < 10 && spawn.X-pos.X < 10
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.SetPosition(far); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Poll(20 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := c.WaitFor(func() bool {
+		pos := c.Position()
+		return near(pos)
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("teleport was not corrected: %v", err)
+	}
+
+	p, ok := srv.gameState.Players.Get(c.PlayerID())
+	if !ok {
+		t.Fatal("player left")
+	}
+	p.RLock()
+	reported := p.LastReportedPos
+	p.RUnlock()
+	if !near(reported) {
+		t.Errorf("server accepted the teleport to %v", reported)
+	}
+}
+
+func TestMovementValidationLetsSpikesThrough(t *testing.T) {
+	srv, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.AntiCheat.MovementValidation = true
+	})
+
+	c := dialLoopback(t, transport, client.Options{Name: "Deuce", Team: 0})
+	if err := c.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForSpawn(5 * time.Second); err != nil {
+		t.Fatalf("waiting for spawn: %v", err)
+	}
+	spawn := c.Position()
+
+	// a single report that jumps ahead is what a lag spike looks like
+	ahead := protocol.Vector3f{X: spawn.X + 8, Y: spawn.Y, Z: spawn.Z}
+	if spawn.X > 256 {
+		ahead.X = spawn.X - 8
+	}
+	if err := c.SetPosition(ahead); err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := srv.gameState.Players.Get(c.PlayerID())
+	if !ok {
+		t.Fatal("player left")
+	}
+	err := c.WaitFor(func() bool {
+		p.RLock()
+		defer p.RUnlock()
+		return p.LastReportedPos == ahead
+	}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("single diverging report was dropped: %v", err)
+	}
+}
+
+func TestBlockDestroyNeedsDamage(t *testing.T) {
+	_, transport := startLoopbackServer(t, func(cfg *config.Config) {
+		cfg.AntiCheat.BlockDamage = true
+	})
+
+	digger := dialLoopback(t, transport, client.Options{Name: "digger", Team: 0})
+	watcher := dialLoopback(t, transport, client.Options{Name: "watcher", Team: 1})
+	for _, c := range []*client.Client{digger, watcher} {
+		if err := c.Join(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitForSpawn(5 * time.Second); err != nil {
+			t.Fatalf("waiting for spawn: %v", err)
+		}
+	}
+
+	pos := digger.Position()
+	x, y := int(pos.X), int(pos.Y)
+	z := digger.Map().FindTopBlock(x, y)
+
+	// an untouched block cannot be destroyed in one go, the build after it
+	// shows the watcher has seen everything the dig could have caused. The
+	// digger removed the block on its own and gets it back
+	color := digger.Map().Get(x, y, z)
+	digger.Map().SetAir(x, y, z)
+	if err := digger.Dig(x, y, z); err != nil {
+		t.Fatal(err)
+	}
+	if err := digger.WaitFor(func() bool { return digger.Map().IsSolid(x, y, z) }, 5*time.Second); err != nil {
+		t.Fatalf("the rejected block was not sent back: %v", err)
+	}
+	if got := digger.Map().Get(x, y, z); got&0xffffff != color&0xffffff {
+		t.Errorf("the block came back as %06x, want %06x", got&0xffffff, color&0xffffff)
+	}
+	markerZ := digger.Map().FindTopBlock(x+1, y) - 1
+	if err := digger.Build(x+1, y, markerZ); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.WaitFor(func() bool { return watcher.Map().IsSolid(x+1, y, markerZ) }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for the marker block: %v", err)
+	}
+	if !watcher.Map().IsSolid(x, y, z) {
+		t.Fatal("an undamaged block was destroyed")
+	}
+
+	// swing the spade at the ground until it gives way
+	if err := digger.SetTool(protocol.ItemTypeSpade); err != nil {
+		t.Fatal(err)
+	}
+	if err := digger.SetOrientation(protocol.Vector3f{Z: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := digger.SetWeaponInput(protocol.WeaponInputPrimary); err != nil {
+		t.Fatal(err)
+	}
+	// a couple of swings land well within half a second
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		if err := digger.Poll(50 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := digger.Dig(x, y, z); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.WaitFor(func() bool { return !watcher.Map().IsSolid(x, y, z) }, 5*time.Second); err != nil {
+		t.Fatalf("a block worn down by the spade was not destroyed: %v", err)
+	}
+}
+
+func TestShotgunBlockDestroyNeedsEveryPellet(t *testing.T) {
+	srv := &Server{
+		config:      &config.Config{AntiCheat: config.AntiCheatConfig{BlockDamage: true}},
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		blockDamage: make(map[blockKey]*blockDamage),
+		blockTools:  make(map[uint8]*blockTool),
+	}
+
+	p := player.New(0, nil)
+	p.Tool = protocol.ItemTypeGun
+	p.Weapon = protocol.WeaponTypeShotgun
+	p.SetPosition(protocol.Vector3f{X: 10.5, Y: 10.5, Z: 5})
+
+	// one pellet and the one that broke it are nowhere near a full block,
+	// even though a whole shot would have been
+	now := time.Now()
+	srv.damageBlock(10, 10, 10, shotgunBlockDamage, now)
+	if srv.plausibleBlockDestroy(p, protocol.BlockActionTypeSpadeGunDestroy, 10, 10, 10) {
+		t.Error("destroy after a single pellet was accepted")
+	}
+
+	for i := 0; i < 3; i++ {
+		srv.damageBlock(10, 10, 10, shotgunBlockDamage, now)
+	}
+	if !srv.plausibleBlockDestroy(p, protocol.BlockActionTypeSpadeGunDestroy, 10, 10, 10) {
+		t.Error("destroy one pellet short of breaking was rejected")
+	}
+
+	// at range the pellets spread wider than a block, the traced ones may
+	// miss it while the client's landed
+	p.SetPosition(protocol.Vector3f{X: 10.5, Y: 50.5, Z: 10.5})
+	if srv.plausibleBlockDestroy(p, protocol.BlockActionTypeSpadeGunDestroy, 10, 20, 10) {
+		t.Error("destroy at range with no pellet anywhere near was accepted")
+	}
+	srv.damageBlock(11, 20, 10, shotgunBlockDamage, now)
+	if !srv.plausibleBlockDestroy(p, protocol.BlockActionTypeSpadeGunDestroy, 10, 20, 10) {
+		t.Error("destroy at range next to a traced pellet was rejected")
+	}
+}
+
+func TestViewerIDStaysOffPlayers(t *testing.T) {
+	srv := &Server{gameState: &gamestate.GameState{Players: player.NewManager()}}
+	join := func(id uint8) *player.Player {
+		p := player.New(id, nil)
+		p.State = player.PlayerStateReady
+		srv.gameState.Players.Add(p)
+		return p
+	}
+	chat := func(id uint8) []byte {
+		return []byte{byte(protocol.PacketTypeChatMessage), id, 0, 'h', 'i', 0}
+	}
+	types := func(packets [][]byte) (got []string) {
+		for _, data := range packets {
+			got = append(got, fmt.Sprintf("%d:%d", data[0], data[1]))
+		}
+		return got
+	}
+
+	join(viewerID)
+	out := srv.forViewers(chat(viewerID), true)
+	want := []string{
+		fmt.Sprintf("%d:30", protocol.PacketTypeExistingPlayer),
+		fmt.Sprintf("%d:30", protocol.PacketTypeChatMessage),
+	}
+	if got := types(out); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("player on the viewer id reached viewers as %v, want %v", got, want)
+	}
+
+	// someone joining on the stand-in pushes them to the next free id
+	join(30)
+	out = srv.forViewers(chat(30), true)
+	want = []string{
+		fmt.Sprintf("%d:30", protocol.PacketTypePlayerLeft),
+		fmt.Sprintf("%d:29", protocol.PacketTypeExistingPlayer),
+		fmt.Sprintf("%d:30", protocol.PacketTypeChatMessage),
+	}
+	if got := types(out); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("stand-in taken over gave %v, want %v", got, want)
+	}
+
+	update := make([]byte, 1+protocol.MaxPlayers*24)
+	update[0] = byte(protocol.PacketTypeWorldUpdate)
+	update[1+int(viewerID)*24] = 7
+	out = srv.forViewers(update, true)
+	if len(out) != 1 || out[0][1+29*24] != 7 || out[0][1+int(viewerID)*24] != 0 {
+		t.Error("world update entry of the viewer id was not moved to the stand-in")
+	}
+
+	// with every other id taken they cannot be shown at all
+	for id := uint8(0); id < 30; id++ {
+		join(id)
+	}
+	out = srv.forViewers(chat(viewerID), true)
+	if got := types(out); len(got) != 1 || got[0] != fmt.Sprintf("%d:29", protocol.PacketTypePlayerLeft) {
+		t.Errorf("with no free id viewers got %v, want only the stand-in leaving", got)
+	}
+}
+
+func TestBotsHeadForUnheldTerritories(t *testing.T) {
+	srv := &Server{gameState: &gamestate.GameState{}}
+	if _, ok := srv.closestTerritory(player.New(0, nil)); ok {
+		t.Fatal("a territory was found before the script set any")
+	}
+
+	srv.gameState.SetTerritory(0, protocol.Vector3f{X: 10, Y: 10}, 0)
+	srv.gameState.SetTerritory(1, protocol.Vector3f{X: 200, Y: 200}, 1)
+	srv.gameState.SetTerritory(2, protocol.Vector3f{X: 400, Y: 400}, 255)
+
+	blue := player.New(0, nil)
+	blue.SetPosition(protocol.Vector3f{X: 12, Y: 12})
+	if pos, ok := srv.closestTerritory(blue); !ok || pos.X != 200 {
+		t.Errorf("blue bot heads for %v, want the green territory at 200", pos)
+	}
+
+	srv.gameState.SetTerritory(1, protocol.Vector3f{X: 200, Y: 200}, 0)
+	srv.gameState.SetTerritory(2, protocol.Vector3f{X: 400, Y: 400}, 0)
+	if pos, ok := srv.closestTerritory(blue); !ok || pos.X != 10 {
+		t.Errorf("with every territory held the bot heads for %v, want the nearest at 10", pos)
+	}
+}
+
+func TestReservedNamesNeedLogin(t *testing.T) {
+	srv, transport := newLoopbackServer(t, func(cfg *config.Config) {
+		cfg.Accounts.Enabled = true
+		cfg.Accounts.GracePeriod = 1
+	})
+
+	store := accounts.NewStore(filepath.Join(t.TempDir(), "accounts.json"))
+	for _, name := range []string{"Admin", "Owner"} {
+		if err := store.Register(name, "secret1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetRole("Owner", "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	srv.accounts = store
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+
+	impostor := dialLoopback(t, transport, client.Options{Name: "Admin", Team: 0})
+	owner := dialLoopback(t, transport, client.Options{Name: "Owner", Team: 1})
+	for _, c := range []*client.Client{impostor, owner} {
+		if err := c.Join(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitForSpawn(5 * time.Second); err != nil {
+			t.Fatalf("waiting for spawn: %v", err)
+		}
+	}
+
+	var wrong string
+	impostor.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		if strings.HasPrefix(message, "Incorrect password") {
+			wrong = message
+		}
+	})
+	if err := impostor.Chat("/login guess123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := impostor.WaitFor(func() bool { return wrong != "" }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for the wrong password reply: %v", err)
+	}
+
+	var reply string
+	owner.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		if strings.HasPrefix(message, "Logged in") {
+			reply = message
+		}
+	})
+	if err := owner.Chat("/login secret1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.WaitFor(func() bool { return reply != "" }, 5*time.Second); err != nil {
+		t.Fatalf("waiting for the login reply: %v", err)
+	}
+
+	renamed := func() bool {
+		p, ok := owner.Player(impostor.PlayerID())
+		return ok && p.Name != "Admin"
+	}
+	if err := owner.WaitFor(renamed, 5*time.Second); err != nil {
+		t.Fatalf("the impostor kept the registered name: %v", err)
+	}
+	if p, _ := owner.Player(impostor.PlayerID()); !strings.HasPrefix(p.Name, "Deuce") {
+		t.Errorf("impostor renamed to %q", p.Name)
+	}
+
+	if err := impostor.WaitFor(func() bool {
+		p, ok := impostor.Player(owner.PlayerID())
+		return ok && p.Name == "Owner"
+	}, 5*time.Second); err != nil {
+		t.Errorf("the owner lost their name: %v", err)
+	}
+	p, ok := srv.gameState.Players.Get(owner.PlayerID())
+	if !ok || p.Permissions != 1<<3 {
+		t.Errorf("the owner did not get the moderator role of the account")
+	}
+}
+
+func TestViolationLadderSurvivesKick(t *testing.T) {
+	srv, transport := newLoopbackServer(t, func(cfg *config.Config) {
+		cfg.Violations.Enabled = true
+	})
+	srv.banManager = bans.NewManager(filepath.Join(t.TempDir(), "bans.json"))
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+
+	const address = "10.0.0.5"
+	join := func(name string) *client.Client {
+		t.Helper()
+		conn, err := transport.Dial(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := client.New(conn, client.Options{Name: name, Team: 0})
+		t.Cleanup(c.Disconnect)
+		if err := c.WaitForMap(10 * time.Second); err != nil {
+			t.Fatalf("waiting for map: %v", err)
+		}
+		if err := c.Join(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitForSpawn(5 * time.Second); err != nil {
+			t.Fatalf("waiting for spawn: %v", err)
+		}
+		return c
+	}
+	score := func(id uint8) float64 {
+		done := make(chan float64)
+		srv.post(func() {
+			report, _ := srv.GetViolations(id)
+			done <- report.Score
+		})
+		return <-done
+	}
+	violate := func(id uint8, weight float64) {
+		srv.post(func() { srv.AddViolation(id, "movement", "test", weight) })
+	}
+
+	// shares the address but must keep a ledger of its own
+	bystander := join("Bystander")
+	cheater := join("Cheater")
+
+	violate(cheater.PlayerID(), 25)
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.gameState.Players.Count() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the kick rung did not remove the player")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if banned, _ := srv.banManager.IsBanned(address); banned {
+		t.Fatal("the kick rung banned the player")
+	}
+	if got := score(bystander.PlayerID()); got != 0 {
+		t.Errorf("the bystander has a score of %.1f", got)
+	}
+
+	back := join("Cheater")
+	if got := score(back.PlayerID()); got < 20 {
+		t.Fatalf("the player came back with a score of %.1f, want the one they were kicked with", got)
+	}
+	violate(back.PlayerID(), 20)
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if banned, _ := srv.banManager.IsBanned(address); banned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the player never reached the ban rung")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	srv, transport := startLoopbackServer(t)
+
+	admin := dialLoopback(t, transport, client.Options{Name: "Admin", Team: 0})
+	victim := dialLoopback(t, transport, client.Options{Name: "Victim", Team: 1})
+	for _, c := range []*client.Client{admin, victim} {
+		if err := c.Join(); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitForSpawn(5 * time.Second); err != nil {
+			t.Fatalf("waiting for spawn: %v", err)
+		}
+	}
+
+	var replies []string
+	admin.OnChat(func(playerID uint8, chatType protocol.ChatType, message string) {
+		replies = append(replies, message)
+	})
+	for _, line := range []string{"/kick Victim", "/login admin " + srv.config.Passwords.Admin, "/kick Victim griefing"} {
+		if err := admin.Chat(line); err != nil {
+			t.Fatal(err)
+		}
+		n := len(replies)
+		if err := admin.WaitFor(func() bool { return len(replies) > n }, 5*time.Second); err != nil {
+			t.Fatalf("waiting for the reply to %s: %v", line, err)
+		}
+	}
+
+	entries, err := srv.AuditLog("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+		if e.Actor != "Admin" || e.IP == "" {
+			t.Errorf("%s entry by %q from %q", e.Action, e.Actor, e.IP)
+		}
+	}
+	want := "command kick set_player_permission command_denied"
+	if got := strings.Join(actions, " "); got != want {
+		t.Fatalf("audit actions %q, want %q", got, want)
+	}
+	if entries[1].Target != "Victim" || entries[1].Args[0] != "griefing" {
+		t.Errorf("kick entry: %+v", entries[1])
+	}
+
+	if about, _ := srv.AuditLog("victim", 10); len(about) != 1 {
+		t.Errorf("%d entries about the victim, want 1", len(about))
+	}
+}
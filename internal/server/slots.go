@@ -0,0 +1,133 @@
+package server
+
+import (
+	"time"
+
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// a client on a slot past the legacy range gets this long to announce the
+// 256 players extension once it has started negotiating
+const pendingJoinTimeout = 10 * time.Second
+
+type pendingJoin struct {
+	data     []byte
+	deadline time.Time
+}
+
+// extendedSlots reports whether p understands player ids past the legacy 32
+func extendedSlots(p *player.Player) bool {
+	return p.SupportsExtension(protocol.ExtensionID256Players)
+}
+
+// admitSlot decides whether a player may join from the slot they were given.
+// Slots are handed out lowest first so vanilla clients only ever land past 32
+// when the server is that full, and clients still negotiating have their join
+// held until their extension info arrives
+func (s *Server) admitSlot(p *player.Player, data []byte) bool {
+	if p.ID < protocol.MaxPlayers || extendedSlots(p) {
+		return true
+	}
+
+	p.RLock()
+	negotiating := p.VersionInfoReceived && !p.ExtensionsReceived
+	p.RUnlock()
+
+	if negotiating {
+		s.pendingJoins[p.ID] = pendingJoin{
+			data:     append([]byte(nil), data...),
+			deadline: time.Now().Add(pendingJoinTimeout),
+		}
+		return false
+	}
+
+	s.rejectLegacySlot(p)
+	return false
+}
+
+// resumePendingJoin finishes a join held by admitSlot now that the client
+// has told us what it supports
+func (s *Server) resumePendingJoin(p *player.Player) {
+	pending, ok := s.pendingJoins[p.ID]
+	if !ok {
+		return
+	}
+	delete(s.pendingJoins, p.ID)
+
+	if extendedSlots(p) {
+		s.handleExistingPlayer(p, pending.data)
+	} else {
+		s.rejectLegacySlot(p)
+	}
+}
+
+func (s *Server) expirePendingJoins(now time.Time) {
+	for id, pending := range s.pendingJoins {
+		if now.Before(pending.deadline) {
+			continue
+		}
+		delete(s.pendingJoins, id)
+		if p, ok := s.gameState.Players.Get(id); ok {
+			s.rejectLegacySlot(p)
+		}
+	}
+}
+
+func (s *Server) rejectLegacySlot(p *player.Player) {
+	delete(s.pendingJoins, p.ID)
+	s.logger.Info("client without the 256 players extension got a slot past 32, disconnecting",
+		"player", p.ID)
+	s.network.DisconnectPeerWithReason(p.Peer, false, uint32(protocol.DisconnectReasonServerFull))
+}
+
+// refersToHighSlot reports packets naming a player past the legacy range,
+// vanilla clients index fixed 32 entry arrays with those ids so they never
+// get to see them
+func refersToHighSlot(data []byte) bool {
+	for _, i := range playerIDOffsets(data) {
+		if id := data[i]; id >= protocol.MaxPlayers && id != 255 {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	playerIDAt1      = []int{1}
+	playerIDsAt1And2 = []int{1, 2}
+)
+
+// playerIDOffsets lists where a packet names players
+func playerIDOffsets(data []byte) []int {
+	if len(data) < 2 {
+		return nil
+	}
+
+	switch protocol.PacketType(data[0]) {
+	case protocol.PacketTypeKillAction:
+		if len(data) > 2 {
+			return playerIDsAt1And2
+		}
+		return playerIDAt1
+	case protocol.PacketTypeInputData,
+		protocol.PacketTypeWeaponInput,
+		protocol.PacketTypeGrenade,
+		protocol.PacketTypeSetTool,
+		protocol.PacketTypeSetColor,
+		protocol.PacketTypeExistingPlayer,
+		protocol.PacketTypeShortPlayerData,
+		protocol.PacketTypeCreatePlayer,
+		protocol.PacketTypeBlockAction,
+		protocol.PacketTypeBlockLine,
+		protocol.PacketTypeChatMessage,
+		protocol.PacketTypePlayerLeft,
+		protocol.PacketTypeIntelCapture,
+		protocol.PacketTypeIntelPickup,
+		protocol.PacketTypeIntelDrop,
+		protocol.PacketTypeChangeTeam,
+		protocol.PacketTypeChangeWeapon:
+		return playerIDAt1
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/anticheat"
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/pkg/config"
+)
+
+// ledgerFor returns the violation ledger of p. Ledgers belong to the slot
+// while the player is in game, players behind the same address keep their
+// own and the address is only used when the ladder bans
+func (s *Server) ledgerFor(p *player.Player) *anticheat.Ledger {
+	if p.Bot || p.Peer == nil {
+		return nil
+	}
+
+	ledger, ok := s.ledgers[p.ID]
+	if !ok {
+		ledger = anticheat.NewLedger(time.Duration(s.config.Violations.HalfLife) * time.Second)
+		s.ledgers[p.ID] = ledger
+	}
+	return ledger
+}
+
+func ledgerKey(address, name string) string {
+	return address + "\x00" + strings.ToLower(name)
+}
+
+// restoreLedger hands a joining player the ledger they left with, matched
+// by address and name so players sharing an address don't share a score
+// but a kick doesn't start the ladder over
+func (s *Server) restoreLedger(p *player.Player) {
+	if p.Bot || p.Peer == nil {
+		return
+	}
+	key := ledgerKey(p.Peer.Address(), p.GetName())
+	s.ledgerKeys[p.ID] = key
+
+	left, ok := s.leftLedgers[key]
+	if !ok {
+		return
+	}
+	delete(s.leftLedgers, key)
+	if current, ok := s.ledgers[p.ID]; ok {
+		current.Absorb(left, time.Now())
+		return
+	}
+	s.ledgers[p.ID] = left
+}
+
+// retainLedger keeps the ledger of a leaving player until its score has
+// decayed to nothing, left ledgers that got there are dropped on the way
+func (s *Server) retainLedger(p *player.Player) {
+	ledger, hasLedger := s.ledgers[p.ID]
+	key, hasKey := s.ledgerKeys[p.ID]
+	delete(s.ledgers, p.ID)
+	delete(s.ledgerKeys, p.ID)
+
+	now := time.Now()
+	for k, left := range s.leftLedgers {
+		if left.Score(now) == 0 {
+			delete(s.leftLedgers, k)
+		}
+	}
+	if hasLedger && hasKey && ledger.Score(now) > 0 {
+		s.leftLedgers[key] = ledger
+	}
+}
+
+// recordViolation adds a violation with the configured weight of category
+func (s *Server) recordViolation(p *player.Player, category, detail string) {
+	weight := s.config.Violations.Weights[category]
+	if weight <= 0 {
+		return
+	}
+	s.addViolation(p, category, detail, weight)
+}
+
+func (s *Server) addViolation(p *player.Player, category, detail string, weight float64) {
+	if !s.config.Violations.Enabled {
+		return
+	}
+	ledger := s.ledgerFor(p)
+	if ledger == nil {
+		return
+	}
+
+	score := ledger.Record(time.Now(), category, weight)
+	s.logger.Info("violation recorded", "player", p.GetName(), "id", p.ID, "category", category,
+		"detail", detail, "score", score)
+
+	// only the highest step reached is applied when several are crossed at once
+	var step *config.ViolationStep
+	ladder := s.config.Violations.Ladder
+	for ledger.Step < len(ladder) && score >= ladder[ledger.Step].Score {
+		step = &ladder[ledger.Step]
+		ledger.Step++
+	}
+	if step != nil {
+		s.applyViolationStep(p, *step, category, score)
+	}
+}
+
+func (s *Server) applyViolationStep(p *player.Player, step config.ViolationStep, category string, score float64) {
+	name := p.GetName()
+	s.logger.Warn("violation ladder step reached", "player", name, "id", p.ID,
+		"action", step.Action, "minutes", step.Minutes, "score", score)
+
+	category = strings.ReplaceAll(category, "_", " ")
+	s.notifyStaff(fmt.Sprintf("[violations] %s (#%d) reached %.0f points after %s, action: %s",
+		name, p.ID, score, category, step.Action))
+
+	reason := "Too many violations (" + category + ")"
+	switch step.Action {
+	case "warn":
+		s.sendChatToPlayer(p, "Warning: the server rejected too much of what your client sent ("+category+
+			"), keep going and you will be removed")
+	case "kick":
+		s.KickPlayer(p.ID, reason)
+	case "ban":
+		duration := time.Duration(step.Minutes) * time.Minute
+		if err := s.banManager.AddBan(p.Peer.Address(), name, reason, "violations", duration); err != nil {
+			s.logger.Error("failed to ban player", "player", name, "error", err)
+		}
+		s.KickPlayer(p.ID, reason)
+	}
+}
+
+// GetViolations returns the violation ledger of a player in game
+func (s *Server) GetViolations(playerID uint8) (anticheat.Report, bool) {
+	p, ok := s.gameState.Players.Get(playerID)
+	if !ok {
+		return anticheat.Report{}, false
+	}
+	ledger := s.ledgerFor(p)
+	if ledger == nil {
+		return anticheat.Report{}, false
+	}
+	return ledger.Report(time.Now()), true
+}
+
+// AddViolation lets scripts feed the ledger. A negative weight uses the
+// configured weight of the category or 1 for categories without one, a
+// category configured with weight 0 is turned off and records nothing
+func (s *Server) AddViolation(playerID uint8, category, detail string, weight float64) bool {
+	p, ok := s.gameState.Players.Get(playerID)
+	if !ok || !s.config.Violations.Enabled {
+		return false
+	}
+	if weight < 0 {
+		configured, ok := s.config.Violations.Weights[category]
+		if !ok {
+			configured = 1
+		}
+		weight = configured
+	}
+	if weight <= 0 || s.ledgerFor(p) == nil {
+		return false
+	}
+	s.addViolation(p, category, detail, weight)
+	return true
+}
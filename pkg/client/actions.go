@@ -0,0 +1,208 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// Join sends the ExistingPlayer packet that takes us from the loading screen
+// into the game with the team, weapon and name from Options
+func (c *Client) Join() error {
+	if c.vxlMap == nil {
+		return fmt.Errorf("map not loaded yet")
+	}
+
+	packet := protocol.PacketExistingPlayer{
+		PacketID: uint8(protocol.PacketTypeExistingPlayer),
+		PlayerID: c.playerID,
+		Team:     c.opts.Team,
+		Weapon:   c.opts.Weapon,
+		Item:     protocol.ItemTypeGun,
+		Color:    c.opts.Color,
+	}
+	name, err := protocol.StringToCP437(c.opts.Name)
+	if err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+	copy(packet.Name[:protocol.PlayerNameLen-1], name)
+
+	if err := c.send(&packet, true); err != nil {
+		return err
+	}
+
+	c.joined = true
+	self := c.playerInfo(c.playerID)
+	self.Name = c.opts.Name
+	self.Team = c.opts.Team
+	self.Weapon = c.opts.Weapon
+	self.Color = c.opts.Color
+	return nil
+}
+
+func (c *Client) SetPosition(pos Vector3f) error {
+	c.position = pos
+	return c.send(&protocol.PacketPositionData{
+		PacketID: uint8(protocol.PacketTypePositionData),
+		X:        pos.X,
+		Y:        pos.Y,
+		Z:        pos.Z,
+	}, false)
+}
+
+func (c *Client) SetOrientation(ori Vector3f) error {
+	c.orientation = ori
+	return c.send(&protocol.PacketOrientationData{
+		PacketID: uint8(protocol.PacketTypeOrientationData),
+		X:        ori.X,
+		Y:        ori.Y,
+		Z:        ori.Z,
+	}, false)
+}
+
+// SetInput sends the held movement keys, the server simulates movement from them
+func (c *Client) SetInput(keys KeyState) error {
+	return c.send(&protocol.PacketInputData{
+		PacketID:  uint8(protocol.PacketTypeInputData),
+		PlayerID:  c.playerID,
+		KeyStates: keys,
+	}, false)
+}
+
+func (c *Client) SetWeaponInput(input WeaponInput) error {
+	return c.send(&protocol.PacketWeaponInput{
+		PacketID:    uint8(protocol.PacketTypeWeaponInput),
+		PlayerID:    c.playerID,
+		WeaponInput: input,
+	}, false)
+}
+
+func (c *Client) SetTool(tool ItemType) error {
+	return c.send(&protocol.PacketSetTool{
+		PacketID: uint8(protocol.PacketTypeSetTool),
+		PlayerID: c.playerID,
+		Tool:     tool,
+	}, true)
+}
+
+func (c *Client) SetColor(color Color3b) error {
+	c.opts.Color = color
+	c.playerInfo(c.playerID).Color = color
+	return c.send(&protocol.PacketSetColor{
+		PacketID: uint8(protocol.PacketTypeSetColor),
+		PlayerID: c.playerID,
+		Color:    color,
+	}, true)
+}
+
+// Shoot fires one shot along the current orientation, passing a target
+// reports a hit on that player the way a real client does after its own trace
+func (c *Client) Shoot(target *uint8, hitType HitType) error {
+	if err := c.SetWeaponInput(protocol.WeaponInputPrimary); err != nil {
+		return err
+	}
+	if target != nil {
+		if err := c.Hit(*target, hitType); err != nil {
+			return err
+		}
+	}
+	return c.SetWeaponInput(0)
+}
+
+func (c *Client) Hit(target uint8, hitType HitType) error {
+	return c.send(&protocol.PacketHit{
+		PacketID: uint8(protocol.PacketTypeHit),
+		PlayerID: target,
+		HitType:  hitType,
+	}, true)
+}
+
+func (c *Client) Reload() error {
+	return c.send(&protocol.PacketWeaponReload{
+		PacketID: uint8(protocol.PacketTypeWeaponReload),
+		PlayerID: c.playerID,
+	}, true)
+}
+
+func (c *Client) Grenade(fuse float32, pos, velocity Vector3f) error {
+	return c.send(&protocol.PacketGrenade{
+		PacketID:   uint8(protocol.PacketTypeGrenade),
+		PlayerID:   c.playerID,
+		FuseLength: fuse,
+		X:          pos.X,
+		Y:          pos.Y,
+		Z:          pos.Z,
+		VX:         velocity.X,
+		VY:         velocity.Y,
+		VZ:         velocity.Z,
+	}, true)
+}
+
+func (c *Client) Build(x, y, z int) error {
+	return c.blockAction(protocol.BlockActionTypeBuild, x, y, z)
+}
+
+func (c *Client) Dig(x, y, z int) error {
+	return c.blockAction(protocol.BlockActionTypeSpadeGunDestroy, x, y, z)
+}
+
+func (c *Client) BuildLine(x1, y1, z1, x2, y2, z2 int) error {
+	return c.send(&protocol.PacketBlockLine{
+		PacketID: uint8(protocol.PacketTypeBlockLine),
+		PlayerID: c.playerID,
+		StartX:   uint32(x1),
+		StartY:   uint32(y1),
+		StartZ:   uint32(z1),
+		EndX:     uint32(x2),
+		EndY:     uint32(y2),
+		EndZ:     uint32(z2),
+	}, true)
+}
+
+func (c *Client) blockAction(action protocol.BlockActionType, x, y, z int) error {
+	return c.send(&protocol.PacketBlockAction{
+		PacketID: uint8(protocol.PacketTypeBlockAction),
+		PlayerID: c.playerID,
+		Action:   action,
+		X:        int32(x),
+		Y:        int32(y),
+		Z:        int32(z),
+	}, true)
+}
+
+func (c *Client) Chat(message string) error {
+	return c.chat(protocol.ChatTypeAll, message)
+}
+
+func (c *Client) TeamChat(message string) error {
+	return c.chat(protocol.ChatTypeTeam, message)
+}
+
+func (c *Client) chat(chatType protocol.ChatType, message string) error {
+	encoded, err := protocol.StringToCP437(message)
+	if err != nil {
+		return fmt.Errorf("invalid chat message: %w", err)
+	}
+	return c.send(&protocol.PacketChatMessage{
+		PacketID: uint8(protocol.PacketTypeChatMessage),
+		PlayerID: c.playerID,
+		Type:     chatType,
+		Message:  encoded,
+	}, true)
+}
+
+func (c *Client) ChangeTeam(team uint8) error {
+	return c.send(&protocol.PacketChangeTeam{
+		PacketID: uint8(protocol.PacketTypeChangeTeam),
+		PlayerID: c.playerID,
+		TeamID:   team,
+	}, true)
+}
+
+func (c *Client) ChangeWeapon(weapon WeaponType) error {
+	return c.send(&protocol.PacketChangeWeapon{
+		PacketID: uint8(protocol.PacketTypeChangeWeapon),
+		PlayerID: c.playerID,
+		WeaponID: weapon,
+	}, true)
+}
@@ -0,0 +1,674 @@
+// Package client is a headless Ace of Spades 0.75 and 0.76 client for bots,
+// load tests and end-to-end tests against the server.
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/siohaza/fosilo/internal/network"
+	"github.com/siohaza/fosilo/internal/protocol"
+	"github.com/siohaza/fosilo/pkg/vxl"
+)
+
+var ErrDisconnected = errors.New("disconnected from server")
+
+const defaultBlockColor uint32 = 0x707070
+
+// Conn is the connection a Client talks through, satisfied by both
+// network.Client and network.LoopbackClient
+type Conn interface {
+	Send(data []byte, reliable bool) error
+	Service(timeout time.Duration) (*Event, error)
+	Disconnect()
+}
+
+type Options struct {
+	Name   string
+	Team   uint8
+	Weapon WeaponType
+	Color  Color3b
+
+	// ProtocolVersion defaults to 0.75, when dialing through a loopback the
+	// connection has to be made with the same version
+	ProtocolVersion  uint8
+	ClientIdentifier byte
+	VersionMajor     int8
+	VersionMinor     int8
+	VersionRevision  int8
+	OSInfo           string
+	Extensions       []ExtensionEntry
+
+	Logger *slog.Logger
+}
+
+type PlayerInfo struct {
+	ID          uint8
+	Name        string
+	Team        uint8
+	Weapon      WeaponType
+	Color       Color3b
+	Kills       uint32
+	Alive       bool
+	Position    Vector3f
+	Orientation Vector3f
+}
+
+type PacketHandler func(data []byte)
+
+type ChatHandler func(playerID uint8, chatType ChatType, message string)
+
+// Client holds the view of one connection, it is not safe for concurrent use
+// so drive each client from a single goroutine
+type Client struct {
+	conn   Conn
+	opts   Options
+	logger *slog.Logger
+
+	connected        bool
+	disconnectReason uint32
+
+	mapSize   uint32
+	mapData   bytes.Buffer
+	vxlMap    *vxl.Map
+	state     protocol.PacketStateData
+	stateSeen bool
+
+	playerID    uint8
+	joined      bool
+	alive       bool
+	hp          uint8
+	position    protocol.Vector3f
+	orientation protocol.Vector3f
+
+	players          map[uint8]*PlayerInfo
+	serverExtensions map[protocol.ExtensionID]uint8
+
+	handlers     map[protocol.PacketType][]PacketHandler
+	chatHandlers []ChatHandler
+}
+
+// Dial connects to a server over ENet
+func Dial(host string, port int, opts Options) (*Client, error) {
+	version := opts.ProtocolVersion
+	if version == 0 {
+		version = protocol.ProtocolVersion75
+	}
+	conn, err := network.Dial(host, port, uint32(version), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, opts), nil
+}
+
+// New wraps an already established connection
+func New(conn Conn, opts Options) *Client {
+	if opts.Name == "" {
+		opts.Name = "Deuce"
+	}
+	if opts.ProtocolVersion == 0 {
+		opts.ProtocolVersion = protocol.ProtocolVersion75
+	}
+	if opts.ClientIdentifier == 0 {
+		opts.ClientIdentifier = 'g'
+	}
+	if opts.VersionMajor == 0 && opts.VersionMinor == 0 && opts.VersionRevision == 0 {
+		opts.VersionMinor = 75
+	}
+	if opts.OSInfo == "" {
+		opts.OSInfo = "fosilo headless client"
+	}
+	if opts.Extensions == nil {
+		opts.Extensions = []protocol.ExtensionEntry{
+			{ExtensionID: protocol.ExtensionIDPlayerProperties, ExtensionVersion: 1},
+			{ExtensionID: protocol.ExtensionID256Players, ExtensionVersion: 1},
+			{ExtensionID: protocol.ExtensionIDMessageTypes, ExtensionVersion: 1},
+			{ExtensionID: protocol.ExtensionIDKickReason, ExtensionVersion: 1},
+		}
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return &Client{
+		conn:             conn,
+		opts:             opts,
+		logger:           logger,
+		connected:        true,
+		players:          make(map[uint8]*PlayerInfo),
+		serverExtensions: make(map[protocol.ExtensionID]uint8),
+		handlers:         make(map[protocol.PacketType][]PacketHandler),
+	}
+}
+
+// Handle registers fn to run after the client processed a packet of the given type
+func (c *Client) Handle(packetType PacketType, fn PacketHandler) {
+	c.handlers[packetType] = append(c.handlers[packetType], fn)
+}
+
+func (c *Client) OnChat(fn ChatHandler) {
+	c.chatHandlers = append(c.chatHandlers, fn)
+}
+
+func (c *Client) Connected() bool          { return c.connected }
+func (c *Client) DisconnectReason() uint32 { return c.disconnectReason }
+func (c *Client) Map() *vxl.Map            { return c.vxlMap }
+func (c *Client) MapLoaded() bool          { return c.vxlMap != nil }
+func (c *Client) PlayerID() uint8          { return c.playerID }
+func (c *Client) Joined() bool             { return c.joined }
+func (c *Client) Alive() bool              { return c.alive }
+func (c *Client) HP() uint8                { return c.hp }
+func (c *Client) Position() Vector3f {
+	return c.position
+}
+
+func (c *Client) State() (StateData, bool) {
+	return c.state, c.stateSeen
+}
+
+func (c *Client) Player(id uint8) (PlayerInfo, bool) {
+	p, ok := c.players[id]
+	if !ok {
+		return PlayerInfo{}, false
+	}
+	return *p, true
+}
+
+func (c *Client) Players() []PlayerInfo {
+	players := make([]PlayerInfo, 0, len(c.players))
+	for _, p := range c.players {
+		players = append(players, *p)
+	}
+	return players
+}
+
+func (c *Client) ServerExtension(id ExtensionID) (uint8, bool) {
+	version, ok := c.serverExtensions[id]
+	return version, ok
+}
+
+// negotiated reports an extension both we and the server announced
+func (c *Client) negotiated(id protocol.ExtensionID) bool {
+	if _, ok := c.serverExtensions[id]; !ok {
+		return false
+	}
+	for _, entry := range c.opts.Extensions {
+		if entry.ExtensionID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll waits up to timeout for network traffic and processes everything
+// that is queued, it returns ErrDisconnected once the server drops us
+func (c *Client) Poll(timeout time.Duration) error {
+	if !c.connected {
+		return ErrDisconnected
+	}
+
+	for {
+		event, err := c.conn.Service(timeout)
+		if err != nil {
+			return err
+		}
+		timeout = 0
+
+		switch event.Type {
+		case network.EventTypeNone:
+			return nil
+
+		case network.EventTypeDisconnect:
+			c.connected = false
+			c.disconnectReason = event.Reason
+			c.logger.Info("disconnected", "reason", event.Reason)
+			return ErrDisconnected
+
+		case network.EventTypeReceive:
+			c.handlePacket(event.Data)
+		}
+	}
+}
+
+// WaitFor polls until cond holds or the timeout passes
+func (c *Client) WaitFor(cond func() bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		if err := c.Poll(min(remaining, 50*time.Millisecond)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) WaitForMap(timeout time.Duration) error {
+	return c.WaitFor(c.MapLoaded, timeout)
+}
+
+func (c *Client) WaitForSpawn(timeout time.Duration) error {
+	return c.WaitFor(c.Alive, timeout)
+}
+
+func (c *Client) Disconnect() {
+	if !c.connected {
+		return
+	}
+	c.connected = false
+	c.conn.Disconnect()
+}
+
+func (c *Client) handlePacket(data []byte) {
+	if len(data) < 1 {
+		return
+	}
+
+	packetType := protocol.PacketType(data[0])
+
+	switch packetType {
+	case protocol.PacketTypeMapStart:
+		c.handleMapStart(data)
+	case protocol.PacketTypeMapChunk:
+		c.mapData.Write(data[1:])
+	case protocol.PacketTypeStateData:
+		c.handleStateData(data)
+	case protocol.PacketTypeHandShakeInit:
+		c.handleHandshakeInit(data)
+	case protocol.PacketTypeVersionRequest:
+		c.sendVersionResponse()
+	case protocol.PacketTypeExtensionInfo:
+		c.handleExtensionInfo(data)
+	case protocol.PacketTypeExistingPlayer:
+		c.handleExistingPlayer(data)
+	case protocol.PacketTypeCreatePlayer:
+		c.handleCreatePlayer(data)
+	case protocol.PacketTypeShortPlayerData:
+		c.handleShortPlayerData(data)
+	case protocol.PacketTypePlayerLeft:
+		if len(data) >= 2 {
+			delete(c.players, data[1])
+		}
+	case protocol.PacketTypeKillAction:
+		c.handleKillAction(data)
+	case protocol.PacketTypeSetHP:
+		c.handleSetHP(data)
+	case protocol.PacketTypeWorldUpdate:
+		c.handleWorldUpdate(data)
+	case protocol.PacketTypePositionData:
+		c.handlePositionData(data)
+	case protocol.PacketTypeSetColor:
+		c.handleSetColor(data)
+	case protocol.PacketTypeBlockAction:
+		c.handleBlockAction(data)
+	case protocol.PacketTypeBlockLine:
+		c.handleBlockLine(data)
+	case protocol.PacketTypeChatMessage:
+		c.handleChatMessage(data)
+	}
+
+	for _, fn := range c.handlers[packetType] {
+		fn(data)
+	}
+}
+
+func (c *Client) handleMapStart(data []byte) {
+	if len(data) < 5 {
+		return
+	}
+	c.mapSize = binary.LittleEndian.Uint32(data[1:5])
+	c.mapData.Reset()
+	c.vxlMap = nil
+	c.joined = false
+	c.alive = false
+	c.players = make(map[uint8]*PlayerInfo)
+
+	// we keep no map cache, so 0.76 servers always have to send it
+	if c.opts.ProtocolVersion == protocol.ProtocolVersion76 {
+		c.send(&protocol.PacketMapCached{
+			PacketID: uint8(protocol.PacketTypeMapCached),
+		}, true)
+	}
+}
+
+// the server sends state data right after the last map chunk, so that is
+// when the downloaded map gets decoded
+func (c *Client) handleStateData(data []byte) {
+	var packet protocol.PacketStateData
+	if err := packet.Read(data); err != nil {
+		c.logger.Warn("invalid state data", "error", err)
+		return
+	}
+	c.state = packet
+	c.stateSeen = true
+	c.playerID = packet.PlayerID
+
+	if c.vxlMap == nil && c.mapData.Len() > 0 {
+		m, err := decodeMap(c.mapData.Bytes())
+		if err != nil {
+			c.logger.Error("failed to decode map", "error", err)
+			return
+		}
+		c.vxlMap = m
+		c.mapData.Reset()
+		c.logger.Debug("map loaded", "compressed_size", c.mapSize)
+	}
+}
+
+func decodeMap(compressed []byte) (*vxl.Map, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open map stream: %w", err)
+	}
+	defer zr.Close()
+
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress map: %w", err)
+	}
+
+	size, depth, err := vxl.Size(raw)
+	if err != nil {
+		return nil, err
+	}
+	return vxl.Create(size, size, depth, raw)
+}
+
+func (c *Client) handleHandshakeInit(data []byte) {
+	if len(data) < 5 {
+		return
+	}
+	c.send(&protocol.PacketHandShakeReturn{
+		PacketID:  uint8(protocol.PacketTypeHandShakeReturn),
+		Challenge: binary.LittleEndian.Uint32(data[1:5]),
+	}, true)
+}
+
+func (c *Client) sendVersionResponse() {
+	c.send(&protocol.PacketVersionResponse{
+		PacketID:         uint8(protocol.PacketTypeVersionResponse),
+		ClientIdentifier: c.opts.ClientIdentifier,
+		VersionMajor:     c.opts.VersionMajor,
+		VersionMinor:     c.opts.VersionMinor,
+		VersionRevision:  c.opts.VersionRevision,
+		OSInfo:           c.opts.OSInfo,
+	}, true)
+}
+
+func (c *Client) handleExtensionInfo(data []byte) {
+	var packet protocol.PacketExtensionInfo
+	if err := packet.Read(data); err != nil {
+		c.logger.Warn("invalid extension info", "error", err)
+		return
+	}
+	for _, entry := range packet.Entries {
+		c.serverExtensions[entry.ExtensionID] = entry.ExtensionVersion
+	}
+
+	reply := protocol.PacketExtensionInfo{
+		PacketID: uint8(protocol.PacketTypeExtensionInfo),
+		Length:   uint8(len(c.opts.Extensions)),
+		Entries:  c.opts.Extensions,
+	}
+	c.send(&reply, true)
+}
+
+func (c *Client) handleExistingPlayer(data []byte) {
+	var packet protocol.PacketExistingPlayer
+	if err := readFixed(data, &packet); err != nil {
+		return
+	}
+	p := c.playerInfo(packet.PlayerID)
+	p.Name = decodeName(packet.Name[:])
+	p.Team = packet.Team
+	p.Weapon = packet.Weapon
+	p.Color = packet.Color
+	p.Kills = packet.Kills
+}
+
+func (c *Client) handleCreatePlayer(data []byte) {
+	var packet protocol.PacketCreatePlayer
+	if err := readFixed(data, &packet); err != nil {
+		return
+	}
+	p := c.playerInfo(packet.PlayerID)
+	p.Name = decodeName(packet.Name[:])
+	p.Team = packet.Team
+	p.Weapon = packet.Weapon
+	p.Alive = true
+	p.Position = protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
+
+	if packet.PlayerID == c.playerID && c.joined {
+		c.alive = true
+		c.hp = protocol.InitialHP
+		c.position = p.Position
+	}
+}
+
+func (c *Client) handleShortPlayerData(data []byte) {
+	var packet protocol.PacketShortPlayerData
+	if err := readFixed(data, &packet); err != nil {
+		return
+	}
+	p := c.playerInfo(packet.PlayerID)
+	p.Team = packet.Team
+	p.Weapon = packet.Weapon
+}
+
+func (c *Client) handleKillAction(data []byte) {
+	var packet protocol.PacketKillAction
+	if err := readFixed(data, &packet); err != nil {
+		return
+	}
+	if victim, ok := c.players[packet.PlayerID]; ok {
+		victim.Alive = false
+	}
+	if killer, ok := c.players[packet.KillerID]; ok && packet.KillerID != packet.PlayerID {
+		killer.Kills++
+	}
+	if packet.PlayerID == c.playerID {
+		c.alive = false
+		c.hp = 0
+	}
+}
+
+func (c *Client) handleSetHP(data []byte) {
+	var packet protocol.PacketSetHP
+	if err := readFixed(data, &packet); err != nil {
+		return
+	}
+	c.hp = packet.HP
+}
+
+func (c *Client) handleWorldUpdate(data []byte) {
+	if c.negotiated(protocol.ExtensionID256Players) || c.opts.ProtocolVersion == protocol.ProtocolVersion76 {
+		var packet protocol.PacketWorldUpdateExtended
+		if err := packet.Read(data); err != nil {
+			return
+		}
+		for _, entry := range packet.Players {
+			p, ok := c.players[entry.PlayerID]
+			if !ok || entry.PlayerID == c.playerID {
+				continue
+			}
+			p.Position = protocol.Vector3f{X: entry.X, Y: entry.Y, Z: entry.Z}
+			p.Orientation = protocol.Vector3f{X: entry.OX, Y: entry.OY, Z: entry.OZ}
+		}
+		return
+	}
+
+	var packet protocol.PacketWorldUpdate
+	if err := packet.Read(bytes.NewReader(data)); err != nil {
+		return
+	}
+	for id, p := range c.players {
+		if int(id) >= len(packet.Players) || id == c.playerID {
+			continue
+		}
+		entry := packet.Players[id]
+		p.Position = protocol.Vector3f{X: entry.X, Y: entry.Y, Z: entry.Z}
+		p.Orientation = protocol.Vector3f{X: entry.OX, Y: entry.OY, Z: entry.OZ}
+	}
+}
+
+// the server only sends us position data to correct or teleport us
+func (c *Client) handlePositionData(data []byte) {
+	var packet protocol.PacketPositionData
+	if err := packet.Read(bytes.NewReader(data)); err != nil {
+		return
+	}
+	c.position = protocol.Vector3f{X: packet.X, Y: packet.Y, Z: packet.Z}
+}
+
+func (c *Client) handleSetColor(data []byte) {
+	var packet protocol.PacketSetColor
+	if err := readFixed(data, &packet); err != nil {
+		return
+	}
+	c.playerInfo(packet.PlayerID).Color = packet.Color
+}
+
+func (c *Client) handleBlockAction(data []byte) {
+	var packet protocol.PacketBlockAction
+	if err := readFixed(data, &packet); err != nil || c.vxlMap == nil {
+		return
+	}
+	x, y, z := int(packet.X), int(packet.Y), int(packet.Z)
+
+	switch packet.Action {
+	case protocol.BlockActionTypeBuild:
+		c.vxlMap.Set(x, y, z, c.blockColor(packet.PlayerID))
+	case protocol.BlockActionTypeSpadeGunDestroy:
+		c.removeBlock(x, y, z)
+	case protocol.BlockActionTypeSpadeSecondaryDestroy:
+		for dz := -1; dz <= 1; dz++ {
+			c.removeBlock(x, y, z+dz)
+		}
+	case protocol.BlockActionTypeGrenadeDestroy:
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					c.removeBlock(x+dx, y+dy, z+dz)
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) handleBlockLine(data []byte) {
+	var packet protocol.PacketBlockLine
+	if err := readFixed(data, &packet); err != nil || c.vxlMap == nil {
+		return
+	}
+	color := c.blockColor(packet.PlayerID)
+	for _, block := range blockLine(
+		int(packet.StartX), int(packet.StartY), int(packet.StartZ),
+		int(packet.EndX), int(packet.EndY), int(packet.EndZ),
+	) {
+		if c.vxlMap.IsInside(block[0], block[1], block[2]) {
+			c.vxlMap.Set(block[0], block[1], block[2], color)
+		}
+	}
+}
+
+func (c *Client) handleChatMessage(data []byte) {
+	var packet protocol.PacketChatMessage
+	if err := packet.Read(data); err != nil {
+		return
+	}
+	message, err := protocol.CP437ToString(packet.Message)
+	if err != nil {
+		return
+	}
+	for _, fn := range c.chatHandlers {
+		fn(packet.PlayerID, packet.Type, message)
+	}
+}
+
+func (c *Client) removeBlock(x, y, z int) {
+	if z >= c.vxlMap.Depth()-1 || !c.vxlMap.IsInside(x, y, z) {
+		return
+	}
+	c.vxlMap.SetAir(x, y, z)
+}
+
+func (c *Client) blockColor(playerID uint8) uint32 {
+	p, ok := c.players[playerID]
+	if !ok {
+		return defaultBlockColor
+	}
+	return uint32(p.Color.R)<<16 | uint32(p.Color.G)<<8 | uint32(p.Color.B)
+}
+
+func (c *Client) playerInfo(id uint8) *PlayerInfo {
+	p, ok := c.players[id]
+	if !ok {
+		p = &PlayerInfo{ID: id}
+		c.players[id] = p
+	}
+	return p
+}
+
+func (c *Client) send(packet interface{}, reliable bool) error {
+	if !c.connected {
+		return ErrDisconnected
+	}
+
+	var buf bytes.Buffer
+	if writer, ok := packet.(interface{ Write(io.Writer) error }); ok {
+		if err := writer.Write(&buf); err != nil {
+			return err
+		}
+	} else if err := binary.Write(&buf, binary.LittleEndian, packet); err != nil {
+		return err
+	}
+
+	return c.conn.Send(buf.Bytes(), reliable)
+}
+
+func readFixed(data []byte, packet interface{}) error {
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, packet)
+}
+
+func decodeName(raw []byte) string {
+	name, err := protocol.CP437ToString(raw)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// blockLine matches the interpolation the server uses for block lines
+func blockLine(x1, y1, z1, x2, y2, z2 int) [][3]int {
+	steps := max(abs(x2-x1), abs(y2-y1), abs(z2-z1))
+	if steps == 0 {
+		return [][3]int{{x1, y1, z1}}
+	}
+
+	blocks := make([][3]int, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		blocks = append(blocks, [3]int{
+			x1 + (x2-x1)*i/steps,
+			y1 + (y2-y1)*i/steps,
+			z1 + (z2-z1)*i/steps,
+		})
+	}
+	return blocks
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"github.com/siohaza/fosilo/internal/network"
+	"github.com/siohaza/fosilo/internal/protocol"
+)
+
+// The protocol and transport definitions live in internal packages, these
+// aliases are what code outside the module uses to talk to a Client
+
+type (
+	Vector3f       = protocol.Vector3f
+	Color3b        = protocol.Color3b
+	PacketType     = protocol.PacketType
+	ExtensionID    = protocol.ExtensionID
+	ExtensionEntry = protocol.ExtensionEntry
+	WeaponType     = protocol.WeaponType
+	ItemType       = protocol.ItemType
+	HitType        = protocol.HitType
+	ChatType       = protocol.ChatType
+	KeyState       = protocol.KeyState
+	WeaponInput    = protocol.WeaponInput
+	GamemodeType   = protocol.GamemodeType
+	StateData      = protocol.PacketStateData
+	CTFStateData   = protocol.CTFStateData
+	TCStateData    = protocol.TCStateData
+	Territory      = protocol.Territory
+
+	// Event and EventType are what a custom Conn hands back from Service
+	Event     = network.Event
+	EventType = network.EventType
+)
+
+const (
+	ProtocolVersion75 = protocol.ProtocolVersion75
+	ProtocolVersion76 = protocol.ProtocolVersion76
+)
+
+const (
+	EventTypeNone       = network.EventTypeNone
+	EventTypeConnect    = network.EventTypeConnect
+	EventTypeDisconnect = network.EventTypeDisconnect
+	EventTypeReceive    = network.EventTypeReceive
+)
+
+const (
+	PacketTypePositionData     = protocol.PacketTypePositionData
+	PacketTypeOrientationData  = protocol.PacketTypeOrientationData
+	PacketTypeWorldUpdate      = protocol.PacketTypeWorldUpdate
+	PacketTypeInputData        = protocol.PacketTypeInputData
+	PacketTypeWeaponInput      = protocol.PacketTypeWeaponInput
+	PacketTypeHit              = protocol.PacketTypeHit
+	PacketTypeSetHP            = protocol.PacketTypeSetHP
+	PacketTypeGrenade          = protocol.PacketTypeGrenade
+	PacketTypeSetTool          = protocol.PacketTypeSetTool
+	PacketTypeSetColor         = protocol.PacketTypeSetColor
+	PacketTypeExistingPlayer   = protocol.PacketTypeExistingPlayer
+	PacketTypeShortPlayerData  = protocol.PacketTypeShortPlayerData
+	PacketTypeMoveObject       = protocol.PacketTypeMoveObject
+	PacketTypeCreatePlayer     = protocol.PacketTypeCreatePlayer
+	PacketTypeBlockAction      = protocol.PacketTypeBlockAction
+	PacketTypeBlockLine        = protocol.PacketTypeBlockLine
+	PacketTypeStateData        = protocol.PacketTypeStateData
+	PacketTypeKillAction       = protocol.PacketTypeKillAction
+	PacketTypeChatMessage      = protocol.PacketTypeChatMessage
+	PacketTypeMapStart         = protocol.PacketTypeMapStart
+	PacketTypeMapChunk         = protocol.PacketTypeMapChunk
+	PacketTypePlayerLeft       = protocol.PacketTypePlayerLeft
+	PacketTypeTerritoryCapture = protocol.PacketTypeTerritoryCapture
+	PacketTypeProgressBar      = protocol.PacketTypeProgressBar
+	PacketTypeIntelCapture     = protocol.PacketTypeIntelCapture
+	PacketTypeIntelPickup      = protocol.PacketTypeIntelPickup
+	PacketTypeIntelDrop        = protocol.PacketTypeIntelDrop
+	PacketTypeRestock          = protocol.PacketTypeRestock
+	PacketTypeFogColor         = protocol.PacketTypeFogColor
+	PacketTypeWeaponReload     = protocol.PacketTypeWeaponReload
+	PacketTypeChangeTeam       = protocol.PacketTypeChangeTeam
+	PacketTypeChangeWeapon     = protocol.PacketTypeChangeWeapon
+	PacketTypeExtensionInfo    = protocol.PacketTypeExtensionInfo
+	PacketTypePlayerProperties = protocol.PacketTypePlayerProperties
+)
+
+const (
+	ExtensionIDPlayerProperties = protocol.ExtensionIDPlayerProperties
+	ExtensionID256Players       = protocol.ExtensionID256Players
+	ExtensionIDMessageTypes     = protocol.ExtensionIDMessageTypes
+	ExtensionIDKickReason       = protocol.ExtensionIDKickReason
+)
+
+const (
+	WeaponTypeRifle   = protocol.WeaponTypeRifle
+	WeaponTypeSMG     = protocol.WeaponTypeSMG
+	WeaponTypeShotgun = protocol.WeaponTypeShotgun
+)
+
+const (
+	ItemTypeSpade   = protocol.ItemTypeSpade
+	ItemTypeBlock   = protocol.ItemTypeBlock
+	ItemTypeGun     = protocol.ItemTypeGun
+	ItemTypeGrenade = protocol.ItemTypeGrenade
+)
+
+const (
+	HitTypeTorso = protocol.HitTypeTorso
+	HitTypeHead  = protocol.HitTypeHead
+	HitTypeArms  = protocol.HitTypeArms
+	HitTypeLegs  = protocol.HitTypeLegs
+	HitTypeMelee = protocol.HitTypeMelee
+)
+
+const (
+	ChatTypeAll     = protocol.ChatTypeAll
+	ChatTypeTeam    = protocol.ChatTypeTeam
+	ChatTypeSystem  = protocol.ChatTypeSystem
+	ChatTypeBig     = protocol.ChatTypeBig
+	ChatTypeInfo    = protocol.ChatTypeInfo
+	ChatTypeWarning = protocol.ChatTypeWarning
+	ChatTypeError   = protocol.ChatTypeError
+)
+
+const (
+	KeyStateForward  = protocol.KeyStateForward
+	KeyStateBackward = protocol.KeyStateBackward
+	KeyStateLeft     = protocol.KeyStateLeft
+	KeyStateRight    = protocol.KeyStateRight
+	KeyStateJump     = protocol.KeyStateJump
+	KeyStateCrouch   = protocol.KeyStateCrouch
+	KeyStateSneak    = protocol.KeyStateSneak
+	KeyStateSprint   = protocol.KeyStateSprint
+)
+
+const (
+	WeaponInputPrimary   = protocol.WeaponInputPrimary
+	WeaponInputSecondary = protocol.WeaponInputSecondary
+)
+
+const (
+	GamemodeTypeCTF = protocol.GamemodeTypeCTF
+	GamemodeTypeTC  = protocol.GamemodeTypeTC
+)
@@ -8,12 +8,20 @@ import (
 )
 
 type Config struct {
-	Server    ServerConfig
-	Teams     TeamsConfig
-	Passwords PasswordsConfig
-	RateLimit RateLimitConfig
-	Voting    VotingConfig
-	Gamemode  GamemodeConfig `toml:"gamemode"`
+	Server     ServerConfig
+	Teams      TeamsConfig
+	Passwords  PasswordsConfig
+	RateLimit  RateLimitConfig
+	AntiCheat  AntiCheatConfig       `toml:"anticheat"`
+	Bots       BotsConfig            `toml:"bots"`
+	Demo       DemoConfig            `toml:"demo"`
+	Relay      RelayConfig           `toml:"relay"`
+	Violations ViolationsConfig      `toml:"violations"`
+	Bans       BansConfig            `toml:"bans"`
+	Accounts   AccountsConfig        `toml:"accounts"`
+	Roles      map[string]RoleConfig `toml:"roles"`
+	Voting     VotingConfig
+	Gamemode   GamemodeConfig `toml:"gamemode"`
 }
 
 type MasterHost struct {
@@ -35,7 +43,8 @@ type ServerConfig struct {
 	RespawnTime      int          `toml:"respawn_time"`
 
 	// logging configuration
-	LogToFile bool `toml:"log_to_file"`
+	LogToFile bool   `toml:"log_to_file"`
+	AuditLog  string `toml:"audit_log"`
 
 	// ctf specific
 	CaptureTimeBonus float64 `toml:"capture_time_bonus"`
@@ -69,6 +78,12 @@ type ServerConfig struct {
 	LabyHogTimeout int     `toml:"laby_hog_timeout"`
 	LabyRegenRate  float64 `toml:"laby_regen_rate"`
 
+	// push specific
+	PushIntelReturnTime float64 `toml:"push_intel_return_time"`
+
+	// murderball specific
+	MurderballResetTime float64 `toml:"murderball_reset_time"`
+
 	// vxlgen tower metadata which is set dynamically after generation
 	TowerPosX   int `toml:"-"`
 	TowerPosY   int `toml:"-"`
@@ -95,6 +110,29 @@ type PasswordsConfig struct {
 	Trusted   string `toml:"trusted"`
 }
 
+// AccountsConfig turns on registered accounts. A registered name is reserved
+// for its owner, and the role of an account replaces the shared passwords
+type AccountsConfig struct {
+	Enabled bool `toml:"enabled"`
+	// keep the role passwords above working next to accounts
+	SharedPasswords bool `toml:"shared_passwords"`
+	// what happens to a player still using someone else's name after the
+	// grace period, rename or kick
+	ReservedNameAction string `toml:"reserved_name_action"`
+	GracePeriod        int    `toml:"grace_period"` // seconds
+	MinPasswordLength  int    `toml:"min_password_length"`
+}
+
+// RoleConfig defines a named role. It gets the commands of the role it
+// inherits, a built-in tier or another named role, and the allow and deny
+// lists then grant or take away single commands, "*" meaning all of them.
+// Naming a built-in tier only adds the lists to it
+type RoleConfig struct {
+	Inherits string   `toml:"inherits"`
+	Allow    []string `toml:"allow"`
+	Deny     []string `toml:"deny"`
+}
+
 type RateLimitConfig struct {
 	Enabled               bool `toml:"enabled"`
 	PacketsPerSecond      int  `toml:"packets_per_second"`
@@ -104,6 +142,103 @@ type RateLimitConfig struct {
 	BlockPacketsPerSec    int  `toml:"block_packets_per_sec"`
 }
 
+type AntiCheatConfig struct {
+	// rewind hit targets by the shooter's round trip time
+	LagCompensation bool `toml:"lag_compensation"`
+	// 1 to 1000, left out it is 300
+	MaxRewindMs int `toml:"max_rewind_ms"`
+
+	// move enemies a player has no line of sight to out of their world
+	// updates, teammates and spectators always get everyone
+	ESPCulling      bool    `toml:"esp_culling"`
+	ESPViewDistance float64 `toml:"esp_view_distance"`
+	// enemies stay visible this long after the last successful check
+	ESPGraceMs int `toml:"esp_grace_ms"`
+	// line of sight rays allowed per world update across all players
+	ESPChecksPerTick int `toml:"esp_checks_per_tick"`
+
+	// statistical aimbot and triggerbot detection, outliers are logged and
+	// handled according to AimAction. Left out or zero, a threshold takes its
+	// default, a negative one turns that check off
+	AimDetection     bool    `toml:"aim_detection"`
+	AimMinSamples    int     `toml:"aim_min_samples"`
+	AimSnapSpeed     float64 `toml:"aim_snap_speed"`
+	AimSnapRatio     float64 `toml:"aim_snap_ratio"`
+	AimHeadshotRatio float64 `toml:"aim_headshot_ratio"`
+	AimMinReactionMs int     `toml:"aim_min_reaction_ms"`
+	AimMaxAccuracy   float64 `toml:"aim_max_accuracy"`
+	AimAction        string  `toml:"aim_action"`
+	AimBanMinutes    int     `toml:"aim_ban_minutes"`
+
+	// compare reported positions against the server's own simulation and
+	// pull players back once MovementStrikes reports in a row diverge,
+	// repeat offenders are left to the violation ledger
+	MovementValidation bool    `toml:"movement_validation"`
+	MovementTolerance  float64 `toml:"movement_tolerance"`
+	MovementStrikes    int     `toml:"movement_strikes"`
+
+	// track block health from spade swings and bullets and only accept
+	// destroys of blocks within reach that were worn down
+	BlockDamage bool `toml:"block_damage"`
+}
+
+type BotsConfig struct {
+	Enabled bool `toml:"enabled"`
+	// bots fill the server until humans plus bots reach this count
+	TargetPlayers int    `toml:"target_players"`
+	NamePrefix    string `toml:"name_prefix"`
+	// left out these are 0.5 and 400, a 0 set in the config is kept
+	Accuracy   float64 `toml:"accuracy"`
+	ReactionMs int     `toml:"reaction_ms"`
+}
+
+type DemoConfig struct {
+	// record every match, admins can still start and stop recordings by hand
+	Enabled   bool   `toml:"enabled"`
+	Directory string `toml:"directory"`
+}
+
+type RelayConfig struct {
+	Enabled bool `toml:"enabled"`
+	// defaults to the game port + 2, the port above the game is the ping handler
+	Port int `toml:"port"`
+	// seconds the relay runs behind the live match
+	Delay      int `toml:"delay"`
+	MaxViewers int `toml:"max_viewers"`
+}
+
+// ViolationsConfig drives the per player violation ledger, every rejected
+// packet adds the weight of its category to a score that halves every
+// HalfLife seconds, and each ladder step fires once the score reaches it
+type ViolationsConfig struct {
+	Enabled  bool               `toml:"enabled"`
+	HalfLife int                `toml:"half_life"`
+	Weights  map[string]float64 `toml:"weights"`
+	Ladder   []ViolationStep    `toml:"ladder"`
+}
+
+type ViolationStep struct {
+	Score  float64 `toml:"score"`
+	Action string  `toml:"action"` // warn, kick or ban
+	// ban length, 0 bans permanently
+	Minutes int `toml:"minutes"`
+}
+
+// BansConfig subscribes to ban lists other servers share. Subscribed bans
+// are read-only and apply alongside our own
+type BansConfig struct {
+	Subscriptions []BanSubscription `toml:"subscriptions"`
+	// addresses, ranges, names and patterns subscribed bans do not apply to
+	Exclude []string `toml:"exclude"`
+}
+
+type BanSubscription struct {
+	Name     string `toml:"name"`
+	Location string `toml:"location"` // http(s) URL or file path
+	Format   string `toml:"format"`   // fosilo, pyspades or piqueserver
+	Refresh  int    `toml:"refresh"`  // seconds between fetches
+}
+
 type VotingConfig struct {
 	VotekickEnabled     bool `toml:"votekick_enabled"`
 	VotekickPercentage  int  `toml:"votekick_percentage"`
@@ -117,12 +252,14 @@ type VotingConfig struct {
 }
 
 type GamemodeConfig struct {
-	CTF   *CTFConfig   `toml:"ctf"`
-	TC    *TCConfig    `toml:"tc"`
-	TDM   *TDMConfig   `toml:"tdm"`
-	Babel *BabelConfig `toml:"babel"`
-	Arena *ArenaConfig `toml:"arena"`
-	Laby  *LabyConfig  `toml:"laby"`
+	CTF        *CTFConfig        `toml:"ctf"`
+	TC         *TCConfig         `toml:"tc"`
+	TDM        *TDMConfig        `toml:"tdm"`
+	Babel      *BabelConfig      `toml:"babel"`
+	Arena      *ArenaConfig      `toml:"arena"`
+	Laby       *LabyConfig       `toml:"laby"`
+	Push       *PushConfig       `toml:"push"`
+	Murderball *MurderballConfig `toml:"murderball"`
 }
 
 type CTFConfig struct {
@@ -164,6 +301,16 @@ type LabyConfig struct {
 	RegenRate  *float64 `toml:"regen_rate"`
 }
 
+type PushConfig struct {
+	CaptureLimit    *int     `toml:"capture_limit"`
+	IntelReturnTime *float64 `toml:"intel_return_time"`
+}
+
+type MurderballConfig struct {
+	CaptureLimit *int     `toml:"capture_limit"`
+	ResetTime    *float64 `toml:"reset_time"`
+}
+
 type MapConfig struct {
 	Map          MapInfo           `toml:"map"`
 	SpawnPoints  SpawnPointsConfig `toml:"spawnpoints"`
@@ -497,7 +644,10 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	if err := toml.Unmarshal(data, &config); err != nil {
+	// the metadata tells a setting left out from one set to zero, for the
+	// few where zero means something
+	md, err := toml.Decode(string(data), &config)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
@@ -579,6 +729,13 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	// murderball defaults
+	if config.Server.Gamemode == 7 {
+		if config.Server.MurderballResetTime == 0 {
+			config.Server.MurderballResetTime = 10.0
+		}
+	}
+
 	if config.Server.Master && len(config.Server.MasterHosts) == 0 {
 		config.Server.MasterHosts = []MasterHost{
 			{Host: "master.buildandshoot.com", Port: 32886},
@@ -604,6 +761,129 @@ func LoadConfig(path string) (*Config, error) {
 		config.RateLimit.BlockPacketsPerSec = 30
 	}
 
+	// anticheat defaults
+	if !md.IsDefined("anticheat", "max_rewind_ms") {
+		config.AntiCheat.MaxRewindMs = 300
+	}
+	if config.AntiCheat.ESPViewDistance == 0 {
+		config.AntiCheat.ESPViewDistance = 135
+	}
+	if config.AntiCheat.ESPGraceMs == 0 {
+		config.AntiCheat.ESPGraceMs = 500
+	}
+	if config.AntiCheat.ESPChecksPerTick == 0 {
+		config.AntiCheat.ESPChecksPerTick = 512
+	}
+	if config.AntiCheat.AimMinSamples == 0 {
+		config.AntiCheat.AimMinSamples = 30
+	}
+	if config.AntiCheat.AimSnapSpeed == 0 {
+		config.AntiCheat.AimSnapSpeed = 1800
+	}
+	if config.AntiCheat.AimSnapRatio == 0 {
+		config.AntiCheat.AimSnapRatio = 0.5
+	}
+	if config.AntiCheat.AimHeadshotRatio == 0 {
+		config.AntiCheat.AimHeadshotRatio = 0.75
+	}
+	if config.AntiCheat.AimMinReactionMs == 0 {
+		config.AntiCheat.AimMinReactionMs = 100
+	}
+	if config.AntiCheat.AimMaxAccuracy == 0 {
+		config.AntiCheat.AimMaxAccuracy = 0.85
+	}
+	if config.AntiCheat.AimAction == "" {
+		config.AntiCheat.AimAction = "notify"
+	}
+	if config.AntiCheat.AimBanMinutes == 0 {
+		config.AntiCheat.AimBanMinutes = 60
+	}
+	if config.AntiCheat.MovementTolerance == 0 {
+		config.AntiCheat.MovementTolerance = 2
+	}
+	if config.AntiCheat.MovementStrikes == 0 {
+		config.AntiCheat.MovementStrikes = 3
+	}
+
+	// bot defaults
+	if config.Bots.TargetPlayers == 0 {
+		config.Bots.TargetPlayers = 8
+	}
+	if config.Bots.NamePrefix == "" {
+		config.Bots.NamePrefix = "Bot"
+	}
+	if !md.IsDefined("bots", "accuracy") {
+		config.Bots.Accuracy = 0.5
+	}
+	if !md.IsDefined("bots", "reaction_ms") {
+		config.Bots.ReactionMs = 400
+	}
+
+	if config.Demo.Directory == "" {
+		config.Demo.Directory = "demos"
+	}
+
+	if config.Relay.Port == 0 {
+		config.Relay.Port = config.Server.Port + 2
+	}
+	if config.Relay.Delay == 0 {
+		config.Relay.Delay = 60
+	}
+	if config.Relay.MaxViewers == 0 {
+		config.Relay.MaxViewers = 32
+	}
+
+	if config.Violations.HalfLife == 0 {
+		config.Violations.HalfLife = 300
+	}
+	defaultWeights := map[string]float64{
+		"rate_limit":   1,
+		"block_quota":  1,
+		"weapon":       2,
+		"movement":     4,
+		"block_damage": 2,
+		"aim":          10,
+	}
+	if config.Violations.Weights == nil {
+		config.Violations.Weights = make(map[string]float64)
+	}
+	for category, weight := range defaultWeights {
+		if _, ok := config.Violations.Weights[category]; !ok {
+			config.Violations.Weights[category] = weight
+		}
+	}
+	if len(config.Violations.Ladder) == 0 {
+		config.Violations.Ladder = []ViolationStep{
+			{Score: 10, Action: "warn"},
+			{Score: 20, Action: "kick"},
+			{Score: 40, Action: "ban", Minutes: 60},
+			{Score: 80, Action: "ban"},
+		}
+	}
+
+	if config.Accounts.ReservedNameAction == "" {
+		config.Accounts.ReservedNameAction = "rename"
+	}
+	if config.Accounts.GracePeriod == 0 {
+		config.Accounts.GracePeriod = 60
+	}
+	if config.Accounts.MinPasswordLength == 0 {
+		config.Accounts.MinPasswordLength = 6
+	}
+
+	for i := range config.Bans.Subscriptions {
+		sub := &config.Bans.Subscriptions[i]
+		if sub.Name == "" {
+			sub.Name = sub.Location
+		}
+		if sub.Format == "" {
+			sub.Format = "piqueserver"
+		}
+		if sub.Refresh == 0 {
+			sub.Refresh = 600
+		}
+	}
+
 	// voting defaults
 	if config.Voting.VotekickPercentage == 0 {
 		config.Voting.VotekickPercentage = 35
@@ -697,6 +977,24 @@ func (c *Config) applyGamemodeOverrides() {
 		}
 	}
 
+	if c.Gamemode.Push != nil {
+		if c.Gamemode.Push.CaptureLimit != nil {
+			c.Server.CaptureLimit = *c.Gamemode.Push.CaptureLimit
+		}
+		if c.Gamemode.Push.IntelReturnTime != nil {
+			c.Server.PushIntelReturnTime = *c.Gamemode.Push.IntelReturnTime
+		}
+	}
+
+	if c.Gamemode.Murderball != nil {
+		if c.Gamemode.Murderball.CaptureLimit != nil {
+			c.Server.CaptureLimit = *c.Gamemode.Murderball.CaptureLimit
+		}
+		if c.Gamemode.Murderball.ResetTime != nil {
+			c.Server.MurderballResetTime = *c.Gamemode.Murderball.ResetTime
+		}
+	}
+
 	if c.Gamemode.TC != nil {
 		if c.Gamemode.TC.MaxScore != nil {
 			c.Server.TCMaxScore = *c.Gamemode.TC.MaxScore
@@ -958,14 +1256,114 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid port: %d", c.Server.Port)
 	}
 
-	if c.Server.MaxPlayers <= 0 || c.Server.MaxPlayers > 32 {
-		return fmt.Errorf("max_players must be between 1 and 32")
+	if c.Server.MaxPlayers <= 0 || c.Server.MaxPlayers > 255 {
+		return fmt.Errorf("max_players must be between 1 and 255")
 	}
 
 	if len(c.Server.Maps) == 0 {
 		return fmt.Errorf("at least one map must be specified")
 	}
 
+	// lag_compensation = false is how rewinding is turned off
+	if c.AntiCheat.MaxRewindMs < 1 || c.AntiCheat.MaxRewindMs > 1000 {
+		return fmt.Errorf("anticheat max_rewind_ms must be between 1 and 1000")
+	}
+
+	if c.AntiCheat.ESPViewDistance < 0 || c.AntiCheat.ESPGraceMs < 0 || c.AntiCheat.ESPChecksPerTick < 0 {
+		return fmt.Errorf("anticheat esp settings cannot be negative")
+	}
+
+	switch c.AntiCheat.AimAction {
+	case "log", "notify", "kick", "ban":
+	default:
+		return fmt.Errorf("anticheat aim_action must be log, notify, kick or ban")
+	}
+	if c.AntiCheat.AimMinSamples < 1 {
+		return fmt.Errorf("anticheat aim_min_samples must be positive")
+	}
+	if c.AntiCheat.AimBanMinutes < 0 {
+		return fmt.Errorf("anticheat aim_ban_minutes cannot be negative")
+	}
+	if c.AntiCheat.MovementTolerance < 0 || c.AntiCheat.MovementStrikes < 1 {
+		return fmt.Errorf("anticheat movement settings must be positive")
+	}
+	for _, ratio := range []float64{c.AntiCheat.AimSnapRatio, c.AntiCheat.AimHeadshotRatio, c.AntiCheat.AimMaxAccuracy} {
+		if ratio > 1 {
+			return fmt.Errorf("anticheat aim ratios cannot be above 1")
+		}
+	}
+
+	if c.Bots.TargetPlayers < 0 || c.Bots.TargetPlayers > c.Server.MaxPlayers {
+		return fmt.Errorf("bots target_players must be between 0 and max_players")
+	}
+
+	if c.Bots.Accuracy < 0 || c.Bots.Accuracy > 1 {
+		return fmt.Errorf("bots accuracy must be between 0 and 1")
+	}
+
+	if c.Relay.Enabled {
+		if c.Relay.Port <= 0 || c.Relay.Port > 65535 {
+			return fmt.Errorf("invalid relay port: %d", c.Relay.Port)
+		}
+		if c.Relay.Port == c.Server.Port || c.Relay.Port == c.Server.Port+1 {
+			return fmt.Errorf("relay port %d clashes with the game or ping port", c.Relay.Port)
+		}
+		if c.Relay.Delay < 0 {
+			return fmt.Errorf("relay delay cannot be negative")
+		}
+		if c.Relay.MaxViewers <= 0 || c.Relay.MaxViewers > 255 {
+			return fmt.Errorf("relay max_viewers must be between 1 and 255")
+		}
+	}
+
+	if c.Violations.HalfLife < 0 {
+		return fmt.Errorf("violations half_life cannot be negative")
+	}
+	for i, step := range c.Violations.Ladder {
+		switch step.Action {
+		case "warn", "kick", "ban":
+		default:
+			return fmt.Errorf("violations ladder step %d: action must be warn, kick or ban", i+1)
+		}
+		if step.Score <= 0 || step.Minutes < 0 {
+			return fmt.Errorf("violations ladder step %d: score must be positive and minutes not negative", i+1)
+		}
+		if i > 0 && step.Score <= c.Violations.Ladder[i-1].Score {
+			return fmt.Errorf("violations ladder scores must increase")
+		}
+	}
+
+	switch c.Accounts.ReservedNameAction {
+	case "rename", "kick":
+	default:
+		return fmt.Errorf("accounts reserved_name_action must be rename or kick")
+	}
+	if c.Accounts.GracePeriod < 0 {
+		return fmt.Errorf("accounts grace_period cannot be negative")
+	}
+	if c.Accounts.MinPasswordLength < 0 {
+		return fmt.Errorf("accounts min_password_length cannot be negative")
+	}
+
+	names := make(map[string]bool)
+	for i, sub := range c.Bans.Subscriptions {
+		if sub.Location == "" {
+			return fmt.Errorf("ban subscription %d: location cannot be empty", i+1)
+		}
+		switch sub.Format {
+		case "fosilo", "pyspades", "piqueserver":
+		default:
+			return fmt.Errorf("ban subscription %q: format must be fosilo, pyspades or piqueserver", sub.Name)
+		}
+		if sub.Refresh < 0 {
+			return fmt.Errorf("ban subscription %q: refresh cannot be negative", sub.Name)
+		}
+		if names[sub.Name] {
+			return fmt.Errorf("ban subscription %q is listed twice", sub.Name)
+		}
+		names[sub.Name] = true
+	}
+
 	if c.Teams.Team1.Name == "" || c.Teams.Team2.Name == "" {
 		return fmt.Errorf("team names cannot be empty")
 	}
@@ -976,12 +1374,14 @@ func (c *Config) Validate() error {
 type GamemodeID int
 
 const (
-	GamemodeCTF   GamemodeID = 0
-	GamemodeTC    GamemodeID = 1
-	GamemodeBabel GamemodeID = 2
-	GamemodeTDM   GamemodeID = 3
-	GamemodeArena GamemodeID = 4
-	GamemodeLaby  GamemodeID = 5
+	GamemodeCTF        GamemodeID = 0
+	GamemodeTC         GamemodeID = 1
+	GamemodeBabel      GamemodeID = 2
+	GamemodeTDM        GamemodeID = 3
+	GamemodeArena      GamemodeID = 4
+	GamemodeLaby       GamemodeID = 5
+	GamemodePush       GamemodeID = 6
+	GamemodeMurderball GamemodeID = 7
 )
 
 func (g GamemodeID) String() string {
@@ -998,11 +1398,26 @@ func (g GamemodeID) String() string {
 		return "arena"
 	case GamemodeLaby:
 		return "laby"
+	case GamemodePush:
+		return "push"
+	case GamemodeMurderball:
+		return "murderball"
 	default:
 		return "unknown"
 	}
 }
 
+// SingleIntel reports whether the mode plays with one shared intel in slot 0
+func (g GamemodeID) SingleIntel() bool {
+	return g == GamemodeBabel || g == GamemodeMurderball
+}
+
+// TracksIntelDrops reports whether the mode's script receives on_intel_drop
+// when a carrier loses the intel
+func (g GamemodeID) TracksIntelDrops() bool {
+	return g == GamemodePush || g == GamemodeMurderball
+}
+
 func ParseGamemode(id int) (GamemodeID, error) {
 	switch id {
 	case 0:
@@ -1017,6 +1432,10 @@ func ParseGamemode(id int) (GamemodeID, error) {
 		return GamemodeArena, nil
 	case 5:
 		return GamemodeLaby, nil
+	case 6:
+		return GamemodePush, nil
+	case 7:
+		return GamemodeMurderball, nil
 	default:
 		return 0, fmt.Errorf("invalid gamemode ID: %d", id)
 	}
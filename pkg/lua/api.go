@@ -3,8 +3,11 @@ package lua
 import (
 	"fmt"
 	"math"
+	"strconv"
 	"time"
 
+	"github.com/siohaza/fosilo/internal/anticheat"
+	"github.com/siohaza/fosilo/internal/audit"
 	"github.com/siohaza/fosilo/internal/bans"
 	"github.com/siohaza/fosilo/internal/gamestate"
 	"github.com/siohaza/fosilo/internal/player"
@@ -40,11 +43,27 @@ type ServerInterface interface {
 	DisconnectPlayerWithReason(p *player.Player, reason uint32)
 	SendPlayerLeftPacket(playerID uint8)
 	SaveMap(filename string) (string, error)
+	StartDemo() (string, error)
+	StopDemo() (string, error)
+	IsRecordingDemo() bool
 	BroadcastTerritoryCapture(playerID, entityID, winning, state uint8)
 	BroadcastProgressBar(entityID, capturingTeam uint8, rate int8, progress float32)
 	SendPlayerPositionPacketTo(playerID uint8, pos, ori protocol.Vector3f, toPlayerID uint8)
 	SendIntelPositionPacketOnly(objectID uint8, team uint8, position protocol.Vector3f)
 	BroadcastCreatePlayer(p *player.Player)
+	IsProtected(x, y int) bool
+	SetSectorProtected(sector string, protected bool) error
+	GetProtectedSectors() []string
+	GetViolations(playerID uint8) (anticheat.Report, bool)
+	AddViolation(playerID uint8, category, detail string, weight float64) bool
+	AccountsEnabled() bool
+	RegisterAccount(playerID uint8, password string) error
+	LoginAccount(playerID uint8, password string) error
+	SetAccountRole(name, role string) error
+	GetAccountRole(name string) (string, bool)
+	GetPlayerAccount(playerID uint8) (string, string, bool)
+	Audit(action, target string, args []string)
+	AuditLog(who string, n int) ([]audit.Entry, error)
 }
 
 type GameAPI struct {
@@ -77,6 +96,22 @@ func (api *GameAPI) SetGamemodeVM(vm *VM) {
 	api.gamemodeVM = vm
 }
 
+// audit records a privileged call, the server keeps only those made by a
+// player's command
+func (api *GameAPI) audit(action, target string, args ...string) {
+	if api.server != nil {
+		api.server.Audit(action, target, args)
+	}
+}
+
+func (api *GameAPI) auditPlayer(action string, id int, args ...string) {
+	target := strconv.Itoa(id)
+	if p, ok := api.gameState.Players.Get(uint8(id)); ok {
+		target = p.GetName()
+	}
+	api.audit(action, target, args...)
+}
+
 func (api *GameAPI) RegisterFunctions(vm *VM) {
 	state := vm.State()
 
@@ -104,9 +139,13 @@ func (api *GameAPI) RegisterFunctions(vm *VM) {
 	state.Register("get_map_width", api.getMapWidth)
 	state.Register("get_map_height", api.getMapHeight)
 	state.Register("get_map_depth", api.getMapDepth)
+	state.Register("find_path", api.findPath)
 	state.Register("ban_player", api.banPlayer)
 	state.Register("unban_ip", api.unbanIP)
 	state.Register("is_banned", api.isBanned)
+	state.Register("ban_name", api.banName)
+	state.Register("is_name_banned", api.isNameBanned)
+	state.Register("unban_name", api.unbanName)
 	state.Register("kick_player_cmd", api.kickPlayerCmd)
 	state.Register("disconnect_player", api.disconnectPlayer)
 	state.Register("broadcast_chat", api.broadcastChat)
@@ -127,12 +166,22 @@ func (api *GameAPI) RegisterFunctions(vm *VM) {
 	state.Register("send_error_message", api.sendErrorMessage)
 	state.Register("get_available_commands", api.getAvailableCommands)
 	state.Register("has_permission", api.hasPermission)
+	state.Register("outranks", api.outranks)
 	state.Register("set_player_permission", api.setPlayerPermission)
 	state.Register("get_login_retries", api.getLoginRetries)
 	state.Register("set_login_retries", api.setLoginRetries)
 	state.Register("get_config_password", api.getConfigPassword)
+	state.Register("accounts_enabled", api.accountsEnabled)
+	state.Register("register_account", api.registerAccount)
+	state.Register("login_account", api.loginAccount)
+	state.Register("set_account_role", api.setAccountRole)
+	state.Register("get_player_account", api.getPlayerAccount)
+	state.Register("get_account_role", api.getAccountRole)
 	state.Register("get_map_name", api.getMapName)
 	state.Register("save_map", api.saveMap)
+	state.Register("start_demo", api.startDemo)
+	state.Register("stop_demo", api.stopDemo)
+	state.Register("is_recording_demo", api.isRecordingDemo)
 
 	state.Register("set_player_hp", api.setPlayerHP)
 	state.Register("set_player_team", api.setPlayerTeam)
@@ -176,11 +225,19 @@ func (api *GameAPI) RegisterFunctions(vm *VM) {
 	state.Register("create_explosion", api.createExplosion)
 
 	state.Register("send_territory_capture", api.sendTerritoryCapture)
+	state.Register("set_territory", api.setTerritory)
 	state.Register("send_progress_bar", api.sendProgressBar)
 	state.Register("get_config_value", api.getConfigValue)
 
 	state.Register("send_player_position_packet", api.sendPlayerPositionPacket)
 	state.Register("send_intel_position_packet", api.sendIntelPositionPacket)
+
+	state.Register("is_protected", api.isProtected)
+	state.Register("set_protected", api.setProtected)
+	state.Register("get_protected_sectors", api.getProtectedSectors)
+	state.Register("get_violations", api.getViolations)
+	state.Register("add_violation", api.addViolation)
+	state.Register("get_audit_log", api.getAuditLog)
 }
 
 func (api *GameAPI) findTopBlock(state *lua.State) int {
@@ -299,6 +356,8 @@ func pushPlayerTable(state *lua.State, p *player.Player) {
 	state.SetField(-2, "has_intel")
 	state.PushInteger(int(p.Permissions))
 	state.SetField(-2, "permissions")
+	state.PushString(p.Role)
+	state.SetField(-2, "role")
 
 	state.PushString(string(p.ClientIdentifier))
 	state.SetField(-2, "client_identifier")
@@ -310,6 +369,8 @@ func pushPlayerTable(state *lua.State, p *player.Player) {
 	state.SetField(-2, "version_revision")
 	state.PushString(p.OSInfo)
 	state.SetField(-2, "os_info")
+	state.PushString(protocol.VersionString(p.ProtocolVersion))
+	state.SetField(-2, "protocol_version")
 
 	state.NewTable()
 	state.PushNumber(float64(p.Position.X))
@@ -344,6 +405,7 @@ func (api *GameAPI) setTeamScore(state *lua.State) int {
 	} else if team == 1 {
 		api.gameState.Team2Score = uint8(score)
 	}
+	api.audit("set_team_score", "", strconv.Itoa(team), strconv.Itoa(score))
 
 	return 0
 }
@@ -429,6 +491,7 @@ func (api *GameAPI) killPlayer(state *lua.State) int {
 	id, _ := state.ToInteger(1)
 
 	if api.server != nil {
+		api.auditPlayer("kill_player", id)
 		api.server.KillPlayer(uint8(id), uint8(id), protocol.KillTypeTeamChange)
 	}
 
@@ -455,6 +518,7 @@ func (api *GameAPI) setPlayerPosition(state *lua.State) int {
 		if api.server != nil {
 			api.server.BroadcastCreatePlayer(p)
 		}
+		api.auditPlayer("set_player_position", id, fmt.Sprintf("%.1f %.1f %.1f", x, y, z))
 	}
 
 	return 0
@@ -604,7 +668,12 @@ func (api *GameAPI) banPlayer(state *lua.State) int {
 		duration = time.Duration(durationHours * float64(time.Hour))
 	}
 
-	err := api.banManager.AddBan(ip, name, reason, bannedBy, duration)
+	var err error
+	if bans.IsRange(ip) {
+		err = api.banManager.AddRangeBan(ip, name, reason, bannedBy, duration)
+	} else {
+		err = api.banManager.AddBan(ip, name, reason, bannedBy, duration)
+	}
 	if err != nil {
 		state.PushBoolean(false)
 		state.PushString(err.Error())
@@ -637,6 +706,50 @@ func (api *GameAPI) unbanIP(state *lua.State) int {
 	return 2
 }
 
+func (api *GameAPI) banName(state *lua.State) int {
+	name, _ := state.ToString(1)
+	reason, _ := state.ToString(2)
+	bannedBy, _ := state.ToString(3)
+	durationHours, _ := state.ToNumber(4)
+
+	if api.banManager == nil {
+		state.PushBoolean(false)
+		state.PushString("ban manager not available")
+		return 2
+	}
+
+	duration := time.Duration(durationHours * float64(time.Hour))
+	if err := api.banManager.AddBanByName(name, reason, bannedBy, duration); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+
+	state.PushBoolean(true)
+	state.PushString("")
+	return 2
+}
+
+func (api *GameAPI) unbanName(state *lua.State) int {
+	name, _ := state.ToString(1)
+
+	if api.banManager == nil {
+		state.PushBoolean(false)
+		state.PushString("ban manager not available")
+		return 2
+	}
+
+	if err := api.banManager.RemoveBanByName(name); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+
+	state.PushBoolean(true)
+	state.PushString("")
+	return 2
+}
+
 func (api *GameAPI) isBanned(state *lua.State) int {
 	ip, _ := state.ToString(1)
 
@@ -650,6 +763,19 @@ func (api *GameAPI) isBanned(state *lua.State) int {
 	return 1
 }
 
+func (api *GameAPI) isNameBanned(state *lua.State) int {
+	name, _ := state.ToString(1)
+
+	if api.banManager == nil {
+		state.PushBoolean(false)
+		return 1
+	}
+
+	banned, _ := api.banManager.IsBannedByName(name)
+	state.PushBoolean(banned)
+	return 1
+}
+
 func (api *GameAPI) kickPlayerCmd(state *lua.State) int {
 	id, _ := state.ToInteger(1)
 	reason, _ := state.ToString(2)
@@ -684,6 +810,7 @@ func (api *GameAPI) disconnectPlayer(state *lua.State) int {
 		return 2
 	}
 
+	api.auditPlayer("disconnect_player", id, strconv.Itoa(reason))
 	api.server.DisconnectPlayerWithReason(p, uint32(reason))
 
 	state.PushBoolean(true)
@@ -719,7 +846,7 @@ func (api *GameAPI) getPlayerIP(state *lua.State) int {
 		return 1
 	}
 
-	ip := peer.GetAddress().String()
+	ip := peer.Address()
 	state.PushString(ip)
 	return 1
 }
@@ -962,6 +1089,7 @@ func (api *GameAPI) reloadCommands(state *lua.State) int {
 		return 2
 	}
 
+	api.audit("reload_commands", "")
 	err := api.server.ReloadCommands()
 	if err != nil {
 		state.PushBoolean(false)
@@ -981,6 +1109,7 @@ func (api *GameAPI) reloadGamemode(state *lua.State) int {
 		return 2
 	}
 
+	api.audit("reload_gamemode", "")
 	err := api.server.ReloadGamemode()
 	if err != nil {
 		state.PushBoolean(false)
@@ -1082,6 +1211,16 @@ func (api *GameAPI) getAvailableCommands(state *lua.State) int {
 	return 1
 }
 
+// roles returns the server's roles, only the built-in tiers when no command
+// manager is set
+func (api *GameAPI) roles() *Roles {
+	if api.commandManager != nil {
+		return api.commandManager.Roles()
+	}
+	roles, _ := NewRoles(nil)
+	return roles
+}
+
 func (api *GameAPI) hasPermission(state *lua.State) int {
 	playerID, _ := state.ToInteger(1)
 	permissionName, _ := state.ToString(2)
@@ -1092,31 +1231,22 @@ func (api *GameAPI) hasPermission(state *lua.State) int {
 		return 1
 	}
 
-	requiredLevel := parsePermissionLevel(permissionName)
-	p.RLock()
-	perms := p.Permissions
-	p.RUnlock()
-
-	playerLevel := getPermissionLevel(perms)
-	state.PushBoolean(playerLevel >= requiredLevel)
+	state.PushBoolean(api.roles().Has(p, permissionName))
 	return 1
 }
 
-func parsePermissionLevel(perm string) int {
-	switch perm {
-	case "trusted":
+func (api *GameAPI) outranks(state *lua.State) int {
+	playerID, _ := state.ToInteger(1)
+	roleName, _ := state.ToString(2)
+
+	p, exists := api.gameState.Players.Get(uint8(playerID))
+	if !exists {
+		state.PushBoolean(false)
 		return 1
-	case "guard":
-		return 2
-	case "moderator", "mod":
-		return 3
-	case "admin":
-		return 4
-	case "manager":
-		return 5
-	default:
-		return 0
 	}
+
+	state.PushBoolean(api.roles().Outranks(p, roleName))
+	return 1
 }
 
 func getPermissionLevel(perms uint64) int {
@@ -1149,27 +1279,19 @@ func (api *GameAPI) setPlayerPermission(state *lua.State) int {
 		return 2
 	}
 
-	var permBit uint64
-	switch permissionName {
-	case "trusted":
-		permBit = 1 << 1
-	case "guard":
-		permBit = 1 << 2
-	case "moderator", "mod":
-		permBit = 1 << 3
-	case "admin":
-		permBit = 1 << 4
-	case "manager":
-		permBit = 1 << 5
-	default:
+	roles := api.roles()
+	if name := NormalizeRole(permissionName); name == "" || name == "none" || !roles.Exists(name) {
 		state.PushBoolean(false)
 		state.PushString("invalid permission level")
 		return 2
 	}
 
-	p.Lock()
-	p.Permissions = permBit
-	p.Unlock()
+	if err := roles.Assign(p, permissionName); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+	api.auditPlayer("set_player_permission", playerID, NormalizeRole(permissionName))
 
 	state.PushBoolean(true)
 	state.PushString("")
@@ -1216,6 +1338,112 @@ func (api *GameAPI) getConfigPassword(state *lua.State) int {
 	return 1
 }
 
+func (api *GameAPI) accountsEnabled(state *lua.State) int {
+	state.PushBoolean(api.server != nil && api.server.AccountsEnabled())
+	return 1
+}
+
+func (api *GameAPI) registerAccount(state *lua.State) int {
+	playerID, _ := state.ToInteger(1)
+	password, _ := state.ToString(2)
+
+	if api.server == nil {
+		state.PushBoolean(false)
+		state.PushString("server not available")
+		return 2
+	}
+
+	if err := api.server.RegisterAccount(uint8(playerID), password); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+
+	state.PushBoolean(true)
+	state.PushString("")
+	return 2
+}
+
+func (api *GameAPI) loginAccount(state *lua.State) int {
+	playerID, _ := state.ToInteger(1)
+	password, _ := state.ToString(2)
+
+	if api.server == nil {
+		state.PushBoolean(false)
+		state.PushString("server not available")
+		return 2
+	}
+
+	if err := api.server.LoginAccount(uint8(playerID), password); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+
+	state.PushBoolean(true)
+	state.PushString("")
+	return 2
+}
+
+func (api *GameAPI) setAccountRole(state *lua.State) int {
+	name, _ := state.ToString(1)
+	role, _ := state.ToString(2)
+
+	if api.server == nil {
+		state.PushBoolean(false)
+		state.PushString("server not available")
+		return 2
+	}
+
+	if err := api.server.SetAccountRole(name, role); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+	api.audit("set_account_role", name, role)
+
+	state.PushBoolean(true)
+	state.PushString("")
+	return 2
+}
+
+func (api *GameAPI) getPlayerAccount(state *lua.State) int {
+	playerID, _ := state.ToInteger(1)
+
+	if api.server == nil {
+		state.PushNil()
+		return 1
+	}
+
+	name, role, ok := api.server.GetPlayerAccount(uint8(playerID))
+	if !ok {
+		state.PushNil()
+		return 1
+	}
+
+	state.PushString(name)
+	state.PushString(role)
+	return 2
+}
+
+func (api *GameAPI) getAccountRole(state *lua.State) int {
+	name, _ := state.ToString(1)
+
+	if api.server == nil {
+		state.PushNil()
+		return 1
+	}
+
+	role, ok := api.server.GetAccountRole(name)
+	if !ok {
+		state.PushNil()
+		return 1
+	}
+
+	state.PushString(role)
+	return 1
+}
+
 func (api *GameAPI) getMapName(state *lua.State) int {
 	if api.server == nil {
 		state.PushString("")
@@ -1240,6 +1468,7 @@ func (api *GameAPI) setPlayerHP(state *lua.State) int {
 		return 0
 	}
 
+	api.auditPlayer("set_player_hp", id, strconv.Itoa(hp))
 	if hp <= 0 {
 		api.server.KillPlayer(uint8(id), 255, protocol.KillTypeWeapon)
 	} else {
@@ -1260,6 +1489,7 @@ func (api *GameAPI) setPlayerTeam(state *lua.State) int {
 		return 0
 	}
 
+	api.auditPlayer("set_player_team", id, strconv.Itoa(team))
 	api.server.SetPlayerTeam(uint8(id), uint8(team))
 
 	return 0
@@ -1271,6 +1501,7 @@ func (api *GameAPI) healPlayer(state *lua.State) int {
 	}
 
 	id, _ := state.ToInteger(1)
+	api.auditPlayer("heal_player", id)
 	api.server.RestockPlayer(uint8(id))
 	return 0
 }
@@ -1288,6 +1519,7 @@ func (api *GameAPI) setPlayerWeapon(state *lua.State) int {
 		p.Lock()
 		p.Weapon = protocol.WeaponType(weapon)
 		p.Unlock()
+		api.auditPlayer("set_player_weapon", id, strconv.Itoa(weapon))
 	}
 
 	return 0
@@ -1315,6 +1547,7 @@ func (api *GameAPI) setPlayerAmmo(state *lua.State) int {
 		p.MagazineAmmo = uint8(primary)
 		p.ReserveAmmo = uint8(secondary)
 		p.Unlock()
+		api.auditPlayer("set_player_ammo", id, strconv.Itoa(primary), strconv.Itoa(secondary))
 	}
 
 	return 0
@@ -1335,6 +1568,7 @@ func (api *GameAPI) setPlayerGrenades(state *lua.State) int {
 		p.Lock()
 		p.Grenades = uint8(grenades)
 		p.Unlock()
+		api.auditPlayer("set_player_grenades", id, strconv.Itoa(grenades))
 	}
 
 	return 0
@@ -1355,6 +1589,7 @@ func (api *GameAPI) setPlayerBlocks(state *lua.State) int {
 		p.Lock()
 		p.Blocks = uint8(blocks)
 		p.Unlock()
+		api.auditPlayer("set_player_blocks", id, strconv.Itoa(blocks))
 	}
 
 	return 0
@@ -1364,6 +1599,7 @@ func (api *GameAPI) respawnPlayer(state *lua.State) int {
 	id, _ := state.ToInteger(1)
 
 	if api.server != nil {
+		api.auditPlayer("respawn_player", id)
 		api.server.RespawnPlayer(uint8(id))
 	} else {
 		p, _ := api.gameState.Players.Get(uint8(id))
@@ -1483,6 +1719,53 @@ func (api *GameAPI) isValidPosition(state *lua.State) int {
 	return 1
 }
 
+func (api *GameAPI) findPath(state *lua.State) int {
+	x1, _ := state.ToInteger(1)
+	y1, _ := state.ToInteger(2)
+	z1, _ := state.ToInteger(3)
+	x2, _ := state.ToInteger(4)
+	y2, _ := state.ToInteger(5)
+	z2, _ := state.ToInteger(6)
+
+	if api.gameState.Nav == nil {
+		state.PushNil()
+		return 1
+	}
+
+	// callers usually pass block or player coordinates, snap them to the
+	// nearest level a player can stand on in that column
+	start, ok := api.gameState.Nav.Snap(x1, y1, z1)
+	if !ok {
+		state.PushNil()
+		return 1
+	}
+	goal, ok := api.gameState.Nav.Snap(x2, y2, z2)
+	if !ok {
+		state.PushNil()
+		return 1
+	}
+
+	path, ok := api.gameState.Nav.FindPath(start, goal)
+	if !ok {
+		state.PushNil()
+		return 1
+	}
+
+	state.CreateTable(len(path), 0)
+	for i, node := range path {
+		state.PushInteger(i + 1)
+		state.CreateTable(0, 3)
+		state.PushInteger(node.X)
+		state.SetField(-2, "x")
+		state.PushInteger(node.Y)
+		state.SetField(-2, "y")
+		state.PushInteger(node.Z)
+		state.SetField(-2, "z")
+		state.SetTable(-3)
+	}
+	return 1
+}
+
 func (api *GameAPI) getPlayerPing(state *lua.State) int {
 	id, _ := state.ToInteger(1)
 
@@ -1497,7 +1780,7 @@ func (api *GameAPI) getPlayerPing(state *lua.State) int {
 		return 1
 	}
 
-	rtt := p.Peer.GetRoundTripTime()
+	rtt := p.Peer.RoundTripTime()
 	state.PushInteger(int(rtt))
 	return 1
 }
@@ -1825,12 +2108,58 @@ func (api *GameAPI) saveMap(state *lua.State) int {
 		state.PushString(err.Error())
 		return 2
 	}
+	api.audit("save_map", "", savedPath)
 
 	state.PushBoolean(true)
 	state.PushString(savedPath)
 	return 2
 }
 
+func (api *GameAPI) startDemo(state *lua.State) int {
+	if api.server == nil {
+		state.PushBoolean(false)
+		state.PushString("server not available")
+		return 2
+	}
+
+	path, err := api.server.StartDemo()
+	if err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+	api.audit("start_demo", "", path)
+
+	state.PushBoolean(true)
+	state.PushString(path)
+	return 2
+}
+
+func (api *GameAPI) stopDemo(state *lua.State) int {
+	if api.server == nil {
+		state.PushBoolean(false)
+		state.PushString("server not available")
+		return 2
+	}
+
+	path, err := api.server.StopDemo()
+	if err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+	api.audit("stop_demo", "", path)
+
+	state.PushBoolean(true)
+	state.PushString(path)
+	return 2
+}
+
+func (api *GameAPI) isRecordingDemo(state *lua.State) int {
+	state.PushBoolean(api.server != nil && api.server.IsRecordingDemo())
+	return 1
+}
+
 func (api *GameAPI) sendTerritoryCapture(state *lua.State) int {
 	playerID, _ := state.ToInteger(1)
 	entityID, _ := state.ToInteger(2)
@@ -1845,6 +2174,25 @@ func (api *GameAPI) sendTerritoryCapture(state *lua.State) int {
 	return 0
 }
 
+func (api *GameAPI) setTerritory(state *lua.State) int {
+	id, _ := state.ToInteger(1)
+	x, _ := state.ToNumber(2)
+	y, _ := state.ToNumber(3)
+	z, _ := state.ToNumber(4)
+	team, ok := state.ToInteger(5)
+	if !ok || team < 0 || team > 1 {
+		team = 255
+	}
+
+	if id < 0 || id > 255 {
+		return 0
+	}
+
+	pos := protocol.Vector3f{X: float32(x), Y: float32(y), Z: float32(z)}
+	api.gameState.SetTerritory(uint8(id), pos, uint8(team))
+	return 0
+}
+
 func (api *GameAPI) sendProgressBar(state *lua.State) int {
 	entityID, _ := state.ToInteger(1)
 	capturingTeam, _ := state.ToInteger(2)
@@ -1919,6 +2267,10 @@ func (api *GameAPI) getConfigValue(state *lua.State) int {
 		state.PushInteger(cfg.LabyHogTimeout)
 	case "laby_regen_rate":
 		state.PushNumber(cfg.LabyRegenRate)
+	case "push_intel_return_time":
+		state.PushNumber(cfg.PushIntelReturnTime)
+	case "murderball_reset_time":
+		state.PushNumber(cfg.MurderballResetTime)
 	case "tower_pos_x":
 		state.PushInteger(cfg.TowerPosX)
 	case "tower_pos_y":
@@ -1981,3 +2333,150 @@ func (api *GameAPI) sendIntelPositionPacket(state *lua.State) int {
 	api.server.SendIntelPositionPacketOnly(uint8(objectID), uint8(team), pos)
 	return 0
 }
+
+func (api *GameAPI) isProtected(state *lua.State) int {
+	x, _ := state.ToInteger(1)
+	y, _ := state.ToInteger(2)
+
+	if api.server == nil {
+		state.PushBoolean(false)
+		return 1
+	}
+
+	state.PushBoolean(api.server.IsProtected(x, y))
+	return 1
+}
+
+func (api *GameAPI) setProtected(state *lua.State) int {
+	sector, _ := state.ToString(1)
+	protected := true
+	if state.Top() >= 2 && state.IsBoolean(2) {
+		protected = state.ToBoolean(2)
+	}
+
+	if api.server == nil {
+		state.PushBoolean(false)
+		state.PushString("server not available")
+		return 2
+	}
+
+	if err := api.server.SetSectorProtected(sector, protected); err != nil {
+		state.PushBoolean(false)
+		state.PushString(err.Error())
+		return 2
+	}
+	api.audit("set_protected", sector, strconv.FormatBool(protected))
+
+	state.PushBoolean(true)
+	state.PushString("")
+	return 2
+}
+
+func (api *GameAPI) getProtectedSectors(state *lua.State) int {
+	if api.server == nil {
+		state.NewTable()
+		return 1
+	}
+
+	sectors := api.server.GetProtectedSectors()
+	state.CreateTable(len(sectors), 0)
+	for i, sector := range sectors {
+		state.PushInteger(i + 1)
+		state.PushString(sector)
+		state.SetTable(-3)
+	}
+	return 1
+}
+
+func (api *GameAPI) getViolations(state *lua.State) int {
+	playerID, _ := state.ToInteger(1)
+
+	if api.server == nil {
+		state.PushNil()
+		return 1
+	}
+
+	report, ok := api.server.GetViolations(uint8(playerID))
+	if !ok {
+		state.PushNil()
+		return 1
+	}
+
+	state.NewTable()
+	state.PushNumber(report.Score)
+	state.SetField(-2, "score")
+	state.PushInteger(report.Step)
+	state.SetField(-2, "step")
+
+	state.CreateTable(0, len(report.Counts))
+	for _, c := range report.Counts {
+		state.PushInteger(c.Count)
+		state.SetField(-2, c.Category)
+	}
+	state.SetField(-2, "categories")
+	return 1
+}
+
+func (api *GameAPI) addViolation(state *lua.State) int {
+	playerID, _ := state.ToInteger(1)
+	category, _ := state.ToString(2)
+	detail, _ := state.ToString(3)
+	weight := -1.0
+	if state.Top() >= 4 && !state.IsNil(4) {
+		weight, _ = state.ToNumber(4)
+	}
+
+	if api.server == nil || category == "" {
+		state.PushBoolean(false)
+		return 1
+	}
+
+	state.PushBoolean(api.server.AddViolation(uint8(playerID), category, detail, weight))
+	return 1
+}
+
+func (api *GameAPI) getAuditLog(state *lua.State) int {
+	who, _ := state.ToString(1)
+	n, ok := state.ToInteger(2)
+	if !ok || n <= 0 {
+		n = 10
+	}
+
+	if api.server == nil {
+		state.PushNil()
+		state.PushString("server not available")
+		return 2
+	}
+
+	entries, err := api.server.AuditLog(who, n)
+	if err != nil {
+		state.PushNil()
+		state.PushString(err.Error())
+		return 2
+	}
+
+	state.CreateTable(len(entries), 0)
+	for i, e := range entries {
+		state.NewTable()
+		state.PushInteger(int(e.Time.Unix()))
+		state.SetField(-2, "time")
+		state.PushInteger(int(time.Since(e.Time).Seconds()))
+		state.SetField(-2, "age")
+		state.PushString(e.Actor)
+		state.SetField(-2, "actor")
+		state.PushString(e.IP)
+		state.SetField(-2, "ip")
+		state.PushString(e.Action)
+		state.SetField(-2, "action")
+		state.PushString(e.Target)
+		state.SetField(-2, "target")
+		state.CreateTable(len(e.Args), 0)
+		for j, arg := range e.Args {
+			state.PushString(arg)
+			state.RawSetInt(-2, j+1)
+		}
+		state.SetField(-2, "args")
+		state.RawSetInt(-2, i+1)
+	}
+	return 1
+}
@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -10,6 +11,8 @@ import (
 	"github.com/siohaza/fosilo/internal/player"
 )
 
+var ErrPermissionDenied = errors.New("you don't have permission to use this command")
+
 type CommandPermission int
 
 const (
@@ -34,15 +37,31 @@ type LuaCommand struct {
 type CommandManager struct {
 	commands map[string]*LuaCommand
 	aliases  map[string]string
+	roles    *Roles
 	logger   *slog.Logger
 }
 
 func NewCommandManager(logger *slog.Logger) *CommandManager {
-	return &CommandManager{
+	roles, _ := NewRoles(nil)
+	cm := &CommandManager{
 		commands: make(map[string]*LuaCommand),
 		aliases:  make(map[string]string),
+		roles:    roles,
 		logger:   logger,
 	}
+	roles.commands = cm
+	return cm
+}
+
+// SetRoles replaces the roles command access is checked against, the roles
+// look up these commands when judging what a role hands out
+func (cm *CommandManager) SetRoles(roles *Roles) {
+	roles.commands = cm
+	cm.roles = roles
+}
+
+func (cm *CommandManager) Roles() *Roles {
+	return cm.roles
 }
 
 func (cm *CommandManager) LoadCommands(commandsDir string, api *GameAPI) error {
@@ -147,8 +166,8 @@ func (cm *CommandManager) Execute(p *player.Player, cmdName string, args []strin
 		return "", fmt.Errorf("unknown command: %s", cmdName)
 	}
 
-	if !hasPermission(p, cmd.Permission) {
-		return "", fmt.Errorf("you don't have permission to use this command")
+	if !cm.roles.CanRun(p, cmd) {
+		return "", ErrPermissionDenied
 	}
 
 	state := cmd.VM.State()
@@ -184,7 +203,7 @@ func (cm *CommandManager) Execute(p *player.Player, cmdName string, args []strin
 func (cm *CommandManager) List(p *player.Player) []*LuaCommand {
 	var commands []*LuaCommand
 	for _, cmd := range cm.commands {
-		if hasPermission(p, cmd.Permission) {
+		if cm.roles.CanRun(p, cmd) {
 			commands = append(commands, cmd)
 		}
 	}
@@ -207,34 +226,3 @@ func parsePermission(perm string) CommandPermission {
 		return PermissionNone
 	}
 }
-
-func hasPermission(p *player.Player, required CommandPermission) bool {
-	if required == PermissionNone {
-		return true
-	}
-
-	playerPerm := getPlayerPermission(p)
-	return playerPerm >= required
-}
-
-func getPlayerPermission(p *player.Player) CommandPermission {
-	perms := p.Permissions
-
-	if perms&(1<<5) != 0 {
-		return PermissionManager
-	}
-	if perms&(1<<4) != 0 {
-		return PermissionAdmin
-	}
-	if perms&(1<<3) != 0 {
-		return PermissionModerator
-	}
-	if perms&(1<<2) != 0 {
-		return PermissionGuard
-	}
-	if perms&(1<<1) != 0 {
-		return PermissionTrusted
-	}
-
-	return PermissionNone
-}
@@ -0,0 +1,263 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/pkg/config"
+)
+
+type role struct {
+	name    string
+	parent  string
+	tier    CommandPermission
+	allow   map[string]bool
+	deny    map[string]bool
+	builtin bool
+}
+
+// Roles resolves the built-in permission tiers together with the roles the
+// config defines on top of them
+type Roles struct {
+	roles    map[string]*role
+	commands *CommandManager
+}
+
+var builtinRoles = []string{"none", "trusted", "guard", "moderator", "admin", "manager"}
+
+// NewRoles builds the role table. Every custom role ends up on a built-in
+// tier through its inherits chain, a built-in name in defs only adds allow
+// and deny lists to that tier
+func NewRoles(defs map[string]config.RoleConfig) (*Roles, error) {
+	r := &Roles{roles: make(map[string]*role)}
+	for _, name := range builtinRoles {
+		r.roles[name] = &role{
+			name:    name,
+			tier:    parsePermission(name),
+			builtin: true,
+		}
+	}
+
+	for name, def := range defs {
+		name = NormalizeRole(name)
+		ro, exists := r.roles[name]
+		if !exists {
+			ro = &role{name: name, parent: NormalizeRole(def.Inherits)}
+			if ro.parent == "" {
+				ro.parent = "none"
+			}
+			r.roles[name] = ro
+		} else if def.Inherits != "" {
+			return nil, fmt.Errorf("role %q is built in and cannot inherit", name)
+		}
+		ro.allow = commandSet(def.Allow)
+		ro.deny = commandSet(def.Deny)
+	}
+
+	for _, ro := range r.roles {
+		if ro.builtin {
+			continue
+		}
+		tier, err := r.resolveTier(ro)
+		if err != nil {
+			return nil, err
+		}
+		ro.tier = tier
+	}
+
+	return r, nil
+}
+
+func (r *Roles) resolveTier(ro *role) (CommandPermission, error) {
+	seen := map[string]bool{}
+	for !ro.builtin {
+		if seen[ro.name] {
+			return 0, fmt.Errorf("role %q inherits from itself", ro.name)
+		}
+		seen[ro.name] = true
+
+		parent, ok := r.roles[ro.parent]
+		if !ok {
+			return 0, fmt.Errorf("role %q inherits unknown role %q", ro.name, ro.parent)
+		}
+		ro = parent
+	}
+	return ro.tier, nil
+}
+
+func commandSet(commands []string) map[string]bool {
+	set := make(map[string]bool, len(commands))
+	for _, cmd := range commands {
+		set[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(cmd), "/"))] = true
+	}
+	return set
+}
+
+// NormalizeRole folds a role name, "mod" being short for moderator
+func NormalizeRole(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "mod" {
+		return "moderator"
+	}
+	return name
+}
+
+// Exists reports whether name is a built-in or configured role, "" counts as
+// none
+func (r *Roles) Exists(name string) bool {
+	name = NormalizeRole(name)
+	if name == "" {
+		return true
+	}
+	_, ok := r.roles[name]
+	return ok
+}
+
+// Bits returns the permission bits a player holding the role gets, which are
+// those of the built-in tier it rests on
+func (r *Roles) Bits(name string) (uint64, bool) {
+	ro, ok := r.lookup(name)
+	if !ok {
+		return 0, false
+	}
+	if ro.tier == PermissionNone {
+		return 0, true
+	}
+	return 1 << uint(ro.tier), true
+}
+
+func (r *Roles) lookup(name string) (*role, bool) {
+	name = NormalizeRole(name)
+	if name == "" {
+		name = "none"
+	}
+	ro, ok := r.roles[name]
+	return ro, ok
+}
+
+// playerRole is the role a player's command access is judged by, players
+// given only permission bits fall back to their tier
+func (r *Roles) playerRole(p *player.Player) *role {
+	p.RLock()
+	name, perms := p.Role, p.Permissions
+	p.RUnlock()
+
+	if ro, ok := r.lookup(name); ok && name != "" {
+		return ro
+	}
+	return r.roles[builtinRoles[getPermissionLevel(perms)]]
+}
+
+// CanRun decides whether p may use cmd. Going up from the player's role, the
+// first role that lists the command in deny or allow decides, deny first.
+// Without such a role the command's own permission is compared to the tier
+func (r *Roles) CanRun(p *player.Player, cmd *LuaCommand) bool {
+	return r.roleCanRun(r.playerRole(p), cmd)
+}
+
+func (r *Roles) roleCanRun(start *role, cmd *LuaCommand) bool {
+	name := strings.ToLower(cmd.Name)
+	for ro := start; ro != nil; ro = r.roles[ro.parent] {
+		if ro.deny[name] || ro.deny["*"] {
+			return false
+		}
+		if ro.allow[name] || ro.allow["*"] {
+			return true
+		}
+		if ro.builtin {
+			break
+		}
+	}
+	return start.tier >= cmd.Permission
+}
+
+// Has reports whether p holds the named role or one above it. A built-in
+// tier is held from that tier up, a custom role by players whose role
+// inherits it and by anyone on a higher tier than it rests on. On top of
+// that p must be able to run every command the role's allow lists open up,
+// otherwise a role could hand out more than its holder has
+func (r *Roles) Has(p *player.Player, name string) bool {
+	want, ok := r.lookup(name)
+	if !ok {
+		return false
+	}
+
+	ro := r.playerRole(p)
+	if want.builtin {
+		return ro.tier >= want.tier && r.coversAllows(ro, want)
+	}
+	if ro.tier > want.tier {
+		return r.coversAllows(ro, want)
+	}
+	for held := ro; held != nil && !held.builtin; held = r.roles[held.parent] {
+		if held == want {
+			return r.coversAllows(ro, want)
+		}
+	}
+	return false
+}
+
+// coversAllows reports whether holder can run each command that want's allow
+// lists let it run. "*" stands for every loaded command and a command that
+// is not loaded counts as a manager one
+func (r *Roles) coversAllows(holder, want *role) bool {
+	for ro := want; ro != nil; ro = r.roles[ro.parent] {
+		for name := range ro.allow {
+			for _, cmd := range r.allowed(name) {
+				if r.roleCanRun(want, cmd) && !r.roleCanRun(holder, cmd) {
+					return false
+				}
+			}
+		}
+		if ro.builtin {
+			break
+		}
+	}
+	return true
+}
+
+func (r *Roles) allowed(name string) []*LuaCommand {
+	if name == "*" && r.commands != nil {
+		commands := make([]*LuaCommand, 0, len(r.commands.commands))
+		for _, cmd := range r.commands.commands {
+			commands = append(commands, cmd)
+		}
+		return commands
+	}
+	if r.commands != nil {
+		if cmd := r.commands.Get(name); cmd != nil {
+			return []*LuaCommand{cmd}
+		}
+	}
+	return []*LuaCommand{{Name: name, Permission: PermissionManager}}
+}
+
+// Outranks reports whether p is on a higher tier than the named role rests
+// on, "" and none are outranked by everyone with a role at all
+func (r *Roles) Outranks(p *player.Player, name string) bool {
+	want, ok := r.lookup(name)
+	if !ok {
+		return false
+	}
+	return r.playerRole(p).tier > want.tier
+}
+
+// Assign gives p a role and the permission bits of its tier
+func (r *Roles) Assign(p *player.Player, name string) error {
+	bits, ok := r.Bits(name)
+	if !ok {
+		return fmt.Errorf("unknown role %q", name)
+	}
+
+	name = NormalizeRole(name)
+	if name == "none" {
+		name = ""
+	}
+
+	p.Lock()
+	p.Role = name
+	p.Permissions = bits
+	p.Unlock()
+	return nil
+}
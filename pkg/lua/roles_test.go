@@ -0,0 +1,101 @@
+package lua
+
+import (
+	"testing"
+
+	"github.com/siohaza/fosilo/internal/player"
+	"github.com/siohaza/fosilo/pkg/config"
+)
+
+func TestRoleCommands(t *testing.T) {
+	roles, err := NewRoles(map[string]config.RoleConfig{
+		"mapper":    {Inherits: "trusted", Allow: []string{"/savemap", "floor"}},
+		"builder":   {Inherits: "mapper", Deny: []string{"floor"}},
+		"moderator": {Deny: []string{"ban"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savemap := &LuaCommand{Name: "savemap", Permission: PermissionAdmin}
+	floor := &LuaCommand{Name: "floor", Permission: PermissionAdmin}
+	ban := &LuaCommand{Name: "ban", Permission: PermissionModerator}
+	votekick := &LuaCommand{Name: "votekick", Permission: PermissionTrusted}
+
+	cm := NewCommandManager(nil)
+	for _, cmd := range []*LuaCommand{savemap, floor, ban, votekick} {
+		cm.commands[cmd.Name] = cmd
+	}
+	cm.SetRoles(roles)
+
+	mapper := player.New(0, nil)
+	if err := roles.Assign(mapper, "mapper"); err != nil {
+		t.Fatal(err)
+	}
+	if mapper.Permissions != 1<<1 {
+		t.Errorf("mapper permission bits %b, want those of trusted", mapper.Permissions)
+	}
+	if !roles.CanRun(mapper, savemap) || !roles.CanRun(mapper, floor) || !roles.CanRun(mapper, votekick) {
+		t.Error("mapper cannot run its allowed or inherited commands")
+	}
+	if roles.CanRun(mapper, ban) {
+		t.Error("mapper can run a moderator command")
+	}
+
+	builder := player.New(1, nil)
+	if err := roles.Assign(builder, "builder"); err != nil {
+		t.Fatal(err)
+	}
+	if !roles.CanRun(builder, savemap) || roles.CanRun(builder, floor) {
+		t.Error("builder should inherit savemap and be denied floor")
+	}
+
+	mod := player.New(2, nil)
+	if err := roles.Assign(mod, "mod"); err != nil {
+		t.Fatal(err)
+	}
+	if roles.CanRun(mod, ban) {
+		t.Error("moderator can run a command denied to the tier")
+	}
+
+	admin := player.New(3, nil)
+	admin.Permissions = 1 << 4
+	if !roles.CanRun(admin, ban) {
+		t.Error("a deny on moderator reached admins")
+	}
+	if !roles.Has(mapper, "mapper") || !roles.Has(admin, "mapper") || !roles.Has(admin, "builder") {
+		t.Error("has_permission on named roles is off")
+	}
+	if roles.Has(mapper, "builder") || roles.Has(admin, "nobody") {
+		t.Error("a role was held that should not be")
+	}
+
+	if roles.Has(mod, "mapper") || roles.Has(mod, "builder") {
+		t.Error("a moderator holds a role that opens admin commands to it")
+	}
+	if roles.Has(builder, "mapper") {
+		t.Error("builder holds mapper though it is denied floor")
+	}
+
+	if !roles.Outranks(admin, "moderator") || !roles.Outranks(mod, "mapper") {
+		t.Error("a higher tier does not outrank a lower one")
+	}
+	if roles.Outranks(admin, "admin") || roles.Outranks(mod, "manager") || roles.Outranks(mapper, "builder") {
+		t.Error("a role on the same tier or above was outranked")
+	}
+}
+
+func TestRoleInheritanceErrors(t *testing.T) {
+	if _, err := NewRoles(map[string]config.RoleConfig{
+		"a": {Inherits: "b"},
+		"b": {Inherits: "a"},
+	}); err == nil {
+		t.Error("an inheritance cycle was accepted")
+	}
+	if _, err := NewRoles(map[string]config.RoleConfig{"a": {Inherits: "wizard"}}); err == nil {
+		t.Error("an unknown parent was accepted")
+	}
+	if _, err := NewRoles(map[string]config.RoleConfig{"admin": {Inherits: "manager"}}); err == nil {
+		t.Error("a built-in tier was allowed to inherit")
+	}
+}
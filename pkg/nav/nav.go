@@ -0,0 +1,327 @@
+package nav
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/siohaza/fosilo/pkg/vxl"
+)
+
+const (
+	// MaxDrop is the deepest ledge a path will step off, anything further
+	// hurts enough that players avoid it
+	MaxDrop = 3
+
+	walkCost     = 1.0
+	diagonalCost = math.Sqrt2
+	crouchFactor = 1.5
+	jumpCost     = 2.0
+	dropCost     = 0.5
+
+	defaultMaxExpansions = 1 << 17
+)
+
+// Node is a cell a player can stand in, Z is the air block at the feet with
+// solid ground right below it, the same convention as vxl.Map.FindGroundLevel
+type Node struct {
+	X, Y, Z int
+}
+
+// surface is one standable level in a column, crouch is set when there is
+// only room for a crouching player
+type surface struct {
+	z      int
+	crouch bool
+}
+
+// Graph is the walkable surface graph of a map, it keeps itself up to date
+// as blocks change and like vxl.Map it is not safe for concurrent use
+type Graph struct {
+	m       *vxl.Map
+	columns [][]surface
+	solid   []bool
+
+	// MaxExpansions caps how many nodes one search may visit before giving up
+	MaxExpansions int
+}
+
+func New(m *vxl.Map) *Graph {
+	g := &Graph{
+		m:             m,
+		columns:       make([][]surface, m.Width()*m.Height()),
+		solid:         make([]bool, m.Depth()+1),
+		MaxExpansions: defaultMaxExpansions,
+	}
+
+	for y := 0; y < m.Height(); y++ {
+		for x := 0; x < m.Width(); x++ {
+			g.columns[g.columnIndex(x, y)] = g.scanColumn(x, y, nil)
+		}
+	}
+
+	m.OnChange(g.blockChanged)
+	return g
+}
+
+func (g *Graph) columnIndex(x, y int) int {
+	return y*g.m.Width() + x
+}
+
+func (g *Graph) inBounds(x, y int) bool {
+	return x >= 0 && y >= 0 && x < g.m.Width() && y < g.m.Height()
+}
+
+// scanColumn finds every level in a column with ground below and at least
+// two blocks of air above, reusing buf when it can
+func (g *Graph) scanColumn(x, y int, buf []surface) []surface {
+	for z := range g.solid {
+		g.solid[z] = g.m.IsSolid(x, y, z)
+	}
+
+	buf = buf[:0]
+	for z := 0; z < g.m.Depth()-1; z++ {
+		if g.solid[z] || !g.solid[z+1] || (z >= 1 && g.solid[z-1]) {
+			continue
+		}
+		buf = append(buf, surface{z: z, crouch: z >= 2 && g.solid[z-2]})
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	return buf
+}
+
+// blockChanged rescans the column a block belongs to, surfaces only depend
+// on their own column so nothing else needs touching
+func (g *Graph) blockChanged(x, y, z int) {
+	if !g.inBounds(x, y) {
+		return
+	}
+	idx := g.columnIndex(x, y)
+	g.columns[idx] = g.scanColumn(x, y, g.columns[idx])
+}
+
+func (g *Graph) surfaceAt(x, y, z int) (surface, bool) {
+	if !g.inBounds(x, y) {
+		return surface{}, false
+	}
+	for _, s := range g.columns[g.columnIndex(x, y)] {
+		if s.z == z {
+			return s, true
+		}
+	}
+	return surface{}, false
+}
+
+// Walkable reports whether a player can stand with their feet at (x, y, z)
+func (g *Graph) Walkable(x, y, z int) bool {
+	_, ok := g.surfaceAt(x, y, z)
+	return ok
+}
+
+// Snap returns the standable node in column (x, y) closest in height to z
+func (g *Graph) Snap(x, y, z int) (Node, bool) {
+	if !g.inBounds(x, y) {
+		return Node{}, false
+	}
+
+	best := Node{}
+	bestDistance := math.MaxInt
+	for _, s := range g.columns[g.columnIndex(x, y)] {
+		distance := s.z - z
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < bestDistance {
+			best = Node{X: x, Y: y, Z: s.z}
+			bestDistance = distance
+		}
+	}
+	return best, bestDistance != math.MaxInt
+}
+
+type edge struct {
+	to   Node
+	cost float64
+}
+
+var directions = [8][2]int{
+	{1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{1, 1}, {1, -1}, {-1, 1}, {-1, -1},
+}
+
+// neighbors appends every node reachable in one step from n: walking level,
+// jumping up one block or dropping down at most MaxDrop blocks
+func (g *Graph) neighbors(n Node, from surface, out []edge) []edge {
+	for _, d := range directions {
+		nx, ny := n.X+d[0], n.Y+d[1]
+		if !g.inBounds(nx, ny) {
+			continue
+		}
+		diagonal := d[0] != 0 && d[1] != 0
+
+		// cutting a corner needs both side cells open at body height
+		if diagonal && (!g.open(n.X+d[0], n.Y, n.Z) || !g.open(n.X, n.Y+d[1], n.Z)) {
+			continue
+		}
+
+		for _, to := range g.columns[g.columnIndex(nx, ny)] {
+			dz := to.z - n.Z
+			var cost float64
+
+			switch {
+			case dz == 0:
+				cost = walkCost
+				if diagonal {
+					cost = diagonalCost
+				}
+				if from.crouch || to.crouch {
+					cost *= crouchFactor
+				}
+			case dz == -1 && !diagonal:
+				if from.crouch || to.crouch || g.m.IsSolid(n.X, n.Y, n.Z-3) {
+					continue
+				}
+				cost = jumpCost
+			case dz > 0 && dz <= MaxDrop && !diagonal:
+				if !g.clearDrop(nx, ny, n.Z-1, to.z) {
+					continue
+				}
+				cost = walkCost + dropCost*float64(dz)
+			default:
+				continue
+			}
+
+			out = append(out, edge{to: Node{X: nx, Y: ny, Z: to.z}, cost: cost})
+		}
+	}
+	return out
+}
+
+// open reports whether a crouching player fits with their feet at z
+func (g *Graph) open(x, y, z int) bool {
+	return !g.m.IsSolid(x, y, z) && !g.m.IsSolid(x, y, z-1)
+}
+
+func (g *Graph) clearDrop(x, y, top, bottom int) bool {
+	for z := top; z <= bottom; z++ {
+		if g.m.IsSolid(x, y, z) {
+			return false
+		}
+	}
+	return true
+}
+
+// heuristic is the octile distance, every move costs at least its flat length
+func heuristic(a, b Node) float64 {
+	dx := math.Abs(float64(a.X - b.X))
+	dy := math.Abs(float64(a.Y - b.Y))
+	return math.Max(dx, dy) + (diagonalCost-1)*math.Min(dx, dy)
+}
+
+// openEntry is a queued node, stale entries left behind when a cheaper route
+// is found are skipped when popped instead of being fixed up in place
+type openEntry struct {
+	priority float64
+	record   int32
+}
+
+type openSet []openEntry
+
+func (o openSet) Len() int           { return len(o) }
+func (o openSet) Less(i, j int) bool { return o[i].priority < o[j].priority }
+func (o openSet) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o *openSet) Push(x any)        { *o = append(*o, x.(openEntry)) }
+func (o *openSet) Pop() any {
+	old := *o
+	e := old[len(old)-1]
+	*o = old[:len(old)-1]
+	return e
+}
+
+type record struct {
+	node   Node
+	cost   float64
+	parent int32
+	closed bool
+}
+
+// FindPath runs A* between two standable nodes and returns every node along
+// the way including both ends, ok is false when there is no route or the
+// search ran past MaxExpansions
+func (g *Graph) FindPath(start, goal Node) ([]Node, bool) {
+	return g.FindPathWithin(start, goal, g.MaxExpansions)
+}
+
+// FindPathWithin is FindPath with its own expansion budget, for callers that
+// search often and would rather fail early than stall
+func (g *Graph) FindPathWithin(start, goal Node, maxExpansions int) ([]Node, bool) {
+	if !g.Walkable(start.X, start.Y, start.Z) || !g.Walkable(goal.X, goal.Y, goal.Z) {
+		return nil, false
+	}
+	if start == goal {
+		return []Node{start}, true
+	}
+
+	records := []record{{node: start, parent: -1}}
+	index := map[int]int32{g.key(start): 0}
+	open := &openSet{{priority: heuristic(start, goal), record: 0}}
+
+	var edges []edge
+	for expanded := 0; open.Len() > 0 && expanded < maxExpansions; {
+		entry := heap.Pop(open).(openEntry)
+		rec := &records[entry.record]
+		if rec.closed {
+			continue
+		}
+		rec.closed = true
+		expanded++
+
+		current := rec.node
+		if current == goal {
+			return buildPath(records, entry.record), true
+		}
+
+		from, _ := g.surfaceAt(current.X, current.Y, current.Z)
+		edges = g.neighbors(current, from, edges[:0])
+		for _, e := range edges {
+			cost := rec.cost + e.cost
+			key := g.key(e.to)
+
+			id, seen := index[key]
+			if seen {
+				next := &records[id]
+				if next.closed || cost >= next.cost {
+					continue
+				}
+				next.cost = cost
+				next.parent = entry.record
+			} else {
+				id = int32(len(records))
+				records = append(records, record{node: e.to, cost: cost, parent: entry.record})
+				index[key] = id
+				// appending may have moved the slice
+				rec = &records[entry.record]
+			}
+
+			heap.Push(open, openEntry{priority: cost + heuristic(e.to, goal), record: id})
+		}
+	}
+
+	return nil, false
+}
+
+func (g *Graph) key(n Node) int {
+	return g.columnIndex(n.X, n.Y)*g.m.Depth() + n.Z
+}
+
+func buildPath(records []record, end int32) []Node {
+	var path []Node
+	for id := end; id >= 0; id = records[id].parent {
+		path = append(path, records[id].node)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
@@ -0,0 +1,128 @@
+package nav
+
+import (
+	"testing"
+
+	"github.com/siohaza/fosilo/pkg/vxl"
+)
+
+const groundZ = 40
+
+// flatMap returns a 16x16 map with solid ground below z=39
+func flatMap(t *testing.T) *vxl.Map {
+	t.Helper()
+	m, err := vxl.NewEmpty(16, 16, 64)
+	if err != nil {
+		t.Fatalf("NewEmpty failed: %v", err)
+	}
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			m.Set(x, y, groundZ, 0x808080)
+		}
+	}
+	return m
+}
+
+// wall fills x=8 across the whole map from the ground up to height blocks
+func wall(m *vxl.Map, height int) {
+	for y := 0; y < 16; y++ {
+		for h := 1; h <= height; h++ {
+			m.Set(8, y, groundZ-h, 0x404040)
+		}
+	}
+}
+
+func TestFindPathFlat(t *testing.T) {
+	g := New(flatMap(t))
+
+	path, ok := g.FindPath(Node{2, 2, groundZ - 1}, Node{12, 2, groundZ - 1})
+	if !ok {
+		t.Fatal("expected a path across flat ground")
+	}
+	if len(path) != 11 {
+		t.Fatalf("expected a straight path of 11 nodes, got %d", len(path))
+	}
+}
+
+func TestFindPathStepsAndWalls(t *testing.T) {
+	m := flatMap(t)
+	wall(m, 1)
+	g := New(m)
+
+	start := Node{2, 2, groundZ - 1}
+	goal := Node{12, 2, groundZ - 1}
+
+	path, ok := g.FindPath(start, goal)
+	if !ok {
+		t.Fatal("expected to jump a one block wall")
+	}
+	for _, n := range path {
+		if n.X == 8 && n.Z != groundZ-2 {
+			t.Fatalf("expected to cross the wall on top of it, got %+v", n)
+		}
+	}
+
+	for y := 0; y < 16; y++ {
+		m.Set(8, y, groundZ-2, 0x404040)
+	}
+	if _, ok := g.FindPath(start, goal); ok {
+		t.Fatal("expected a two block wall to block the path")
+	}
+
+	m.SetAir(8, 5, groundZ-2)
+	m.SetAir(8, 5, groundZ-1)
+	if _, ok := g.FindPath(start, goal); !ok {
+		t.Fatal("expected the path to reopen through the dug gap")
+	}
+}
+
+func TestFindPathCrouchGap(t *testing.T) {
+	m := flatMap(t)
+	wall(m, 6)
+	// a two block high hole through the wall with the rest of it as ceiling
+	m.SetAir(8, 7, groundZ-1)
+	m.SetAir(8, 7, groundZ-2)
+	g := New(m)
+
+	if !g.Walkable(8, 7, groundZ-1) {
+		t.Fatal("expected the gap to be walkable while crouching")
+	}
+	if s, _ := g.surfaceAt(8, 7, groundZ-1); !s.crouch {
+		t.Fatal("expected the gap to require crouching")
+	}
+
+	path, ok := g.FindPath(Node{2, 7, groundZ - 1}, Node{12, 7, groundZ - 1})
+	if !ok {
+		t.Fatal("expected a path through the crouch gap")
+	}
+	crossed := false
+	for _, n := range path {
+		if n.X == 8 && n.Y == 7 {
+			crossed = true
+		}
+	}
+	if !crossed {
+		t.Fatal("expected the path to use the gap")
+	}
+}
+
+func TestFindPathDrops(t *testing.T) {
+	m := flatMap(t)
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 16; y++ {
+			for h := 1; h <= MaxDrop; h++ {
+				m.Set(x, y, groundZ-h, 0x404040)
+			}
+		}
+	}
+	g := New(m)
+
+	high := Node{2, 2, groundZ - MaxDrop - 1}
+	low := Node{12, 2, groundZ - 1}
+	if _, ok := g.FindPath(high, low); !ok {
+		t.Fatal("expected to drop off a ledge of MaxDrop blocks")
+	}
+	if _, ok := g.FindPath(low, high); ok {
+		t.Fatal("expected a ledge higher than one block to be unclimbable")
+	}
+}
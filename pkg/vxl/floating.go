@@ -0,0 +1,69 @@
+package vxl
+
+// blocks this close to the bottom of the map count as ground
+const groundLayers = 2
+
+var neighborOffsets = [6][3]int{
+	{0, 0, -1},
+	{0, 1, 0},
+	{0, -1, 0},
+	{1, 0, 0},
+	{-1, 0, 0},
+	{0, 0, 1},
+}
+
+// FloatingBlocks returns every solid block adjacent to (x, y, z) that is no
+// longer connected to the ground, meant to be called right after removing it
+func (m *Map) FloatingBlocks(x, y, z int) [][3]int {
+	var floating [][3]int
+	checked := make(map[int]struct{})
+
+	for _, off := range neighborOffsets {
+		nx, ny, nz := x+off[0], y+off[1], z+off[2]
+		if !m.hasGeometry(nx, ny, nz) {
+			continue
+		}
+		if _, ok := checked[m.geometryOffset(nx, ny, nz)]; ok {
+			continue
+		}
+
+		if component, grounded := m.floodComponent(nx, ny, nz, checked); !grounded {
+			floating = append(floating, component...)
+		}
+	}
+
+	return floating
+}
+
+// floodComponent walks the solid blocks connected to (x, y, z) depth first,
+// stepping downwards before anything else so grounded structures bail out early
+func (m *Map) floodComponent(x, y, z int, checked map[int]struct{}) ([][3]int, bool) {
+	checked[m.geometryOffset(x, y, z)] = struct{}{}
+	stack := [][3]int{{x, y, z}}
+	var component [][3]int
+
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if cur[2] >= m.depth-groundLayers {
+			return nil, true
+		}
+		component = append(component, cur)
+
+		for _, off := range neighborOffsets {
+			nx, ny, nz := cur[0]+off[0], cur[1]+off[1], cur[2]+off[2]
+			if !m.hasGeometry(nx, ny, nz) {
+				continue
+			}
+			key := m.geometryOffset(nx, ny, nz)
+			if _, ok := checked[key]; ok {
+				continue
+			}
+			checked[key] = struct{}{}
+			stack = append(stack, [3]int{nx, ny, nz})
+		}
+	}
+
+	return component, false
+}
@@ -0,0 +1,34 @@
+package vxl
+
+import (
+	"testing"
+)
+
+func TestFloatingBlocks(t *testing.T) {
+	m, err := NewEmpty(16, 16, 64)
+	if err != nil {
+		t.Fatalf("NewEmpty failed: %v", err)
+	}
+
+	for z := 50; z < 63; z++ {
+		m.Set(4, 4, z, 0xFF0000)
+	}
+	for x := 5; x < 8; x++ {
+		m.Set(x, 4, 50, 0x00FF00)
+	}
+
+	if got := m.FloatingBlocks(4, 4, 55); len(got) != 0 {
+		t.Fatalf("expected no floating blocks before removal, got %d", len(got))
+	}
+
+	m.SetAir(4, 4, 55)
+	got := m.FloatingBlocks(4, 4, 55)
+	if len(got) != 8 {
+		t.Fatalf("expected 8 floating blocks, got %d", len(got))
+	}
+	for _, b := range got {
+		if b[2] > 55 {
+			t.Errorf("grounded block %v reported as floating", b)
+		}
+	}
+}
@@ -132,6 +132,46 @@ type Map struct {
 	depth    int
 	chunks   []*chunk
 	geometry []uint64
+	// observers are told about every block placed or removed after loading
+	observers []func(x, y, z int)
+
+	// generation counts changes so serialized copies can tell they are stale
+	generation uint64
+	// columns caches what Write produced for each column, a nil entry marks
+	// a column that changed since
+	columns [][]byte
+}
+
+// OnChange registers fn to be called with the coordinates of every block
+// changed through Set, SetNoOptimize or SetAir
+func (m *Map) OnChange(fn func(x, y, z int)) {
+	m.observers = append(m.observers, fn)
+}
+
+func (m *Map) notify(x, y, z int) {
+	m.generation++
+	// whether a block is on the surface depends on the columns next to it
+	m.dirtyColumn(x, y)
+	m.dirtyColumn(x+1, y)
+	m.dirtyColumn(x-1, y)
+	m.dirtyColumn(x, y+1)
+	m.dirtyColumn(x, y-1)
+
+	for _, fn := range m.observers {
+		fn(x, y, z)
+	}
+}
+
+// Generation returns a counter that moves on with every change to the map
+func (m *Map) Generation() uint64 {
+	return m.generation
+}
+
+func (m *Map) dirtyColumn(x, y int) {
+	if m.columns == nil || x < 0 || y < 0 || x >= m.width || y >= m.height {
+		return
+	}
+	m.columns[y*m.width+x] = nil
 }
 
 func (m *Map) Width() int  { return m.width }
@@ -426,6 +466,7 @@ func (m *Map) Set(x, y, z int, color uint32) {
 	}
 
 	m.updateNeighborSurfaces(x, y, z)
+	m.notify(x, y, z)
 }
 
 func (m *Map) SetNoOptimize(x, y, z int, color uint32) {
@@ -438,6 +479,7 @@ func (m *Map) SetNoOptimize(x, y, z int, color uint32) {
 	if c := m.chunkAt(x, y); c != nil {
 		c.insert(newPosition(uint32(x), uint32(y), uint32(z)), color)
 	}
+	m.notify(x, y, z)
 }
 
 func (m *Map) updateNeighborSurfaces(x, y, z int) {
@@ -501,6 +543,8 @@ func (m *Map) SetAir(x, y, z int) {
 			}
 		}
 	}
+
+	m.notify(x, y, z)
 }
 
 func (m *Map) FindTopBlock(x, y int) int {
@@ -550,18 +594,34 @@ func (m *Map) FindGroundLevel(x, y int) int {
 	return m.depth - 1
 }
 
+// Write serializes the map, columns that haven't changed since the last call
+// are reused rather than encoded again
 func (m *Map) Write() ([]byte, error) {
-	var buf bytes.Buffer
+	if m.columns == nil {
+		m.columns = make([][]byte, m.width*m.height)
+	}
 
+	size := 0
+	var column bytes.Buffer
 	for y := 0; y < m.height; y++ {
 		for x := 0; x < m.width; x++ {
-			if err := m.writeColumn(x, y, &buf); err != nil {
-				return nil, err
+			idx := y*m.width + x
+			if m.columns[idx] == nil {
+				column.Reset()
+				if err := m.writeColumn(x, y, &column); err != nil {
+					return nil, err
+				}
+				m.columns[idx] = bytes.Clone(column.Bytes())
 			}
+			size += len(m.columns[idx])
 		}
 	}
 
-	return buf.Bytes(), nil
+	out := make([]byte, 0, size)
+	for _, data := range m.columns {
+		out = append(out, data...)
+	}
+	return out, nil
 }
 
 func (m *Map) writeColumn(x, y int, w io.Writer) error {
@@ -0,0 +1,42 @@
+package vxl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteReusesCleanColumns(t *testing.T) {
+	m, err := NewEmpty(16, 16, 64)
+	if err != nil {
+		t.Fatalf("NewEmpty failed: %v", err)
+	}
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			m.Set(x, y, 40, 0x808080)
+		}
+	}
+
+	if _, err := m.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	generation := m.Generation()
+	m.Set(5, 5, 39, 0xFF0000)
+	m.SetAir(6, 5, 40)
+	if m.Generation() == generation {
+		t.Fatal("expected changes to move the generation on")
+	}
+
+	cached, err := m.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.columns = nil
+	fresh, err := m.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, fresh) {
+		t.Fatal("incremental write differs from a full write")
+	}
+}
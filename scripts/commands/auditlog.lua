@@ -0,0 +1,69 @@
+name = "auditlog"
+aliases = "audit"
+description = "Show the latest administrative actions, optionally by or against a player"
+usage = "/auditlog [player] [n]"
+permission = "admin"
+
+local function ago(seconds)
+    if seconds < 60 then
+        return seconds .. "s"
+    elseif seconds < 3600 then
+        return math.floor(seconds / 60) .. "m"
+    elseif seconds < 86400 then
+        return math.floor(seconds / 3600) .. "h"
+    end
+    return math.floor(seconds / 86400) .. "d"
+end
+
+function execute(player, args)
+    local who = ""
+    local count = 5
+
+    local rest = {}
+    for i = 1, #args do
+        rest[i] = args[i]
+    end
+    if #rest > 0 and tonumber(rest[#rest]) and (#rest > 1 or rest[1]:sub(1,1) ~= "#") then
+        count = math.min(math.max(math.floor(tonumber(rest[#rest])), 1), 20)
+        table.remove(rest)
+    end
+
+    if #rest > 0 then
+        local target_arg = table.concat(rest, " ")
+        local target
+
+        if target_arg:sub(1,1) == "#" then
+            target = get_player(tonumber(target_arg:sub(2)) or -1)
+            if not target then
+                return "Player not found: " .. target_arg
+            end
+        else
+            target = get_player_by_name(target_arg)
+        end
+
+        -- players who left can still be looked up by name or address
+        who = target and target.name or target_arg
+    end
+
+    local entries, err = get_audit_log(who, count)
+    if not entries then
+        return "Failed to read audit log: " .. err
+    end
+    if #entries == 0 then
+        return "No audit entries"
+    end
+
+    for i = #entries, 1, -1 do
+        local e = entries[i]
+        local line = ago(e.age) .. " ago " .. e.actor .. " " .. e.action
+        if e.target ~= "" then
+            line = line .. " " .. e.target
+        end
+        if #e.args > 0 then
+            line = line .. ": " .. table.concat(e.args, " ")
+        end
+        send_chat(player.id, line)
+    end
+
+    return ""
+end
@@ -1,16 +1,92 @@
 name = "ban"
 aliases = ""
-description = "Ban a player from the server"
-usage = "/ban <player> [duration] [reason]"
+description = "Ban a player, an address range or a name pattern from the server"
+usage = "/ban <player|range|pattern> [/prefix] [duration] [reason]"
 permission = "moderator"
 
+local usage_text = "Usage: /ban <player_id> [/prefix] [duration] [reason]\n" ..
+    "       /ban <ip/prefix> [duration] [reason]\n" ..
+    "       /ban <name*pattern> [duration] [reason]\n" ..
+    "Duration examples: 1h, 24h, 7d, 30d, perm (default: 24h)\n" ..
+    "A /prefix such as /24 bans the player's whole address range"
+
+-- parses the duration at args[i] if there is one, returns the hours, how to
+-- say it and where the reason starts
+local function parse_duration(args, i)
+    local dur_arg = args[i]
+    if not dur_arg then
+        return 24, "24 hours", i
+    end
+
+    if dur_arg == "perm" or dur_arg == "permanent" then
+        return 0, "permanently", i + 1
+    elseif dur_arg:match("^%d+h$") then
+        local hours = tonumber(dur_arg:match("^(%d+)h$"))
+        return hours, hours .. " hours", i + 1
+    elseif dur_arg:match("^%d+d$") then
+        local days = tonumber(dur_arg:match("^(%d+)d$"))
+        return days * 24, days .. " days", i + 1
+    elseif dur_arg:match("^%d+m$") then
+        local minutes = tonumber(dur_arg:match("^(%d+)m$"))
+        return minutes / 60, minutes .. " minutes", i + 1
+    end
+
+    return 24, "24 hours", i
+end
+
+local function parse_reason(args, i)
+    if #args >= i then
+        return table.concat(args, " ", i)
+    end
+    return "Banned by admin"
+end
+
 function execute(player, args)
     if #args < 1 then
-        return "Usage: /ban <player_id> [duration] [reason]\nDuration examples: 1h, 24h, 7d, 30d, perm (default: 24h)"
+        return usage_text
     end
 
     local target_arg = args[1]
 
+    -- a range given outright, nobody has to be online for it
+    if target_arg:find("/", 1, true) then
+        local duration_hours, duration_str, next_arg = parse_duration(args, 2)
+        local reason = parse_reason(args, next_arg)
+
+        local success, error_msg = ban_player(target_arg, "", reason, player.name, duration_hours)
+        if not success then
+            return "Failed to ban range: " .. (error_msg or "unknown error")
+        end
+
+        for id = 0, 255 do
+            local p = get_player(id)
+            if p and is_banned(get_player_ip(id)) then
+                disconnect_player(id, 1)
+            end
+        end
+
+        return "Banned range " .. target_arg .. " " .. duration_str .. ": " .. reason
+    end
+
+    if target_arg:find("[%*%?]") then
+        local duration_hours, duration_str, next_arg = parse_duration(args, 2)
+        local reason = parse_reason(args, next_arg)
+
+        local success, error_msg = ban_name(target_arg, reason, player.name, duration_hours)
+        if not success then
+            return "Failed to ban name pattern: " .. (error_msg or "unknown error")
+        end
+
+        for id = 0, 255 do
+            local p = get_player(id)
+            if p and is_name_banned(p.name) then
+                disconnect_player(id, 1)
+            end
+        end
+
+        return "Banned names matching " .. target_arg .. " " .. duration_str .. ": " .. reason
+    end
+
     if target_arg:sub(1,1) == "#" then
         target_arg = target_arg:sub(2)
     end
@@ -28,50 +104,28 @@ function execute(player, args)
         return "Player not found: " .. args[1]
     end
 
-    local duration_hours = 24
-    local reason = "Banned by admin"
-    local duration_str = "24 hours"
-
-    if #args >= 2 then
-        local dur_arg = args[2]
-
-        if dur_arg == "perm" or dur_arg == "permanent" then
-            duration_hours = 0
-            duration_str = "permanently"
-            if #args >= 3 then
-                reason = table.concat(args, " ", 3)
-            end
-        elseif dur_arg:match("^%d+h$") then
-            duration_hours = tonumber(dur_arg:match("^(%d+)h$"))
-            duration_str = duration_hours .. " hours"
-            if #args >= 3 then
-                reason = table.concat(args, " ", 3)
-            end
-        elseif dur_arg:match("^%d+d$") then
-            local days = tonumber(dur_arg:match("^(%d+)d$"))
-            duration_hours = days * 24
-            duration_str = days .. " days"
-            if #args >= 3 then
-                reason = table.concat(args, " ", 3)
-            end
-        elseif dur_arg:match("^%d+m$") then
-            local minutes = tonumber(dur_arg:match("^(%d+)m$"))
-            duration_hours = minutes / 60
-            duration_str = minutes .. " minutes"
-            if #args >= 3 then
-                reason = table.concat(args, " ", 3)
-            end
-        else
-            reason = table.concat(args, " ", 2)
-        end
+    local next_arg = 2
+    local prefix = nil
+    if args[2] and args[2]:match("^/%d+$") then
+        prefix = args[2]
+        next_arg = 3
     end
 
+    local duration_hours, duration_str
+    duration_hours, duration_str, next_arg = parse_duration(args, next_arg)
+    local reason = parse_reason(args, next_arg)
+
     local target_ip = get_player_ip(target.id)
     if target_ip == "" then
         return "Could not get player IP"
     end
 
-    local success, error_msg = ban_player(target_ip, target.name, reason, player.name, duration_hours)
+    local ban_target = target_ip
+    if prefix then
+        ban_target = target_ip .. prefix
+    end
+
+    local success, error_msg = ban_player(ban_target, target.name, reason, player.name, duration_hours)
 
     if not success then
         return "Failed to ban player: " .. (error_msg or "unknown error")
@@ -81,5 +135,5 @@ function execute(player, args)
 
     disconnect_player(target.id, 1)
 
-    return "Banned " .. target.name .. " (" .. target_ip .. ") " .. duration_str .. ": " .. reason
+    return "Banned " .. target.name .. " (" .. ban_target .. ") " .. duration_str .. ": " .. reason
 end
@@ -46,7 +46,12 @@ function execute(player, args)
         player_name = "You"
     end
 
-    return player_name .. " connected with " .. client_name .. version_str .. os_str
+    local protocol_str = ""
+    if target.protocol_version and target.protocol_version ~= "" then
+        protocol_str = " (protocol " .. target.protocol_version .. ")"
+    end
+
+    return player_name .. " connected with " .. client_name .. version_str .. os_str .. protocol_str
 end
 
 function get_client_name(identifier)
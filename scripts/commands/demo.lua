@@ -0,0 +1,32 @@
+name = "demo"
+aliases = "record"
+description = "Start or stop recording the match to a demo file"
+usage = "/demo [start|stop]"
+permission = "admin"
+
+function execute(player, args)
+    if #args < 1 then
+        if is_recording_demo() then
+            return "Recording a demo, use /demo stop to finish it"
+        end
+        return "Not recording, use /demo start to record this match"
+    end
+
+    local action = string.lower(args[1])
+
+    if action == "start" then
+        local success, result = start_demo()
+        if not success then
+            return "Failed to start recording: " .. result
+        end
+        return "Recording to " .. result
+    elseif action == "stop" then
+        local success, result = stop_demo()
+        if not success then
+            return "Failed to stop recording: " .. result
+        end
+        return "Demo saved to " .. result
+    end
+
+    return "Usage: /demo [start|stop]"
+end
@@ -1,11 +1,37 @@
 name = "login"
 aliases = "auth"
-description = "Login with a role password to gain permissions"
-usage = "/login <role> <password>"
+description = "Login to your account or with a role password to gain permissions"
+usage = "/login <password> or /login <role> <password>"
 permission = "none"
 
+local function failed_attempt(player, message)
+    local retries = get_login_retries(player.id) + 1
+    set_login_retries(player.id, retries)
+    if retries >= 3 then
+        kick_player_cmd(player.id, "Too many failed login attempts")
+        return ""
+    end
+    return message .. " (" .. (3 - retries) .. " attempts remaining)"
+end
+
+-- the password is checked in the background, the outcome arrives in chat
+local function login_to_account(player, password)
+    local success, err = login_account(player.id, password)
+    if not success then
+        return err
+    end
+    return ""
+end
+
 function execute(player, args)
+    if #args == 1 and accounts_enabled() then
+        return login_to_account(player, args[1])
+    end
+
     if #args < 2 then
+        if accounts_enabled() then
+            return "Usage: /login <password>"
+        end
         return "Usage: /login <role> <password>"
     end
 
@@ -28,17 +54,14 @@ function execute(player, args)
     local config_password = get_config_password(role)
 
     if config_password == "" then
+        if accounts_enabled() then
+            return "Role passwords are disabled, use /login <password> with your registered name"
+        end
         return "No password set for role: " .. role
     end
 
     if password ~= config_password then
-        local retries = get_login_retries(player.id) + 1
-        set_login_retries(player.id, retries)
-        if retries >= 3 then
-            kick_player_cmd(player.id, "Too many failed login attempts")
-            return ""
-        end
-        return "Incorrect password (" .. (3 - retries) .. " attempts remaining)"
+        return failed_attempt(player, "Incorrect password")
     end
 
     set_login_retries(player.id, 0)
@@ -0,0 +1,37 @@
+name = "protect"
+aliases = ""
+description = "Toggle build protection for a map sector"
+usage = "/protect [sector]"
+permission = "admin"
+
+function execute(player, args)
+    if #args < 1 then
+        local sectors = get_protected_sectors()
+        if #sectors == 0 then
+            return "No sectors are protected"
+        end
+        return "Protected sectors: " .. table.concat(sectors, ", ")
+    end
+
+    local sector = string.upper(args[1])
+    local protected = true
+    for _, s in ipairs(get_protected_sectors()) do
+        if s == sector then
+            protected = false
+            break
+        end
+    end
+
+    local success, error_msg = set_protected(sector, protected)
+    if not success then
+        return "Failed to change protection: " .. (error_msg or "unknown error")
+    end
+
+    if protected then
+        broadcast_chat("Sector " .. sector .. " is now protected")
+    else
+        broadcast_chat("Sector " .. sector .. " is no longer protected")
+    end
+
+    return ""
+end
@@ -0,0 +1,27 @@
+name = "register"
+aliases = ""
+description = "Register the name you are using so nobody else can take it"
+usage = "/register <password> <password>"
+permission = "none"
+
+function execute(player, args)
+    if not accounts_enabled() then
+        return "Accounts are disabled on this server"
+    end
+
+    if #args < 2 then
+        return "Usage: /register <password> <password>"
+    end
+
+    if args[1] ~= args[2] then
+        return "Passwords do not match"
+    end
+
+    -- the password is hashed in the background, the outcome arrives in chat
+    local success, err = register_account(player.id, args[1])
+    if not success then
+        return "Failed to register: " .. err
+    end
+
+    return ""
+end
@@ -0,0 +1,39 @@
+name = "setrole"
+aliases = ""
+description = "Give a registered account a role"
+usage = "/setrole <account> <role|none>"
+permission = "admin"
+
+function execute(player, args)
+    if not accounts_enabled() then
+        return "Accounts are disabled on this server"
+    end
+
+    if #args < 2 then
+        return "Usage: /setrole <account> <role|none>"
+    end
+
+    local role = string.lower(args[#args])
+    local account = table.concat(args, " ", 1, #args - 1)
+
+    -- nobody hands out more than they have
+    if role ~= "none" and not has_permission(player.id, role) then
+        return "You cannot give a role above your own"
+    end
+
+    -- nor changes the role of someone on their own tier or above
+    local current = get_account_role(account)
+    if current == nil then
+        return "Failed to set role: no such account"
+    end
+    if current ~= "" and not outranks(player.id, current) then
+        return "You cannot change the role of " .. account .. ", they are not below you"
+    end
+
+    local success, err = set_account_role(account, role)
+    if not success then
+        return "Failed to set role: " .. err
+    end
+
+    return "Role of " .. account .. " set to " .. role
+end
@@ -1,28 +1,35 @@
 name = "unban"
 aliases = ""
-description = "Unban a player by IP address"
-usage = "/unban <ip_address>"
+description = "Unban an IP address, address range or name pattern"
+usage = "/unban <ip_address|ip/prefix|name*pattern>"
 permission = "admin"
 
 function execute(player, args)
     if #args < 1 then
-        return "Usage: /unban <ip_address>"
+        return "Usage: /unban <ip_address|ip/prefix|name*pattern>"
     end
 
-    local ip = args[1]
+    local target = args[1]
 
-    local was_banned = is_banned(ip)
-    if not was_banned then
-        return "IP " .. ip .. " is not banned"
+    local kind = "IP"
+    local unban = unban_ip
+    if target:find("/", 1, true) then
+        kind = "range"
+    elseif target:find("[%*%?]") then
+        kind = "name pattern"
+        unban = unban_name
     end
 
-    local success, error_msg = unban_ip(ip)
+    local success, error_msg = unban(target)
 
     if not success then
-        return "Failed to unban IP: " .. (error_msg or "unknown error")
+        if error_msg == "not banned" then
+            return "No ban on " .. kind .. " " .. target
+        end
+        return "Failed to unban " .. target .. ": " .. (error_msg or "unknown error")
     end
 
-    broadcast_chat("IP " .. ip .. " has been unbanned by " .. player.name)
+    broadcast_chat(target .. " has been unbanned by " .. player.name)
 
-    return "Successfully unbanned IP " .. ip
+    return "Successfully unbanned " .. kind .. " " .. target
 end
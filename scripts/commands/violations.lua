@@ -0,0 +1,48 @@
+name = "violations"
+aliases = "viol"
+description = "Show the violation score of a player"
+usage = "/violations <player>"
+permission = "moderator"
+
+function execute(player, args)
+    if #args < 1 then
+        return "Usage: /violations <player_id_or_name>"
+    end
+
+    local target_arg = args[1]
+
+    if target_arg:sub(1,1) == "#" then
+        target_arg = target_arg:sub(2)
+    end
+
+    local target_id = tonumber(target_arg)
+    local target
+
+    if target_id then
+        target = get_player(target_id)
+    else
+        target = get_player_by_name(target_arg)
+    end
+
+    if not target then
+        return "Player not found: " .. args[1]
+    end
+
+    local report = get_violations(target.id)
+    if not report then
+        return "No violation ledger for " .. target.name
+    end
+
+    local parts = {}
+    for category, count in pairs(report.categories) do
+        table.insert(parts, category .. " x" .. count)
+    end
+    table.sort(parts)
+
+    local summary = "none"
+    if #parts > 0 then
+        summary = table.concat(parts, ", ")
+    end
+
+    return string.format("%s: %.1f points, ladder step %d, %s", target.name, report.score, report.step, summary)
+end
@@ -0,0 +1,99 @@
+name = "murderball"
+
+capture_limit = 10
+ball_reset_time = 10.0
+
+ball_carrier = nil
+ball_drop_time = 0
+ball_home = {}
+
+function on_init()
+	set_team_score(0, 0)
+	set_team_score(1, 0)
+	ball_carrier = nil
+	ball_drop_time = 0
+
+	local x, y, z = get_intel_position(0)
+	ball_home = {x, y, z}
+
+	local config_capture_limit = get_config_value("capture_limit")
+	if config_capture_limit and config_capture_limit > 0 then
+		capture_limit = config_capture_limit
+	end
+
+	local config_reset_time = get_config_value("murderball_reset_time")
+	if config_reset_time and config_reset_time > 0 then
+		ball_reset_time = config_reset_time
+	end
+end
+
+function on_player_spawn(player)
+end
+
+function on_player_kill(killer, victim, kill_type)
+	if victim and ball_carrier == victim.id then
+		ball_carrier = nil
+	end
+end
+
+function on_player_update(player)
+	if ball_drop_time > 0 and ball_carrier == nil then
+		if get_server_time() - ball_drop_time >= ball_reset_time then
+			reset_ball()
+		end
+	end
+end
+
+function reset_ball()
+	ball_drop_time = 0
+	set_intel_position(ball_home[1], ball_home[2], ball_home[3], 0)
+	send_intel_position_packet(0, 0, ball_home[1], ball_home[2], ball_home[3])
+	broadcast_chat("The ball has been returned to the center!")
+end
+
+function on_intel_pickup(player_id, team)
+	ball_carrier = player_id
+	ball_drop_time = 0
+	return true
+end
+
+function on_intel_drop(player_id, team)
+	ball_carrier = nil
+	ball_drop_time = get_server_time()
+	return true
+end
+
+function on_intel_capture(player_id, team)
+	set_team_score(team, get_team_score(team) + 1)
+
+	local player = get_player(player_id)
+	if player then
+		local team_name = team == 0 and "Blue" or "Green"
+		broadcast_chat(player.name .. " scored a goal for " .. team_name .. "!")
+	end
+
+	ball_carrier = nil
+	ball_drop_time = 0
+
+	return true
+end
+
+function check_win_condition()
+	local team1_score = get_team_score(0)
+	local team2_score = get_team_score(1)
+
+	if team1_score >= capture_limit then
+		return true, 0
+	end
+
+	if team2_score >= capture_limit then
+		return true, 1
+	end
+
+	return false, 0
+end
+
+function should_rotate_map()
+	local won, _ = check_win_condition()
+	return won
+end
@@ -0,0 +1,116 @@
+name = "push"
+
+capture_limit = 10
+intel_return_time = 0
+
+intel_carriers = {}
+intel_drop_times = {}
+intel_home = {}
+
+function on_init()
+	set_team_score(0, 0)
+	set_team_score(1, 0)
+	intel_carriers = {[0] = nil, [1] = nil}
+	intel_drop_times = {[0] = 0, [1] = 0}
+
+	-- each intel starts on its team's checkpoint
+	for team = 0, 1 do
+		local x, y, z = get_intel_position(team)
+		intel_home[team] = {x, y, z}
+	end
+
+	local config_capture_limit = get_config_value("capture_limit")
+	if config_capture_limit and config_capture_limit > 0 then
+		capture_limit = config_capture_limit
+	end
+
+	local config_return_time = get_config_value("push_intel_return_time")
+	if config_return_time and config_return_time > 0 then
+		intel_return_time = config_return_time
+	end
+end
+
+function on_player_spawn(player)
+end
+
+function on_player_kill(killer, victim, kill_type)
+	if victim and victim.has_intel then
+		for team = 0, 1 do
+			if intel_carriers[team] == victim.id then
+				intel_carriers[team] = nil
+			end
+		end
+	end
+end
+
+function on_player_update(player)
+	-- dropped intel stays where it fell so it can be pushed further,
+	-- unless the server asks for it to go back after a while
+	if intel_return_time <= 0 then
+		return
+	end
+
+	local current_time = get_server_time()
+	for team = 0, 1 do
+		if intel_drop_times[team] > 0 and intel_carriers[team] == nil then
+			if current_time - intel_drop_times[team] >= intel_return_time then
+				return_intel(team)
+				intel_drop_times[team] = 0
+			end
+		end
+	end
+end
+
+function return_intel(team)
+	local home = intel_home[team]
+	if not home then
+		return
+	end
+
+	set_intel_position(home[1], home[2], home[3], team)
+	send_intel_position_packet(team, team, home[1], home[2], home[3])
+
+	local team_name = team == 0 and "Blue" or "Green"
+	broadcast_chat(team_name .. " intel returned to its checkpoint!")
+end
+
+function on_intel_pickup(player_id, team)
+	intel_carriers[team] = player_id
+	intel_drop_times[team] = 0
+	return true
+end
+
+function on_intel_drop(player_id, team)
+	intel_carriers[team] = nil
+	intel_drop_times[team] = get_server_time()
+	return true
+end
+
+function on_intel_capture(player_id, team)
+	set_team_score(team, get_team_score(team) + 1)
+
+	intel_carriers[1 - team] = nil
+	intel_drop_times[1 - team] = 0
+
+	return true
+end
+
+function check_win_condition()
+	local team1_score = get_team_score(0)
+	local team2_score = get_team_score(1)
+
+	if team1_score >= capture_limit then
+		return true, 0
+	end
+
+	if team2_score >= capture_limit then
+		return true, 1
+	end
+
+	return false, 0
+end
+
+function should_rotate_map()
+	local won, _ = check_win_condition()
+	return won
+end
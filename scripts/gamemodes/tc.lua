@@ -53,6 +53,12 @@ function init_territories()
 	}
 
 	territory_count = 1
+	report_territory(territories[1])
+end
+
+-- the server keeps its own copy for the bots to head for
+function report_territory(territory)
+	set_territory(territory.id, territory.x, territory.y, territory.z, territory.team)
 end
 
 function on_player_spawn(player)
@@ -137,10 +143,12 @@ function update_territory_capture(territory, player)
 		if territory.progress <= 0.0 and territory.team == 1 then
 			territory.team = nil
 			territory.progress = 0.5
+			report_territory(territory)
 			send_progress_bar(territory.id, 255, 0, 0.5)
 		elseif territory.progress >= 1.0 and territory.team == 0 then
 			territory.team = nil
 			territory.progress = 0.5
+			report_territory(territory)
 			send_progress_bar(territory.id, 255, 0, 0.5)
 		else
 			send_progress_bar(territory.id, team, 1, territory.progress)
@@ -150,6 +158,7 @@ end
 
 function capture_territory(territory, team, player_id)
 	territory.team = team
+	report_territory(territory)
 
 	local score = get_team_score(team)
 	set_team_score(team, score + 1)